@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/lambrospetrou/spito/metrics"
+	"github.com/lambrospetrou/spito/spit"
+)
+
+// newAdminMux() serves the internal endpoints on the admin listener (SPITO_ADMIN_ADDR),
+// which should not be reachable from the internet.
+func newAdminMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	return mux
+}
+
+func registerSweeperMetrics() {
+	metrics.CounterFunc("sweeper_runs_total", "Runs of the expiration sweeper.", func() float64 {
+		return float64(spit.GetSweeperStats().Runs)
+	})
+	metrics.CounterFunc("sweeper_skipped_total", "Sweeper runs skipped since another instance holds the lease.", func() float64 {
+		return float64(spit.GetSweeperStats().Skipped)
+	})
+	metrics.CounterFunc("sweeper_reclaimed_total", "Expired spits deleted by the sweeper.", func() float64 {
+		return float64(spit.GetSweeperStats().Reclaimed)
+	})
+	metrics.CounterFunc("sweeper_errors_total", "Failed sweeper runs.", func() float64 {
+		return float64(spit.GetSweeperStats().Errors)
+	})
+}
+
+func registerRescreenerMetrics() {
+	metrics.CounterFunc("rescreener_runs_total", "Runs of the URL rescreener.", func() float64 {
+		return float64(spit.GetRescreenerStats().Runs)
+	})
+	metrics.CounterFunc("rescreener_skipped_total", "Rescreener runs skipped since another instance holds the lease.", func() float64 {
+		return float64(spit.GetRescreenerStats().Skipped)
+	})
+	metrics.CounterFunc("rescreener_screened_total", "URL spits screened again by the rescreener.", func() float64 {
+		return float64(spit.GetRescreenerStats().Screened)
+	})
+	metrics.CounterFunc("rescreener_disabled_total", "Malicious URL spits disabled by the rescreener.", func() float64 {
+		return float64(spit.GetRescreenerStats().Disabled)
+	})
+	metrics.CounterFunc("rescreener_errors_total", "Failed rescreener runs and screenings.", func() float64 {
+		return float64(spit.GetRescreenerStats().Errors)
+	})
+}
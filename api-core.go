@@ -2,18 +2,24 @@ package main
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 
 	"github.com/lambrospetrou/spito/spit"
+	"github.com/lambrospetrou/spito/tracing"
 )
 
 const (
 	MAX_FORM_SIZE int64 = 1 << 17 // 128KB
+	// the user agents recorded for the moderators are cut to this length
+	MAX_USER_AGENT_LENGTH int = 256
 
 	CONTENT_TYPE_MULTIPART  string = "multipart/form-data"
 	CONTENT_TYPE_URLENCODED string = "application/x-www-form-urlencoded"
+
+	PASSWORD_HEADER    string = "X-Spit-Password"
+	PASSWORD_CHALLENGE string = `SpitPassword realm="spito", header="X-Spit-Password"`
 )
 
 var CORSAllowedOrigins map[string]bool = map[string]bool{
@@ -42,7 +48,8 @@ type ErrCoreAddDB struct {
 }
 
 func (e *ErrCoreAddDB) Error() string {
-	return fmt.Sprintf("ErrCoreAddDB: %v\nSpit: %v", e.Message, e.NewSpit)
+	// the spit itself is left out since its content must never reach the logs
+	return fmt.Sprintf("ErrCoreAddDB: %v", e.Message)
 }
 
 // CoreAddMultiSpit does the core execution of a new spit addition.
@@ -50,7 +57,11 @@ func (e *ErrCoreAddDB) Error() string {
 // returns either the Spit successfully added and saved
 // or an error ErrCoreAddDB if something went wrong during the creation or save of the spit
 // or an error ErrCoreAdd when the validation of the request arguments failed
-func CoreAddMultiSpit(r *http.Request) (*spit.Spit, error) {
+func CoreAddMultiSpit(r *http.Request) (_ *spit.Spit, err error) {
+	ctx, span := tracing.Start(r.Context(), "CoreAddMultiSpit")
+	defer func() { tracing.End(span, err) }()
+	r = r.WithContext(ctx)
+
 	result := &ErrCoreAdd{}
 
 	requestType := r.Header.Get("content-type")
@@ -85,16 +96,52 @@ func CoreAddMultiSpit(r *http.Request) (*spit.Spit, error) {
 			result.Errors = spitErr.ErrorsMap
 			return nil, result
 		} else {
-			log.Fatal(err.Error())
+			slog.ErrorContext(r.Context(), "could not create spit", "err", err)
 			return nil, err
 		}
 	}
 	//log.Printf("%v\n", nSpit)
 
+	nSpit.OwnerId = requestOwner(r)
+	nSpit.CreatorIP = clientIP(r)
+	nSpit.CreatorUserAgent = r.UserAgent()
+	if len(nSpit.CreatorUserAgent) > MAX_USER_AGENT_LENGTH {
+		nSpit.CreatorUserAgent = nSpit.CreatorUserAgent[:MAX_USER_AGENT_LENGTH]
+	}
+
+	// spits created in a workspace are shared with its members
+	workspaceId := strings.TrimSpace(r.FormValue("workspace"))
+	slug := strings.TrimSpace(r.FormValue("slug"))
+	if len(workspaceId) > 0 {
+		if _, err := spit.LoadWorkspace(r.Context(), workspaceId, nSpit.OwnerId, spit.ROLE_EDITOR); err != nil {
+			if err != spit.ErrNotMember && err != spit.ErrRoleForbidden {
+				slog.ErrorContext(r.Context(), "could not load workspace", "err", err, "workspace", workspaceId)
+				return nil, err
+			}
+			result.Errors = map[string]string{"Workspace": err.Error()}
+			return nil, result
+		}
+		nSpit.WorkspaceId = workspaceId
+		if len(slug) > 0 {
+			if !spit.ValidateSlug(slug) {
+				result.Errors = map[string]string{"Slug": spit.ErrInvalidSlug.Error()}
+				return nil, result
+			}
+			nSpit.Slug = spit.WorkspaceSlug(workspaceId, slug)
+		}
+	} else if len(slug) > 0 {
+		result.Errors = map[string]string{"Slug": "Slugs are only available in workspaces"}
+		return nil, result
+	}
+
 	// Save the spit
-	if err = nSpit.Save(); err != nil {
+	if err = nSpit.Save(r.Context()); err != nil {
+		if err == spit.ErrSlugTaken {
+			result.Errors = map[string]string{"Slug": err.Error()}
+			return nil, result
+		}
 		errDB := &ErrCoreAddDB{NewSpit: nSpit, Message: "Could not save spit in database!"}
-		log.Printf("%s, %v", err.Error(), errDB)
+		slog.ErrorContext(r.Context(), "could not save spit", "err", err, "spit", nSpit)
 		return nil, errDB
 	}
 	return nSpit, nil
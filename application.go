@@ -1,20 +1,29 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"html/template"
+	"log/slog"
 	"net/http"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/pat"
+	"github.com/lambrospetrou/spito/metrics"
 	"github.com/lambrospetrou/spito/spit"
+	"github.com/lambrospetrou/spito/tracing"
+	"github.com/lambrospetrou/spito/urlpolicy"
+	"github.com/lambrospetrou/spito/utils"
 )
 
 type APIResultError struct {
-	Errors []string `json:"errors"`
+	Errors    []string `json:"errors"`
+	RequestId string   `json:"request_id,omitempty"`
 }
 
 type APIAddResult struct {
@@ -22,9 +31,14 @@ type APIAddResult struct {
 	Content        string `json:"content"`
 	SpitType       string `json:"spit_type"`
 	DateCreated    string `json:"date_created"`
-	DateExpiration string `json:"date_expiration"`
+	DateExpiration string `json:"date_expiration,omitempty"`
+	Expires        bool   `json:"expires"`
 	IsURL          bool   `json:"is_url"`
 	AbsoluteURL    string `json:"absolute_url"`
+	Protected      bool   `json:"protected"`
+	Encryption     string `json:"encryption,omitempty"`
+	MaxViews       int    `json:"max_views,omitempty"`
+	Workspace      string `json:"workspace,omitempty"`
 
 	Message string `json:"message"`
 }
@@ -33,13 +47,54 @@ type APIViewResult struct {
 	Content        string `json:"content"`
 	SpitType       string `json:"spit_type"`
 	DateCreated    string `json:"date_created"`
-	DateExpiration string `json:"date_expiration"`
+	DateExpiration string `json:"date_expiration,omitempty"`
+	Expires        bool   `json:"expires"`
 	IsURL          bool   `json:"is_url"`
 	AbsoluteURL    string `json:"absolute_url"`
 	Clicks         uint64 `json:"clicks"`
+	Protected      bool   `json:"protected"`
+	Encryption     string `json:"encryption,omitempty"`
+	MaxViews       int    `json:"max_views,omitempty"`
+	ViewsLeft      int    `json:"views_left,omitempty"`
 
 	Message string `json:"message"`
 }
+type APIStatsResult struct {
+	Id             string `json:"id"`
+	SpitType       string `json:"spit_type"`
+	DateCreated    string `json:"date_created"`
+	DateExpiration string `json:"date_expiration,omitempty"`
+	Expires        bool   `json:"expires"`
+	Clicks         uint64 `json:"clicks"`
+	Protected      bool   `json:"protected"`
+	Encryption     string `json:"encryption,omitempty"`
+	MaxViews       int    `json:"max_views,omitempty"`
+	ViewsLeft      int    `json:"views_left,omitempty"`
+	Workspace      string `json:"workspace,omitempty"`
+	Disabled       string `json:"disabled,omitempty"`
+}
+
+type APIListSpit struct {
+	Id             string `json:"id"`
+	Content        string `json:"content"`
+	SpitType       string `json:"spit_type"`
+	DateCreated    string `json:"date_created"`
+	DateExpiration string `json:"date_expiration,omitempty"`
+	Expires        bool   `json:"expires"`
+	IsURL          bool   `json:"is_url"`
+	AbsoluteURL    string `json:"absolute_url"`
+	Clicks         uint64 `json:"clicks"`
+	Protected      bool   `json:"protected"`
+	Encryption     string `json:"encryption,omitempty"`
+	MaxViews       int    `json:"max_views,omitempty"`
+	ViewsLeft      int    `json:"views_left,omitempty"`
+	Workspace      string `json:"workspace,omitempty"`
+	Owner          string `json:"owner,omitempty"`
+}
+type APIListResult struct {
+	Spits      []*APIListSpit `json:"spits"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
 
 func requireSpitID(fn func(http.ResponseWriter, *http.Request, string)) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -49,6 +104,7 @@ func requireSpitID(fn func(http.ResponseWriter, *http.Request, string)) http.Han
 			http.Error(w, "Invalid Spit id.", http.StatusBadRequest)
 			return
 		}
+		logSpitID(r, id)
 		fn(w, r, id)
 	}
 }
@@ -59,6 +115,12 @@ func apiAddHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !allowAnonymous && len(requestOwner(r)) == 0 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="spito"`)
+		writeAPIError(w, r, http.StatusUnauthorized, "Log in or use an API key to create spits")
+		return
+	}
+
 	s, err := CoreAddMultiSpit(r)
 
 	if err != nil {
@@ -70,45 +132,36 @@ func apiAddHandler(w http.ResponseWriter, r *http.Request) {
 					errorList = append(errorList, v)
 				}
 			}
-			result := &APIResultError{Errors: errorList}
-			b, e := json.Marshal(result)
-			if e != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-
-			log.Println("application::apiAddHandler():: ", b)
-
-			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write(b)
+			slog.InfoContext(r.Context(), "invalid spit", "errors", errorList)
+			writeAPIError(w, r, http.StatusBadRequest, errorList...)
 			return
 		} else if errDB, ok := err.(*ErrCoreAddDB); ok {
-			log.Printf("application::apiAddHandler()::Internal error: %v", errDB)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "could not add spit", "err", errDB, "spit", errDB.NewSpit)
+			writeInternalError(w, r)
 			return
 		} else {
 			// other internal error
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			log.Fatalf("application::apiAddHandler()::Unknown Error: %v", err)
+			slog.ErrorContext(r.Context(), "could not add spit", "err", err)
+			writeInternalError(w, r)
 			return
-
 		}
 	}
 
 	// we are good to go - spit added successfully
 	result := &APIAddResult{
 		Id: s.Id, Content: s.Content, SpitType: s.SpitType,
-		DateCreated: s.DateCreated, DateExpiration: s.DateExpiration, IsURL: spit.IsUrl(s),
-		AbsoluteURL: spit.AbsoluteUrl(s), Message: "Successfully added new Spit!",
+		DateCreated: s.DateCreated, DateExpiration: s.ExpirationDate(), Expires: s.Expires(),
+		IsURL: spit.IsUrl(s), AbsoluteURL: spit.AbsoluteUrl(s), Protected: s.IsProtected(), Encryption: s.Encryption,
+		MaxViews: s.MaxViews, Workspace: s.WorkspaceId, Message: "Successfully added new Spit!",
 	}
 	b, err := json.Marshal(result)
 	if err != nil {
-		log.Printf("application::apiAddHandler()::Internal error while marshalling spit: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "could not marshal spit", "err", err, "spit", s)
+		writeInternalError(w, r)
 		return
 	}
-	log.Println("application::apiAddHandler():: ", b)
+	logSpitID(r, s.Id)
+	metrics.Spits.WithLabelValues(metrics.ACTION_CREATE, s.SpitType).Inc()
 
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.WriteHeader(http.StatusOK)
@@ -118,34 +171,230 @@ func apiAddHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func apiViewHandler(w http.ResponseWriter, r *http.Request, id string) {
-	log.Println("application::apiViewHandler():: ", id)
-
 	// fetch the Spit with the requested id
-	s, err := spit.Load(id)
+	s, err := spit.LoadWithPassword(r.Context(), id, requestPassword(r))
 	if err != nil {
-		http.NotFound(w, r)
+		switch err {
+		case spit.ErrPasswordRequired, spit.ErrPasswordWrong:
+			w.Header().Set("WWW-Authenticate", PASSWORD_CHALLENGE)
+			writeAPIError(w, r, http.StatusUnauthorized, err.Error())
+		case spit.ErrPasswordAttempts:
+			writeAPIError(w, r, http.StatusTooManyRequests, err.Error())
+		case spit.ErrSpitRemoved:
+			writeAPIError(w, r, http.StatusGone, err.Error())
+		case spit.ErrSpitUnavailableLegal:
+			writeAPIError(w, r, http.StatusUnavailableForLegalReasons, err.Error())
+		default:
+			if _, notFound := err.(spit.DynamoDbItemNotFoundError); notFound {
+				writeAPIError(w, r, http.StatusNotFound, "Spit not found")
+				return
+			}
+			slog.ErrorContext(r.Context(), "could not load spit", "err", err, "spit_id", id)
+			writeInternalError(w, r)
+		}
 		return
 	}
 
 	// we are good to go - spit fetched successfully
 	result := &APIViewResult{
-		Id: s.IdHashOnly(), Content: s.Content, SpitType: s.SpitType,
-		DateCreated: s.DateCreated, DateExpiration: s.DateExpiration, IsURL: spit.IsUrl(s),
-		AbsoluteURL: spit.AbsoluteUrl(s), Clicks: s.MetricClicks,
+		Id: s.Id, Content: s.Content, SpitType: s.SpitType,
+		DateCreated: s.DateCreated, DateExpiration: s.ExpirationDate(), Expires: s.Expires(),
+		IsURL: spit.IsUrl(s), AbsoluteURL: spit.AbsoluteUrl(s), Clicks: s.MetricClicks,
+		Protected: s.IsProtected(), Encryption: s.Encryption, MaxViews: s.MaxViews, ViewsLeft: s.ViewsLeft,
 		Message: "Successfully fetched Spit!",
 	}
 	b, err := json.Marshal(result)
 	if err != nil {
-		log.Printf("apiViewHandler::Internal error while marshalling spit: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "could not marshal spit", "err", err, "spit", s)
+		writeInternalError(w, r)
 		return
 	}
+	metrics.Spits.WithLabelValues(metrics.ACTION_VIEW, s.SpitType).Inc()
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.WriteHeader(http.StatusOK)
 	w.Write(b)
 	return
 }
 
+// apiListHandler() returns the Spits of the owner, or of a workspace of the owner,
+// newest first, a page at a time
+func apiListHandler(w http.ResponseWriter, r *http.Request) {
+	workspaceId := strings.TrimSpace(r.FormValue("workspace"))
+	if owner := r.FormValue("owner"); (owner != "me") == (len(workspaceId) == 0) {
+		writeAPIError(w, r, http.StatusBadRequest, "Specify either owner=me or workspace")
+		return
+	}
+	spitType := strings.TrimSpace(r.FormValue("type"))
+	if len(spitType) > 0 && !spit.ActiveSpitTypes[spitType] {
+		writeAPIError(w, r, http.StatusBadRequest, "Invalid spit type specified")
+		return
+	}
+	limit := 0
+	if l := r.FormValue("limit"); len(l) > 0 {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit <= 0 {
+			writeAPIError(w, r, http.StatusBadRequest, "Invalid limit specified")
+			return
+		}
+	}
+
+	var spits []*spit.Spit
+	var next string
+	var err error
+	if len(workspaceId) > 0 {
+		if _, err := spit.LoadWorkspace(r.Context(), workspaceId, requestOwner(r), spit.ROLE_VIEWER); err != nil {
+			writeWorkspaceError(w, r, err)
+			return
+		}
+		spits, next, err = spit.ListByWorkspace(r.Context(), workspaceId, spitType, r.FormValue("cursor"), limit)
+	} else {
+		spits, next, err = spit.ListByOwner(r.Context(), requestOwner(r), spitType, r.FormValue("cursor"), limit)
+	}
+	if err != nil {
+		if err == spit.ErrInvalidCursor {
+			writeAPIError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		slog.ErrorContext(r.Context(), "could not list spits", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+
+	result := &APIListResult{Spits: make([]*APIListSpit, 0, len(spits)), NextCursor: next}
+	for _, s := range spits {
+		result.Spits = append(result.Spits, &APIListSpit{
+			Id: s.Id, Content: s.Content, SpitType: s.SpitType,
+			DateCreated: s.DateCreated, DateExpiration: s.ExpirationDate(), Expires: s.Expires(),
+			IsURL: spit.IsUrl(s), AbsoluteURL: spit.AbsoluteUrl(s), Clicks: s.MetricClicks,
+			Protected: s.IsProtected(), Encryption: s.Encryption, MaxViews: s.MaxViews, ViewsLeft: s.ViewsLeft,
+			Workspace: s.WorkspaceId, Owner: s.OwnerId,
+		})
+	}
+	b, err := json.Marshal(result)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not marshal spits", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+// apiStatsHandler() returns the counters of the Spit to its owner or the members of its workspace,
+// without its content and without counting a click.
+func apiStatsHandler(w http.ResponseWriter, r *http.Request, id string) {
+	s, err := spit.LoadOwned(r.Context(), id, requestOwner(r), spit.ROLE_VIEWER)
+	if err != nil {
+		// other owners cannot even tell whether the Spit exists
+		http.NotFound(w, r)
+		return
+	}
+	result := &APIStatsResult{
+		Id: s.Id, SpitType: s.SpitType, DateCreated: s.DateCreated,
+		DateExpiration: s.ExpirationDate(), Expires: s.Expires(), Clicks: s.MetricClicks,
+		Protected: s.IsProtected(), Encryption: s.Encryption, MaxViews: s.MaxViews, ViewsLeft: s.ViewsLeft,
+		Workspace: s.WorkspaceId, Disabled: s.Disabled,
+	}
+	b, err := json.Marshal(result)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not marshal spit stats", "err", err, "spit", s)
+		writeInternalError(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+// apiEditHandler() changes the content or the expiration of a Spit of the owner,
+// or of a workspace where the owner is at least an editor
+func apiEditHandler(w http.ResponseWriter, r *http.Request, id string) {
+	s, err := spit.LoadOwned(r.Context(), id, requestOwner(r), spit.ROLE_EDITOR)
+	if err != nil {
+		if err == spit.ErrRoleForbidden {
+			writeAPIError(w, r, http.StatusForbidden, err.Error())
+			return
+		}
+		http.NotFound(w, r)
+		return
+	}
+	// the moderators disabled it, editing it would not bring it back
+	if err := s.DisabledError(); err != nil {
+		writeAPIError(w, r, http.StatusForbidden, err.Error())
+		return
+	}
+	if err := spit.EditFromRequest(r, s); err != nil {
+		errorList := make([]string, 0)
+		if spitErr, ok := err.(*spit.SpitError); ok {
+			for _, v := range spitErr.ErrorsMap {
+				errorList = append(errorList, v)
+			}
+		}
+		writeAPIError(w, r, http.StatusBadRequest, errorList...)
+		return
+	}
+	if err := spit.Update(r.Context(), s); err != nil {
+		if _, notFound := err.(spit.DynamoDbItemNotFoundError); notFound {
+			http.NotFound(w, r)
+			return
+		}
+		slog.ErrorContext(r.Context(), "could not update spit", "err", err, "spit", s)
+		writeInternalError(w, r)
+		return
+	}
+
+	result := &APIViewResult{
+		Id: s.Id, Content: s.Content, SpitType: s.SpitType,
+		DateCreated: s.DateCreated, DateExpiration: s.ExpirationDate(), Expires: s.Expires(),
+		IsURL: spit.IsUrl(s), AbsoluteURL: spit.AbsoluteUrl(s), Clicks: s.MetricClicks,
+		Protected: s.IsProtected(), Encryption: s.Encryption, MaxViews: s.MaxViews, ViewsLeft: s.ViewsLeft,
+		Message: "Successfully edited Spit!",
+	}
+	b, err := json.Marshal(result)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not marshal spit", "err", err, "spit", s)
+		writeInternalError(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+// apiDeleteHandler() deletes a Spit of the owner, or of a workspace where the owner is at least an editor
+func apiDeleteHandler(w http.ResponseWriter, r *http.Request, id string) {
+	if err := spit.DeleteOwned(r.Context(), id, requestOwner(r)); err != nil {
+		if err == spit.ErrRoleForbidden {
+			writeAPIError(w, r, http.StatusForbidden, err.Error())
+			return
+		}
+		if _, notFound := err.(spit.DynamoDbItemNotFoundError); notFound || err == spit.ErrNotOwner {
+			http.NotFound(w, r)
+			return
+		}
+		slog.ErrorContext(r.Context(), "could not delete spit", "err", err, "spit_id", id)
+		writeInternalError(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// webSlugRedirectHandler() resolves the links of the workspaces, e.g. /acme/q3-report,
+// to the Spit they point to
+func webSlugRedirectHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := spit.ResolveSlug(r.Context(), r.URL.Query().Get(":workspace"), r.URL.Query().Get(":slug"))
+	if err != nil {
+		if _, notFound := err.(spit.DynamoDbItemNotFoundError); !notFound {
+			slog.ErrorContext(r.Context(), "could not resolve slug", "err", err)
+		}
+		http.NotFound(w, r)
+		return
+	}
+	logSpitID(r, id)
+	webRedirectHandler(w, r, id)
+}
+
 // webRedirectHandler() tries to find the Spit with the passed ID and either redirects to it
 // if it is a URL or it goes to the Spit viewer
 func webRedirectHandler(w http.ResponseWriter, r *http.Request, id string) {
@@ -164,11 +413,41 @@ func webRedirectHandler(w http.ResponseWriter, r *http.Request, id string) {
 	}
 
 	// fetch the Spit with the requested id
-	s, err := spit.Load(id)
+	s, err := spit.Peek(r.Context(), id, r.FormValue("password"))
 	if err != nil {
-		http.NotFound(w, r)
+		switch err {
+		case spit.ErrPasswordRequired:
+			servePasswordPrompt(w, id, "", http.StatusUnauthorized)
+		case spit.ErrPasswordWrong:
+			servePasswordPrompt(w, id, err.Error(), http.StatusUnauthorized)
+		case spit.ErrPasswordAttempts:
+			servePasswordPrompt(w, id, err.Error(), http.StatusTooManyRequests)
+		case spit.ErrSpitRemoved:
+			http.Error(w, err.Error(), http.StatusGone)
+		case spit.ErrSpitUnavailableLegal:
+			http.Error(w, err.Error(), http.StatusUnavailableForLegalReasons)
+		default:
+			http.NotFound(w, r)
+		}
 		return
 	}
+	// the policy might have changed since the Spit was created
+	if spit.IsUrl(s) {
+		if err := urlpolicy.Check(s.Content); err != nil {
+			slog.InfoContext(r.Context(), "refused to redirect", "reason", err)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+	// text Spits with limited views only consume a view when the app fetches their content
+	if spit.IsUrl(s) || s.MaxViews == 0 {
+		if s, err = spit.Load(r.Context(), id); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	metrics.Spits.WithLabelValues(metrics.ACTION_REDIRECT, s.SpitType).Inc()
 
 	// check if this Spit is a URL that we should redirect to
 	if spit.IsUrl(s) {
@@ -181,11 +460,51 @@ func webRedirectHandler(w http.ResponseWriter, r *http.Request, id string) {
 		http.Redirect(w, r, s.Content, http.StatusMovedPermanently)
 		return
 	}
+	// the key of encrypted Spits is in the fragment of the original URL which the browser
+	// only keeps if the redirect location does not have its own fragment, the web app
+	// decrypts them on ?view=<id> (see the README)
+	if s.IsEncrypted() {
+		http.Redirect(w, r, WEB_APP_URL+"?view="+id, http.StatusFound)
+		return
+	}
 	// this is a text Spit so display it inside the app
 	http.Redirect(w, r, WEB_APP_URL+"#/view/"+id, http.StatusFound)
 	return
 }
 
+var passwordPromptTemplate = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Spito - Protected Spit</title></head>
+<body>
+	<form method="POST" action="/{{.Id}}">
+		<p>This Spit is password protected.</p>
+		{{if .Error}}<p>{{.Error}}</p>{{end}}
+		<input type="password" name="password" autofocus>
+		<button type="submit">Open</button>
+	</form>
+</body>
+</html>
+`))
+
+// servePasswordPrompt() renders a minimal form asking for the password of the Spit
+func servePasswordPrompt(w http.ResponseWriter, id string, errMsg string, status int) {
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.WriteHeader(status)
+	err := passwordPromptTemplate.Execute(w, struct{ Id, Error string }{id, errMsg})
+	if err != nil {
+		slog.Error("could not render the password prompt", "err", err, "spit_id", id)
+	}
+}
+
+// requestPassword() returns the Spit password from the header or the form values
+func requestPassword(r *http.Request) string {
+	if password := r.Header.Get(PASSWORD_HEADER); len(password) > 0 {
+		return password
+	}
+	return r.FormValue("password")
+}
+
 func limitSizeHandler(fn func(http.ResponseWriter, *http.Request),
 	size int64) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -209,8 +528,8 @@ func CORSEnable(fn http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if CORSAllowedOrigins[r.Header.Get("Origin")] {
 			w.Header().Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "X-Spito, Content-type")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "X-Spito, X-Spit-Password, Authorization, Content-type")
 			w.Header().Set("Access-Control-Max-Age", "1728000")
 		}
 		fn(w, r)
@@ -222,34 +541,151 @@ func OKHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
+	utils.SetupLogging()
+
+	// administrative commands instead of the server, e.g. `spito enable-ttl`
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	// Beanstalk will pass the PORT number as env variable.
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "40090"
 	}
-	log.Println("Starting Spito at: ", port)
+	slog.Info("starting spito", "port", port)
 
 	// use all the available cores
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
+	// run on shutdown after the in-flight requests are drained
+	shutdownHooks := make([]func(ctx context.Context), 0)
+
+	shutdownTracing, err := tracing.Init(context.Background())
+	if err != nil {
+		slog.Error("could not set up tracing", "err", err)
+		os.Exit(1)
+	}
+	shutdownHooks = append(shutdownHooks, func(ctx context.Context) {
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error("could not flush the traces", "err", err)
+		}
+	})
+
+	// connect to the storage and load the ID generators
+	spit.Init()
+
+	// delete the expired spits in the background, 0 disables the sweeper
+	if sweepInterval := utils.EnvDuration("SPITO_SWEEP_INTERVAL", 10*time.Minute); sweepInterval > 0 {
+		stopSweeper := spit.StartSweeper(sweepInterval, utils.EnvInt("SPITO_SWEEP_BATCH", 100))
+		shutdownHooks = append(shutdownHooks, func(ctx context.Context) { stopSweeper() })
+	}
+	registerSweeperMetrics()
+
+	// the blocked and allowed destinations of the URL spits, reloaded when the file changes
+	if policyPath := utils.EnvString("SPITO_URL_POLICY_FILE", ""); len(policyPath) > 0 {
+		stopPolicy, err := urlpolicy.WatchFile(policyPath, utils.EnvDuration("SPITO_URL_POLICY_RELOAD", 10*time.Second))
+		if err != nil {
+			slog.Error("could not load the URL policy", "path", policyPath, "err", err)
+			os.Exit(1)
+		}
+		shutdownHooks = append(shutdownHooks, func(ctx context.Context) { stopPolicy() })
+	}
+
+	// screen the URLs of the new spits and, periodically, of the existing ones
+	stopScreening, err := setupScreening()
+	if err != nil {
+		slog.Error("could not set up the URL screening", "err", err)
+		os.Exit(1)
+	}
+	shutdownHooks = append(shutdownHooks, func(ctx context.Context) { stopScreening() })
+	registerRescreenerMetrics()
+
+	limits, err := newRateLimits()
+	if err != nil {
+		slog.Error("invalid rate limits", "err", err)
+		os.Exit(1)
+	}
+	shutdownHooks = append(shutdownHooks, func(ctx context.Context) { limits.close() })
+	shutdownHooks = append(shutdownHooks, func(ctx context.Context) { domainJobs.shutdown() })
+
+	if err := setupSSO(context.Background()); err != nil {
+		slog.Error("could not set up the OpenID Connect login", "err", err)
+		os.Exit(1)
+	}
+	if staffRoles, err = parseStaffRoles(utils.EnvString("SPITO_STAFF", "")); err != nil {
+		slog.Error("invalid staff roles", "err", err)
+		os.Exit(1)
+	}
+
 	router := pat.New()
 
 	/////////////////
 	// API ROUTERS
 	/////////////////
 
-	router.Add("OPTIONS", "/", CORSEnable(OKHandler))
-
-	router.Get("/api/v1/spits/{id}", CORSEnable(requireSpitID(apiViewHandler)))
-	router.Post("/api/v1/spits", CORSEnable(limitSizeHandler(apiAddHandler, MAX_FORM_SIZE)))
+	router.Add("OPTIONS", "/", route("OPTIONS /", CORSEnable(OKHandler)))
+
+	// the routes match by prefix so /stats has to come before /{id}
+	router.Get("/api/v1/spits/{id}/stats", route("GET /api/v1/spits/{id}/stats",
+		CORSEnable(limits.limit("view", limits.view, requireOwner(requireSpitID(apiStatsHandler))))))
+	router.Get("/api/v1/spits/{id}", route("GET /api/v1/spits/{id}",
+		CORSEnable(limits.limit("view", limits.view, requireSpitID(apiViewHandler)))))
+	router.Add("PATCH", "/api/v1/spits/{id}", route("PATCH /api/v1/spits/{id}",
+		CORSEnable(limits.limit("edit", limits.create, requireOwner(limitSizeHandler(requireSpitID(apiEditHandler), MAX_FORM_SIZE))))))
+	router.Delete("/api/v1/spits/{id}", route("DELETE /api/v1/spits/{id}",
+		CORSEnable(limits.limit("edit", limits.create, requireOwner(requireSpitID(apiDeleteHandler))))))
+	router.Post("/api/v1/spits", route("POST /api/v1/spits",
+		CORSEnable(limits.limit("create", limits.create, authenticated(limitSizeHandler(apiAddHandler, MAX_FORM_SIZE))))))
+	// after /{id} since it is a prefix of it
+	router.Get("/api/v1/spits", route("GET /api/v1/spits",
+		CORSEnable(limits.limit("view", limits.view, requireOwner(apiListHandler)))))
+
+	router.Put("/api/v1/workspaces/{workspace}/members/{member}", route("PUT /api/v1/workspaces/{workspace}/members/{member}",
+		CORSEnable(limits.limit("edit", limits.create, requireOwner(requireWorkspaceID(apiSetMemberHandler))))))
+	router.Delete("/api/v1/workspaces/{workspace}/members/{member}", route("DELETE /api/v1/workspaces/{workspace}/members/{member}",
+		CORSEnable(limits.limit("edit", limits.create, requireOwner(requireWorkspaceID(apiRemoveMemberHandler))))))
+	router.Get("/api/v1/workspaces/{workspace}", route("GET /api/v1/workspaces/{workspace}",
+		CORSEnable(limits.limit("view", limits.view, requireOwner(requireWorkspaceID(apiViewWorkspaceHandler))))))
+	router.Post("/api/v1/workspaces", route("POST /api/v1/workspaces",
+		CORSEnable(limits.limit("create", limits.create, requireOwner(limitSizeHandler(apiAddWorkspaceHandler, MAX_FORM_SIZE))))))
+
+	// moderation, not CORS enabled since only the staff uses it
+	router.Post("/api/v1/admin/spits/{id}/disable", route("POST /api/v1/admin/spits/{id}/disable",
+		limits.limit("edit", limits.create, requireStaff(STAFF_ROLE_MODERATOR, requireSpitID(apiAdminDisableHandler)))))
+	router.Post("/api/v1/admin/spits/{id}/enable", route("POST /api/v1/admin/spits/{id}/enable",
+		limits.limit("edit", limits.create, requireStaff(STAFF_ROLE_MODERATOR, requireSpitID(apiAdminEnableHandler)))))
+	router.Get("/api/v1/admin/spits/{id}", route("GET /api/v1/admin/spits/{id}",
+		limits.limit("view", limits.view, requireStaff(STAFF_ROLE_SUPPORT, requireSpitID(apiAdminSpitHandler)))))
+	router.Post("/api/v1/admin/domains/disable", route("POST /api/v1/admin/domains/disable",
+		requireStaff(STAFF_ROLE_ADMIN, apiAdminDisableDomainHandler)))
+	router.Get("/api/v1/admin/jobs/{id}", route("GET /api/v1/admin/jobs/{id}",
+		limits.limit("view", limits.view, requireStaff(STAFF_ROLE_ADMIN, apiAdminJobHandler))))
+	router.Get("/api/v1/admin/audit", route("GET /api/v1/admin/audit",
+		limits.limit("view", limits.view, requireStaff(STAFF_ROLE_SUPPORT, apiAdminAuditHandler))))
 
 	/////////////////
 	// VIEW ROUTERS
 	/////////////////
 	//router.Get("/", rootHandler)
-	router.Get("/{id}", CORSEnable(requireSpitID(webRedirectHandler)))
-	router.Get("/", CORSEnable(requireSpitID(webRedirectHandler)))
-	http.Handle("/", router)
+	// the login routes have to be registered before /{id} which matches everything
+	if ssoProvider != nil {
+		router.Get("/auth/login", route("GET /auth/login", ssoProvider.LoginHandler))
+		router.Get("/auth/callback", route("GET /auth/callback", ssoProvider.CallbackHandler))
+		router.Post("/auth/logout", route("POST /auth/logout", ssoProvider.LogoutHandler))
+		router.Get("/auth/me", route("GET /auth/me", requireOwner(authMeHandler)))
+	}
+	// the links of the workspaces, before /{id} which is a prefix of them
+	router.Get("/{workspace}/{slug}", route("GET /{workspace}/{slug}",
+		CORSEnable(limits.limit("redirect", limits.redirect, webSlugRedirectHandler))))
+	router.Post("/{workspace}/{slug}", route("POST /{workspace}/{slug}",
+		limits.limit("redirect", limits.redirect, limitSizeHandler(webSlugRedirectHandler, MAX_FORM_SIZE))))
+	router.Get("/{id}", route("GET /{id}",
+		CORSEnable(limits.limit("redirect", limits.redirect, requireSpitID(webRedirectHandler)))))
+	router.Post("/{id}", route("POST /{id}",
+		limits.limit("redirect", limits.redirect, limitSizeHandler(requireSpitID(webRedirectHandler), MAX_FORM_SIZE))))
+	router.Get("/", route("GET /", CORSEnable(requireSpitID(webRedirectHandler))))
+	http.Handle("/", tracing.Handler(requestIDHandler(accessLogHandler(recoverHandler(router)))))
 
 	/**
 	 *	SINGLE-DOUBLE LETTER DOMAINS ARE RESERVED FOR INTERNAL USAGE
@@ -266,7 +702,26 @@ func main() {
 		//router.ServeFiles("/static/*filepath", http.Dir("static"))
 	*/
 
-	http.ListenAndServe(":"+port, nil)
+	// the health checks bypass the router, the access logs and the tracing
+	// since the load balancers poll them all the time
+	http.HandleFunc("/healthz", healthzHandler)
+	http.HandleFunc("/readyz", readyzHandler)
+
+	// the metrics are only served on the admin listener, which is off unless configured
+	adminAddr := utils.EnvString("SPITO_ADMIN_ADDR", "")
+
+	// plain HTTP behind Beanstalk, or HTTPS with the HTTP listener redirecting to it
+	listeners := []serverListener{{newServer(":"+port, nil), (*http.Server).ListenAndServe}}
+	if tlsSettings := tlsSettingsFromEnv(); tlsSettings.enabled() {
+		if listeners, err = tlsSettings.tlsListeners(http.DefaultServeMux); err != nil {
+			slog.Error("invalid TLS configuration", "err", err)
+			os.Exit(1)
+		}
+	}
+	if len(adminAddr) > 0 {
+		listeners = append(listeners, serverListener{newServer(adminAddr, newAdminMux()), (*http.Server).ListenAndServe})
+	}
+	os.Exit(runServers(listeners, shutdownHooks...))
 }
 
 //////////////////////// HELPERS ////////////////////
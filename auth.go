@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/lambrospetrou/spito/spit"
+	"github.com/lambrospetrou/spito/sso"
+	"github.com/lambrospetrou/spito/utils"
+)
+
+// allowAnonymous allows creating spits without logging in or an API key
+var allowAnonymous = utils.EnvBool("SPITO_ALLOW_ANONYMOUS", true)
+
+// ssoProvider logs users in with OpenID Connect, nil if SPITO_OIDC_ISSUER is not set
+var ssoProvider *sso.Provider
+
+// setupSSO() discovers the OpenID Connect provider if one is configured
+func setupSSO(ctx context.Context) error {
+	config := sso.ConfigFromEnv()
+	if config == nil {
+		return nil
+	}
+	provider, err := sso.New(ctx, config)
+	if err != nil {
+		return err
+	}
+	ssoProvider = provider
+	return nil
+}
+
+type ownerKey struct{}
+
+// requestOwner() returns the owner of the API key or the session of the request, empty for anonymous requests
+func requestOwner(r *http.Request) string {
+	owner, _ := r.Context().Value(ownerKey{}).(string)
+	return owner
+}
+
+// authenticated() verifies the API key of the Authorization header if there is one,
+// otherwise it falls back to the session cookie of the logged in user.
+// Requests without either stay anonymous, requests with an invalid key are rejected.
+func authenticated(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+		if len(token) == 0 {
+			if ssoProvider != nil {
+				if owner := ssoProvider.Owner(r); len(owner) > 0 {
+					r = r.WithContext(context.WithValue(r.Context(), ownerKey{}, owner))
+				}
+			}
+			fn(w, r)
+			return
+		}
+		key, err := spit.Authenticate(r.Context(), token)
+		if err != nil {
+			if err == spit.ErrInvalidAPIKey {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="spito"`)
+				writeAPIError(w, r, http.StatusUnauthorized, err.Error())
+				return
+			}
+			slog.ErrorContext(r.Context(), "could not verify the API key", "err", err)
+			writeInternalError(w, r)
+			return
+		}
+		fn(w, r.WithContext(context.WithValue(r.Context(), ownerKey{}, key.OwnerId)))
+	}
+}
+
+// requireOwner() only lets through the requests with a valid API key or session
+func requireOwner(fn http.HandlerFunc) http.HandlerFunc {
+	return authenticated(func(w http.ResponseWriter, r *http.Request) {
+		if len(requestOwner(r)) == 0 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="spito"`)
+			writeAPIError(w, r, http.StatusUnauthorized, "Log in or use an API key")
+			return
+		}
+		fn(w, r)
+	})
+}
+
+type APIMeResult struct {
+	Owner string `json:"owner"`
+}
+
+// authMeHandler() returns the owner of the session, for the web app to know who is logged in
+func authMeHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(&APIMeResult{Owner: requestOwner(r)})
+}
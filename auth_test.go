@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireOwner(t *testing.T) {
+	called := false
+	handler := requireOwner(func(w http.ResponseWriter, r *http.Request) { called = true })
+	cases := map[string]string{
+		"anonymous":    "",
+		"malformed":    "Bearer not-a-key",
+		"wrong scheme": "Basic spk_abc_secret",
+	}
+	for name, authorization := range cases {
+		called = false
+		r := httptest.NewRequest(http.MethodGet, "/v1/spits", nil)
+		if len(authorization) > 0 {
+			r.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if called || w.Code != http.StatusUnauthorized {
+			t.Errorf("%v: expected 401, got %v", name, w.Code)
+		}
+		if len(w.Header().Get("WWW-Authenticate")) == 0 {
+			t.Errorf("%v: expected the WWW-Authenticate header", name)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/lambrospetrou/spito/spit"
+)
+
+// command is an administrative subcommand run as `spito <name> [args...]`
+type command struct {
+	usage       string
+	description string
+	run         func(args []string) error
+}
+
+var commands map[string]*command = map[string]*command{
+	"migrate": {
+		usage:       "migrate",
+		description: "Create or update the storage tables to the latest schema version",
+		run:         cmdMigrate,
+	},
+	"migrate-keys": {
+		usage:       "migrate-keys",
+		description: "Rewrite the spits stored with the legacy spit::id:: keys, safe to run while serving",
+		run:         cmdMigrateKeys,
+	},
+	"apikey-create": {
+		usage:       "apikey-create <owner> [name]",
+		description: "Create an API key for a user or team, the key is only printed once",
+		run:         cmdAPIKeyCreate,
+	},
+	"apikey-revoke": {
+		usage:       "apikey-revoke <key-id>",
+		description: "Revoke an API key, running servers accept it until their cache expires",
+		run:         cmdAPIKeyRevoke,
+	},
+	"enable-ttl": {
+		usage:       "enable-ttl",
+		description: "Enable the native storage TTL so expired spits get deleted automatically",
+		run:         cmdEnableTTL,
+	},
+}
+
+func printCommandsUsage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "Usage: spito [command]")
+	fmt.Fprintln(os.Stderr, "Without a command the server is started. Commands:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-30s %s\n", commands[name].usage, commands[name].description)
+	}
+}
+
+// runCommand() runs the command in args and returns the exit code of the process
+func runCommand(args []string) int {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		printCommandsUsage()
+		return 2
+	}
+	// the commands do not need the storage to be ready for serving spits
+	spit.InitAdmin()
+	if err := cmd.run(args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "spito %s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+func cmdEnableTTL(args []string) error {
+	if err := spit.EnableTTL(context.Background()); err != nil {
+		return err
+	}
+	fmt.Println("TTL enabled")
+	return nil
+}
+
+func cmdMigrate(args []string) error {
+	if err := spit.Migrate(context.Background()); err != nil {
+		return err
+	}
+	fmt.Println("Storage schema is up to date")
+	return nil
+}
+
+func cmdMigrateKeys(args []string) error {
+	migrated, err := spit.MigrateKeys(context.Background())
+	fmt.Printf("Migrated %d spits\n", migrated)
+	return err
+}
+
+func cmdAPIKeyCreate(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("usage: spito apikey-create <owner> [name]")
+	}
+	name := ""
+	if len(args) == 2 {
+		name = args[1]
+	}
+	key, apiKey, err := spit.NewAPIKey(context.Background(), args[0], name)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created API key %s for %s\n", apiKey.Id, apiKey.OwnerId)
+	fmt.Println(key)
+	return nil
+}
+
+func cmdAPIKeyRevoke(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: spito apikey-revoke <key-id>")
+	}
+	if err := spit.RevokeAPIKey(context.Background(), args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("Revoked API key %s\n", args[0])
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lambrospetrou/spito/spit"
+	"github.com/lambrospetrou/spito/utils"
+)
+
+const (
+	HEALTH_STATUS_OK   string = "ok"
+	HEALTH_STATUS_FAIL string = "fail"
+)
+
+// the storage probe must answer before the load balancer gives up on the check
+var readinessTimeout = utils.EnvDuration("SPITO_READINESS_TIMEOUT", 2*time.Second)
+
+// APIHealthComponent never carries the error itself since /readyz is public,
+// the details are only logged.
+type APIHealthComponent struct {
+	Status string `json:"status"`
+}
+
+type APIHealthResult struct {
+	Status     string                        `json:"status"`
+	Components map[string]APIHealthComponent `json:"components,omitempty"`
+}
+
+// healthzHandler() only tells that the process is alive and serving requests.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, &APIHealthResult{Status: HEALTH_STATUS_OK})
+}
+
+// readyzHandler() tells whether this instance can serve Spits, the load balancer
+// should stop sending requests to it otherwise.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	var idsErr error
+	if !spit.IdsLoaded() {
+		idsErr = errors.New("the id alphabets are not loaded")
+	}
+	result := &APIHealthResult{
+		Status: HEALTH_STATUS_OK,
+		Components: map[string]APIHealthComponent{
+			"ids":     healthComponent(r.Context(), "ids", idsErr),
+			"storage": healthComponent(r.Context(), "storage", spit.Ping(ctx)),
+			"config":  healthComponent(r.Context(), "config", checkConfig()),
+		},
+	}
+	for _, c := range result.Components {
+		if c.Status != HEALTH_STATUS_OK {
+			result.Status = HEALTH_STATUS_FAIL
+		}
+	}
+	writeHealth(w, result)
+}
+
+func healthComponent(ctx context.Context, name string, err error) APIHealthComponent {
+	if err != nil {
+		slog.WarnContext(ctx, "not ready", "component", name, "err", err)
+		return APIHealthComponent{Status: HEALTH_STATUS_FAIL}
+	}
+	return APIHealthComponent{Status: HEALTH_STATUS_OK}
+}
+
+// withHealthRoutes() answers the health checks itself and passes everything else to h,
+// so that the checks of the plain HTTP listener are not redirected to HTTPS.
+func withHealthRoutes(h http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.HandleFunc("/readyz", readyzHandler)
+	mux.Handle("/", h)
+	return mux
+}
+
+func checkConfig() error {
+	if _, err := strconv.Atoi(utils.EnvString("PORT", "40090")); err != nil {
+		return errors.New("PORT has to be a number")
+	}
+	if settings := tlsSettingsFromEnv(); settings.enabled() {
+		if err := settings.validate(); err != nil {
+			return err
+		}
+	}
+	return spit.CheckConfig()
+}
+
+func writeHealth(w http.ResponseWriter, result *APIHealthResult) {
+	status := http.StatusOK
+	if result.Status != HEALTH_STATUS_OK {
+		status = http.StatusServiceUnavailable
+	}
+	b, _ := json.Marshal(result)
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.WriteHeader(status)
+	w.Write(b)
+}
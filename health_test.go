@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHealthHidesErrors(t *testing.T) {
+	result := &APIHealthResult{
+		Status: HEALTH_STATUS_FAIL,
+		Components: map[string]APIHealthComponent{
+			"storage": healthComponent(context.Background(), "storage", errors.New("dial tcp 10.0.0.7:8000: refused")),
+		},
+	}
+	w := httptest.NewRecorder()
+	writeHealth(w, result)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %v", w.Code)
+	}
+	if body := w.Body.String(); strings.Contains(body, "10.0.0.7") || !strings.Contains(body, `"storage":{"status":"fail"}`) {
+		t.Errorf("unexpected body %v", body)
+	}
+}
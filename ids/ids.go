@@ -1,7 +1,7 @@
 package ids
 
 import (
-	"log"
+	"log/slog"
 
 	"github.com/lambrospetrou/goencoding/lpenc"
 )
@@ -15,9 +15,14 @@ func InitWith(chars ...string) {
 	}
 }
 
+// Loaded returns true once the id alphabets are loaded by InitWith.
+func Loaded() bool {
+	return len(_SpitIdEncodings) > 0
+}
+
 func Encode(n uint64, encodingIdx int) string {
 	if encodingIdx >= len(_SpitIdEncodings) || encodingIdx < 0 {
-		log.Println("Encoding idx given is invalid: ", encodingIdx)
+		slog.Error("invalid id encoding index", "index", encodingIdx)
 		return ""
 	}
 	return _SpitIdEncodings[encodingIdx].Encode(n)
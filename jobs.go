@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/lambrospetrou/spito/spit"
+)
+
+const (
+	JOB_STATUS_RUNNING string = "running"
+	JOB_STATUS_DONE    string = "done"
+	JOB_STATUS_FAILED  string = "failed"
+
+	// the finished jobs are kept for their status to be looked up for a while
+	_JOB_RETENTION time.Duration = time.Hour
+)
+
+// APIJobResult is the status of a domain disable running in the background.
+type APIJobResult struct {
+	Id          string   `json:"id"`
+	Domain      string   `json:"domain"`
+	Reason      string   `json:"reason"`
+	Status      string   `json:"status"`
+	DateStarted string   `json:"date_started"`
+	DateDone    string   `json:"date_done,omitempty"`
+	Disabled    []string `json:"disabled,omitempty"`
+}
+
+// domainJobs runs the domain disables in the background of this instance,
+// they scan the whole table so they do not fit in a request.
+var domainJobs = newJobs()
+
+type jobs struct {
+	sync.Mutex
+	byId map[string]*APIJobResult
+	ctx  context.Context
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+func newJobs() *jobs {
+	ctx, stop := context.WithCancel(context.Background())
+	return &jobs{byId: make(map[string]*APIJobResult), ctx: ctx, stop: stop}
+}
+
+func newJobId() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// startDisableDomain() disables the spits of the domain in the background and records
+// the outcome in the audit log under the job id, the start is recorded by the caller.
+func (j *jobs) startDisableDomain(job *APIJobResult, actor string, note string) {
+	now := time.Now().UTC()
+	job.Status = JOB_STATUS_RUNNING
+	job.DateStarted = now.Format(time.RFC3339)
+
+	j.Lock()
+	for id, finished := range j.byId {
+		if done, err := time.Parse(time.RFC3339, finished.DateDone); err == nil && now.Sub(done) > _JOB_RETENTION {
+			delete(j.byId, id)
+		}
+	}
+	j.byId[job.Id] = job
+	j.Unlock()
+
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+		disabled, err := spit.DisableByDomain(j.ctx, job.Domain, job.Reason)
+		status := JOB_STATUS_DONE
+		if err != nil {
+			status = JOB_STATUS_FAILED
+			slog.Error("could not disable domain", "err", err, "job", job.Id, "domain", job.Domain, "disabled", len(disabled))
+		}
+		entry := &spit.AuditEntry{Actor: actor, Action: spit.AUDIT_ACTION_DISABLE_DOMAIN_DONE, Job: job.Id,
+			Domain: job.Domain, Reason: job.Reason, Note: note, Spits: disabled}
+		// the job outlives the request, and has to be recorded even while shutting down
+		if err := spit.Audit(context.WithoutCancel(j.ctx), entry); err != nil {
+			slog.Error("could not record the audit entry", "err", err, "action", entry.Action, "job", job.Id)
+		}
+		slog.Info("staff disabled domain", "actor", actor, "job", job.Id, "domain", job.Domain, "disabled", len(disabled), "status", status)
+
+		j.Lock()
+		job.Status = status
+		job.DateDone = time.Now().UTC().Format(time.RFC3339)
+		job.Disabled = disabled
+		j.Unlock()
+	}()
+}
+
+// get() returns a copy of the job, nil if this instance does not know it
+func (j *jobs) get(id string) *APIJobResult {
+	j.Lock()
+	defer j.Unlock()
+	job, ok := j.byId[id]
+	if !ok {
+		return nil
+	}
+	c := *job
+	return &c
+}
+
+// shutdown() cancels the running jobs and waits for them to record what they did
+func (j *jobs) shutdown() {
+	j.stop()
+	j.wg.Wait()
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/lambrospetrou/spito/metrics"
+	"github.com/lambrospetrou/spito/ratelimit"
+	"github.com/lambrospetrou/spito/utils"
+)
+
+// trustedProxies are the proxies whose X-Forwarded-For is trusted, from SPITO_TRUSTED_PROXIES
+var trustedProxies []*net.IPNet
+
+// clientIP() returns the address of the client behind the trusted proxies
+func clientIP(r *http.Request) string {
+	return ratelimit.ClientIP(r, trustedProxies)
+}
+
+// rateLimits are the limits of every client per route, by IP and by API key.
+type rateLimits struct {
+	limiter ratelimit.Limiter
+
+	create   ratelimit.Limit
+	view     ratelimit.Limit
+	redirect ratelimit.Limit
+}
+
+// newRateLimits() reads the limits from SPITO_RATE_LIMIT_CREATE, _VIEW and _REDIRECT,
+// e.g. "30/m". The buckets are shared through Redis if SPITO_REDIS_URL is set,
+// otherwise every instance limits on its own.
+func newRateLimits() (*rateLimits, error) {
+	limits := &rateLimits{}
+	var err error
+	if limits.create, err = ratelimit.ParseLimit(utils.EnvString("SPITO_RATE_LIMIT_CREATE", "30/m")); err != nil {
+		return nil, err
+	}
+	if limits.view, err = ratelimit.ParseLimit(utils.EnvString("SPITO_RATE_LIMIT_VIEW", "300/m")); err != nil {
+		return nil, err
+	}
+	if limits.redirect, err = ratelimit.ParseLimit(utils.EnvString("SPITO_RATE_LIMIT_REDIRECT", "600/m")); err != nil {
+		return nil, err
+	}
+	if trustedProxies, err = ratelimit.ParseTrustedProxies(utils.EnvString("SPITO_TRUSTED_PROXIES", "")); err != nil {
+		return nil, err
+	}
+
+	if redisURL := utils.EnvString("SPITO_REDIS_URL", ""); len(redisURL) > 0 {
+		if limits.limiter, err = ratelimit.NewRedisLimiter(redisURL); err != nil {
+			return nil, err
+		}
+	} else {
+		limits.limiter = ratelimit.NewMemoryLimiter()
+	}
+	return limits, nil
+}
+
+// close() releases the connections of the limiter, if it has any
+func (l *rateLimits) close() {
+	if closer, ok := l.limiter.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			slog.Error("could not close the rate limiter", "err", err)
+		}
+	}
+}
+
+// bearerToken() returns the API key of the Authorization header
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// limit() rejects the requests of clients over the named limit with 429.
+// Requests with an API key also count against the key, so that the key cannot
+// be used from many addresses and rotating keys does not escape the IP limit.
+// If the limiter fails the request is allowed.
+func (l *rateLimits) limit(name string, limit ratelimit.Limit, fn http.HandlerFunc) http.HandlerFunc {
+	if !limit.Enabled() {
+		return fn
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		keys := []string{name + ":ip:" + clientIP(r)}
+		if token := bearerToken(r); len(token) > 0 {
+			sum := sha256.Sum256([]byte(token))
+			keys = append(keys, name+":key:"+hex.EncodeToString(sum[:16]))
+		}
+
+		var tightest *ratelimit.Decision
+		for _, key := range keys {
+			d, err := l.limiter.Allow(r.Context(), key, limit)
+			if err != nil {
+				slog.WarnContext(r.Context(), "rate limiter failed, allowing the request", "limit", name, "err", err)
+				continue
+			}
+			if tightest == nil || !d.Allowed || (tightest.Allowed && d.Remaining < tightest.Remaining) {
+				tightest = &d
+			}
+			if !d.Allowed {
+				break
+			}
+		}
+		if tightest == nil {
+			fn(w, r)
+			return
+		}
+
+		ratelimit.SetHeaders(w.Header(), *tightest)
+		if !tightest.Allowed {
+			metrics.RateLimited.WithLabelValues(name).Inc()
+			writeAPIError(w, r, http.StatusTooManyRequests, "Too many requests, please retry later")
+			return
+		}
+		fn(w, r)
+	}
+}
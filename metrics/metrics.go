@@ -0,0 +1,102 @@
+// Package metrics holds the Prometheus metrics of spito.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const _NAMESPACE string = "spito"
+
+// Registry holds all the spito metrics together with the Go runtime and process ones.
+var Registry = prometheus.NewRegistry()
+
+var (
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: _NAMESPACE,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status.",
+	}, []string{"route", "method", "status"})
+
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: _NAMESPACE,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of the HTTP requests by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	Spits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: _NAMESPACE,
+		Name:      "spits_total",
+		Help:      "Spits created, viewed through the API or redirected to, by spit_type.",
+	}, []string{"action", "spit_type"})
+
+	StorageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: _NAMESPACE,
+		Name:      "storage_operation_duration_seconds",
+		Help:      "Latency of the storage operations, including NextId, by operation.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"op"})
+
+	StorageErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: _NAMESPACE,
+		Name:      "storage_errors_total",
+		Help:      "Failed storage operations by operation and kind of error.",
+	}, []string{"op", "kind"})
+
+	URLChecks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: _NAMESPACE,
+		Name:      "url_checks_total",
+		Help:      "Validations of the URLs of new Spits by outcome.",
+	}, []string{"outcome"})
+
+	RateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: _NAMESPACE,
+		Name:      "rate_limited_requests_total",
+		Help:      "Requests rejected by the rate limits, by limit.",
+	}, []string{"limit"})
+)
+
+// Spit actions
+const (
+	ACTION_CREATE   string = "create"
+	ACTION_VIEW     string = "view"
+	ACTION_REDIRECT string = "redirect"
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests, HTTPDuration, Spits, StorageDuration, StorageErrors, URLChecks, RateLimited,
+	)
+}
+
+// ObserveRequest records a served HTTP request
+func ObserveRequest(route string, method string, status int, latency time.Duration) {
+	if len(route) == 0 {
+		route = "unmatched"
+	}
+	HTTPRequests.WithLabelValues(route, method, strconv.Itoa(status)).Inc()
+	HTTPDuration.WithLabelValues(route, method).Observe(latency.Seconds())
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// CounterFunc registers a counter whose value is read from fn on every scrape,
+// for the counters kept elsewhere like the sweeper stats.
+func CounterFunc(name string, help string, fn func() float64) {
+	Registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: _NAMESPACE,
+		Name:      name,
+		Help:      help,
+	}, fn))
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/lambrospetrou/spito/metrics"
+	"github.com/lambrospetrou/spito/tracing"
+	"github.com/lambrospetrou/spito/utils"
+)
+
+const (
+	REQUEST_ID_HEADER string = "X-Request-Id"
+)
+
+// incoming request ids are only reused if they look harmless
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+func newRequestID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestID() returns the id assigned to the request by requestIDHandler()
+func requestID(r *http.Request) string {
+	return utils.RequestID(r.Context())
+}
+
+// requestIDHandler() assigns an id to every request, or keeps the one set by a proxy,
+// and returns it in the response headers so that errors can be traced in the logs.
+func requestIDHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(REQUEST_ID_HEADER)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(REQUEST_ID_HEADER, id)
+		h.ServeHTTP(w, r.WithContext(utils.WithRequestID(r.Context(), id)))
+	})
+}
+
+// recoverWriter remembers whether the response was started
+type recoverWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *recoverWriter) WriteHeader(status int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recoverWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recoverWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// recoverHandler() turns a panic while serving a request into a 500 response
+// instead of killing the whole server. If the response was already started
+// the connection is aborted instead so the client does not take a truncated body as complete.
+func recoverHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &recoverWriter{ResponseWriter: w}
+		defer func() {
+			if rec := recover(); rec != nil {
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+				slog.ErrorContext(r.Context(), "panic while serving request",
+					"method", r.Method, "path", r.URL.Path, "panic", rec, "stack", string(debug.Stack()),
+					"response_started", rw.wroteHeader)
+				if rw.wroteHeader {
+					panic(http.ErrAbortHandler)
+				}
+				writeInternalError(w, r)
+			}
+		}()
+		h.ServeHTTP(rw, r)
+	})
+}
+
+// writeAPIError() writes the errors in the APIResultError shape
+func writeAPIError(w http.ResponseWriter, r *http.Request, status int, errs ...string) {
+	if errs == nil {
+		errs = []string{}
+	}
+	b, err := json.Marshal(&APIResultError{Errors: errs, RequestId: requestID(r)})
+	if err != nil {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+// writeAPIResult() writes the result as JSON
+func writeAPIResult(w http.ResponseWriter, r *http.Request, status int, result interface{}) {
+	b, err := json.Marshal(result)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not marshal result", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+// writeInternalError() hides the details of internal errors from the clients,
+// they can only be found in the logs through the request id.
+func writeInternalError(w http.ResponseWriter, r *http.Request) {
+	writeAPIError(w, r, http.StatusInternalServerError, "Internal server error")
+}
+
+// accessLogEntry is filled in while serving a request and logged once it is done
+type accessLogEntry struct {
+	route  string
+	spitID string
+}
+
+type accessLogKey struct{}
+
+// accessLogWriter records the status and the size of the response
+type accessLogWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *accessLogWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessLogWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+func (w *accessLogWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// accessLogHandler() logs one line per request and records the request metrics. The route and the spit id are
+// set by the handlers through route() and logSpitID(), never the spit content.
+func accessLogHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		entry := &accessLogEntry{}
+		lw := &accessLogWriter{ResponseWriter: w}
+		h.ServeHTTP(lw, r.WithContext(context.WithValue(r.Context(), accessLogKey{}, entry)))
+
+		if lw.status == 0 {
+			lw.status = http.StatusOK
+		}
+		latency := time.Since(start)
+		metrics.ObserveRequest(entry.route, r.Method, lw.status, latency)
+
+		attrs := []any{
+			"method", r.Method,
+			"route", entry.route,
+			"status", lw.status,
+			"latency", latency,
+			"bytes", lw.bytes,
+		}
+		if len(entry.spitID) > 0 {
+			attrs = append(attrs, "spit_id", entry.spitID)
+		}
+		slog.InfoContext(r.Context(), "request", attrs...)
+	})
+}
+
+// route() names the route of the handler in the access logs and the traces instead of the URL,
+// so the requests group by route and the query strings, which may carry spit passwords,
+// are never logged. The spit id is logged in its own field by logSpitID().
+func route(pattern string, h http.HandlerFunc) http.HandlerFunc {
+	method, path, _ := strings.Cut(pattern, " ")
+	return func(w http.ResponseWriter, r *http.Request) {
+		if entry, ok := r.Context().Value(accessLogKey{}).(*accessLogEntry); ok {
+			entry.route = pattern
+		}
+		tracing.SetRoute(r.Context(), method, path)
+		h(w, r)
+	}
+}
+
+// logSpitID() adds the id of the served spit to the access log of the request
+func logSpitID(r *http.Request, id string) {
+	if entry, ok := r.Context().Value(accessLogKey{}).(*accessLogEntry); ok {
+		entry.spitID = id
+	}
+}
@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseTrustedProxies parses the comma-separated IPs or CIDRs of the proxies
+// whose X-Forwarded-For headers are trusted.
+func ParseTrustedProxies(s string) ([]*net.IPNet, error) {
+	proxies := make([]*net.IPNet, 0)
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if len(p) == 0 {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip != nil && ip.To4() != nil {
+				p += "/32"
+			} else {
+				p += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %v", p, err)
+		}
+		proxies = append(proxies, ipNet)
+	}
+	return proxies, nil
+}
+
+func _IsTrusted(ip net.IP, proxies []*net.IPNet) bool {
+	for _, p := range proxies {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the IP of the client. X-Forwarded-For is only used when the request
+// comes from a trusted proxy, and then the client is the rightmost address that is not
+// a trusted proxy since anything left of it can be forged by the client.
+func ClientIP(r *http.Request, trustedProxies []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !_IsTrusted(ip, trustedProxies) {
+		return host
+	}
+
+	forwarded := make([]string, 0)
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(header, ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hopIP := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hopIP == nil {
+			// garbage in the chain, do not trust anything left of it
+			return ip.String()
+		}
+		ip = hopIP
+		if !_IsTrusted(ip, trustedProxies) {
+			break
+		}
+	}
+	return ip.String()
+}
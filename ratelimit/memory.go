@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+const _MEMORY_CLEANUP_INTERVAL time.Duration = time.Minute
+
+type _bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+// MemoryLimiter keeps the buckets in the process, so every instance limits on its own.
+type MemoryLimiter struct {
+	mu          sync.Mutex
+	buckets     map[string]*_bucket
+	lastCleanup time.Time
+	now         func() time.Time
+}
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{buckets: make(map[string]*_bucket), now: time.Now}
+}
+
+func (l *MemoryLimiter) Allow(ctx context.Context, key string, limit Limit) (Decision, error) {
+	if !limit.Enabled() {
+		return Decision{}, errInvalidLimit
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.cleanup(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &_bucket{tokens: float64(limit.Burst), last: now, limit: limit}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+	b.limit = limit
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	return _Decide(limit, allowed, b.tokens), nil
+}
+
+// cleanup() drops the buckets that are full again, they are the same as new ones.
+func (l *MemoryLimiter) cleanup(now time.Time) {
+	if now.Sub(l.lastCleanup) < _MEMORY_CLEANUP_INTERVAL {
+		return
+	}
+	l.lastCleanup = now
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate >= float64(b.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
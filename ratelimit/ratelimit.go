@@ -0,0 +1,99 @@
+// Package ratelimit limits the requests of every client with token buckets.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit allows Burst requests at once, refilled at Rate requests per second.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Enabled is false for the zero Limit, which allows everything.
+func (l Limit) Enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+// Decision is the outcome of a request against its bucket.
+type Decision struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is the time until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is the time until the next request is allowed, 0 if allowed
+	RetryAfter time.Duration
+}
+
+// Limiter takes a token for key from a bucket with the given limit.
+type Limiter interface {
+	Allow(ctx context.Context, key string, limit Limit) (Decision, error)
+}
+
+var _limitUnits = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+}
+
+// ParseLimit parses limits like "10/m", allowing bursts of 10 requests refilled
+// over a minute. An empty string, "0" or "off" disable the limit.
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if len(s) == 0 || s == "0" || s == "off" {
+		return Limit{}, nil
+	}
+	count, unit, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid limit %q, expected e.g. 10/m", s)
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n <= 0 {
+		return Limit{}, fmt.Errorf("invalid limit %q, the count has to be positive", s)
+	}
+	period, ok := _limitUnits[unit]
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid limit %q, the unit has to be s, m or h", s)
+	}
+	return Limit{Rate: float64(n) / period.Seconds(), Burst: n}, nil
+}
+
+var errInvalidLimit = errors.New("the limit is not enabled")
+
+// _Decide builds the decision from the tokens left in the bucket after the request.
+func _Decide(limit Limit, allowed bool, tokens float64) Decision {
+	d := Decision{
+		Allowed:   allowed,
+		Limit:     limit.Burst,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     _Seconds((float64(limit.Burst) - tokens) / limit.Rate),
+	}
+	if !allowed {
+		d.RetryAfter = _Seconds((1 - tokens) / limit.Rate)
+	}
+	return d
+}
+
+func _Seconds(s float64) time.Duration {
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
+
+// SetHeaders writes the RateLimit-* headers of the decision, and Retry-After
+// if the request is not allowed.
+func SetHeaders(h http.Header, d Decision) {
+	h.Set("RateLimit-Limit", strconv.Itoa(d.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(d.Reset.Seconds()))))
+	if !d.Allowed {
+		h.Set("Retry-After", strconv.Itoa(int(math.Ceil(d.RetryAfter.Seconds()))))
+	}
+}
@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	cases := []struct {
+		in    string
+		limit Limit
+		ok    bool
+	}{
+		{"", Limit{}, true},
+		{"off", Limit{}, true},
+		{"10/s", Limit{Rate: 10, Burst: 10}, true},
+		{"60/m", Limit{Rate: 1, Burst: 60}, true},
+		{"10", Limit{}, false},
+		{"-1/m", Limit{}, false},
+		{"10/d", Limit{}, false},
+	}
+	for _, c := range cases {
+		limit, err := ParseLimit(c.in)
+		if (err == nil) != c.ok || limit != c.limit {
+			t.Errorf("ParseLimit(%q) = %v, %v", c.in, limit, err)
+		}
+	}
+}
+
+func TestMemoryLimiter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := NewMemoryLimiter()
+	l.now = func() time.Time { return now }
+	limit := Limit{Rate: 1, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		if d, _ := l.Allow(context.Background(), "a", limit); !d.Allowed {
+			t.Fatalf("request %d should be allowed", i)
+		}
+	}
+	d, _ := l.Allow(context.Background(), "a", limit)
+	if d.Allowed || d.Remaining != 0 || d.RetryAfter != time.Second {
+		t.Fatalf("third request should wait a second: %+v", d)
+	}
+	if d, _ := l.Allow(context.Background(), "b", limit); !d.Allowed {
+		t.Fatal("other keys have their own bucket")
+	}
+
+	now = now.Add(time.Second)
+	if d, _ := l.Allow(context.Background(), "a", limit); !d.Allowed {
+		t.Fatal("the bucket should be refilled after a second")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		remote, forwarded, ip string
+	}{
+		{"1.2.3.4:1000", "", "1.2.3.4"},
+		// untrusted peers cannot choose their IP
+		{"1.2.3.4:1000", "5.6.7.8", "1.2.3.4"},
+		{"10.0.0.1:1000", "5.6.7.8", "5.6.7.8"},
+		// the client can only forge the addresses left of the one our proxies saw
+		{"10.0.0.1:1000", "9.9.9.9, 5.6.7.8, 192.168.1.1", "5.6.7.8"},
+		{"10.0.0.1:1000", "", "10.0.0.1"},
+		{"10.0.0.1:1000", "garbage, 10.0.0.2", "10.0.0.2"},
+	}
+	for _, c := range cases {
+		r := &http.Request{RemoteAddr: c.remote, Header: http.Header{}}
+		if len(c.forwarded) > 0 {
+			r.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+		if ip := ClientIP(r, proxies); ip != c.ip {
+			t.Errorf("ClientIP(%v, %q) = %v, expected %v", c.remote, c.forwarded, ip, c.ip)
+		}
+	}
+}
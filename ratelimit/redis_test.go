@@ -0,0 +1,54 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestRedisLimiter(t *testing.T) {
+	server := miniredis.RunT(t)
+	l, err := NewRedisLimiter("redis://" + server.Addr() + "/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	limit := Limit{Rate: 1, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		if d, err := l.Allow(context.Background(), "a", limit); err != nil || !d.Allowed {
+			t.Fatalf("request %d should be allowed: %+v %v", i, d, err)
+		}
+	}
+	d, err := l.Allow(context.Background(), "a", limit)
+	if err != nil || d.Allowed || d.Remaining != 0 || d.RetryAfter <= 0 {
+		t.Fatalf("third request should wait: %+v %v", d, err)
+	}
+	if d, err := l.Allow(context.Background(), "b", limit); err != nil || !d.Allowed {
+		t.Fatalf("other keys have their own bucket: %+v %v", d, err)
+	}
+	if !server.Exists("spito:ratelimit:a") {
+		t.Fatal("the bucket should be stored under the prefix")
+	}
+
+	// the script takes the time from the server
+	server.SetTime(time.Now().Add(time.Second))
+	if d, err := l.Allow(context.Background(), "a", limit); err != nil || !d.Allowed {
+		t.Fatalf("the bucket should be refilled after a second: %+v %v", d, err)
+	}
+}
+
+func TestRedisLimiterDown(t *testing.T) {
+	server := miniredis.RunT(t)
+	l, err := NewRedisLimiter("redis://" + server.Addr() + "/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	server.Close()
+	if _, err := l.Allow(context.Background(), "a", Limit{Rate: 1, Burst: 2}); err == nil {
+		t.Fatal("expected an error without Redis")
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/lambrospetrou/spito/screening"
+	"github.com/lambrospetrou/spito/spit"
+	"github.com/lambrospetrou/spito/utils"
+)
+
+// setupScreening() configures the URL screeners from the environment, none by default,
+// and starts the rescreener. It returns a function that stops watching the hash list
+// and the rescreener.
+func setupScreening() (func(), error) {
+	var chain screening.Chain
+	stops := make([]func(), 0)
+	stop := func() {
+		for _, s := range stops {
+			s()
+		}
+	}
+
+	if path := utils.EnvString("SPITO_SCREEN_HASH_LIST", ""); len(path) > 0 {
+		list, stopList, err := screening.WatchHashPrefixFile(path, utils.EnvDuration("SPITO_SCREEN_HASH_LIST_RELOAD", time.Minute))
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, list)
+		stops = append(stops, stopList)
+	}
+	if endpoint := utils.EnvString("SPITO_SCREEN_HTTP_URL", ""); len(endpoint) > 0 {
+		chain = append(chain, screening.NewHTTPScreener(endpoint, utils.EnvString("SPITO_SCREEN_HTTP_TOKEN", ""),
+			utils.EnvDuration("SPITO_SCREEN_HTTP_TIMEOUT", 2*time.Second)))
+	}
+	if len(chain) == 0 {
+		return stop, nil
+	}
+	spit.SetURLScreener(chain, utils.EnvBool("SPITO_SCREEN_FAIL_CLOSED", false))
+	slog.Info("screening the URLs", "screeners", len(chain))
+
+	// 0 disables the rescreener
+	if interval := utils.EnvDuration("SPITO_SCREEN_RESCAN_INTERVAL", 24*time.Hour); interval > 0 {
+		stops = append(stops, spit.StartRescreener(interval, utils.EnvInt("SPITO_SCREEN_RESCAN_CONCURRENCY", 4),
+			utils.EnvDuration("SPITO_SCREEN_RESCAN_URL_TIMEOUT", 10*time.Second)))
+	}
+	return stop, nil
+}
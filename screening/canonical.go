@@ -0,0 +1,139 @@
+package screening
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+)
+
+const (
+	_MAX_HOST_SUFFIXES   = 5
+	_MAX_PATH_PREFIXES   = 4
+	_MAX_UNESCAPE_ROUNDS = 16
+)
+
+// _Unescape percent-unescapes the string until it no longer changes, like Safe Browsing does.
+func _Unescape(s string) string {
+	for i := 0; i < _MAX_UNESCAPE_ROUNDS; i++ {
+		u, err := url.PathUnescape(s)
+		if err != nil || u == s {
+			return s
+		}
+		s = u
+	}
+	return s
+}
+
+// _Escape percent-escapes the control characters, spaces, non-ASCII bytes, # and %.
+func _Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= 0x20 || c >= 0x7f || c == '#' || c == '%' {
+			b.WriteString("%")
+			b.WriteByte("0123456789ABCDEF"[c>>4])
+			b.WriteByte("0123456789ABCDEF"[c&15])
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// _Canonicalize returns the host, path and query of the URL canonicalized
+// following the Safe Browsing rules closely enough for the lookups.
+func _Canonicalize(rawURL string) (string, string, string, error) {
+	rawURL = strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\r' || r == '\n' {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(rawURL))
+	if i := strings.IndexByte(rawURL, '#'); i >= 0 {
+		rawURL = rawURL[:i]
+	}
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	host := strings.ToLower(_Unescape(u.Hostname()))
+	host = strings.Trim(host, ".")
+	for strings.Contains(host, "..") {
+		host = strings.ReplaceAll(host, "..", ".")
+	}
+	if len(host) == 0 {
+		return "", "", "", errors.New("the URL has no host")
+	}
+
+	p := _Unescape(u.EscapedPath())
+	if len(p) == 0 {
+		p = "/"
+	}
+	trailingSlash := strings.HasSuffix(p, "/")
+	p = path.Clean(p)
+	if trailingSlash && p != "/" {
+		p += "/"
+	}
+	return _Escape(host), _Escape(p), u.RawQuery, nil
+}
+
+// _Expressions returns the host suffix and path prefix combinations of the URL
+// whose hashes are looked up, e.g. for http://a.b.c/1/2.html?param=1
+//
+//	a.b.c/1/2.html?param=1, a.b.c/1/2.html, a.b.c/, a.b.c/1/,
+//	b.c/1/2.html?param=1, b.c/1/2.html, b.c/, b.c/1/
+func _Expressions(rawURL string) ([]string, error) {
+	host, p, query, err := _Canonicalize(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := []string{host}
+	// the IP addresses are only looked up as they are
+	if net.ParseIP(strings.Trim(host, "[]")) == nil {
+		components := strings.Split(host, ".")
+		if len(components) > _MAX_HOST_SUFFIXES {
+			components = components[len(components)-_MAX_HOST_SUFFIXES:]
+		}
+		for i := 0; i+2 <= len(components) && len(hosts) < _MAX_HOST_SUFFIXES; i++ {
+			if suffix := strings.Join(components[i:], "."); suffix != host {
+				hosts = append(hosts, suffix)
+			}
+		}
+	}
+
+	paths := make([]string, 0, 2+_MAX_PATH_PREFIXES)
+	if len(query) > 0 {
+		paths = append(paths, p+"?"+query)
+	}
+	paths = append(paths, p)
+	// the prefixes are the directories of the path, starting from the root
+	dirs := strings.Split(strings.Trim(p, "/"), "/")
+	if !strings.HasSuffix(p, "/") {
+		dirs = dirs[:len(dirs)-1]
+	}
+	prefix := "/"
+	for i := 0; i < _MAX_PATH_PREFIXES; i++ {
+		if prefix != p {
+			paths = append(paths, prefix)
+		}
+		if i >= len(dirs) || len(dirs[i]) == 0 {
+			break
+		}
+		prefix += dirs[i] + "/"
+	}
+
+	expressions := make([]string, 0, len(hosts)*len(paths))
+	for _, h := range hosts {
+		for _, p := range paths {
+			expressions = append(expressions, h+p)
+		}
+	}
+	return expressions, nil
+}
@@ -0,0 +1,180 @@
+package screening
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/lambrospetrou/spito/utils"
+)
+
+const (
+	PROVIDER_HASH_LIST string = "hash-list"
+
+	_MIN_PREFIX_SIZE = 4
+)
+
+// ErrUnconfirmed is returned when only a short hash prefix matched, the URL may be harmless
+// and there is no full hash to confirm the threat with, so the screener cannot tell.
+var ErrUnconfirmed = errors.New("only a hash prefix matched, the threat is not confirmed")
+
+// _ListUpdate is the part of the Safe Browsing threatListUpdates response we use,
+// the []byte fields are base64 encoded in the JSON.
+type _ListUpdate struct {
+	ListUpdateResponses []struct {
+		ThreatType   string `json:"threatType"`
+		ResponseType string `json:"responseType"`
+		Additions    []struct {
+			CompressionType string `json:"compressionType"`
+			RawHashes       *struct {
+				PrefixSize int    `json:"prefixSize"`
+				RawHashes  []byte `json:"rawHashes"`
+			} `json:"rawHashes"`
+		} `json:"additions"`
+		Checksum *struct {
+			Sha256 []byte `json:"sha256"`
+		} `json:"checksum"`
+	} `json:"listUpdateResponses"`
+}
+
+// HashPrefixList matches the URLs against the SHA256 hash prefixes of threat lists.
+// Short prefixes can match harmless URLs too, so only the full 32 byte hashes
+// report a URL as malicious.
+type HashPrefixList struct {
+	// threats maps the prefixes to the threat type of their list, by prefix size
+	threats map[int]map[string]string
+	count   int
+}
+
+// ParseHashPrefixList reads a full update of threat lists in the JSON format of the
+// Safe Browsing v4 threatListUpdates response with raw, uncompressed, hash prefixes.
+// The lists with a checksum are verified against it.
+func ParseHashPrefixList(r io.Reader) (*HashPrefixList, error) {
+	update := &_ListUpdate{}
+	if err := json.NewDecoder(r).Decode(update); err != nil {
+		return nil, err
+	}
+	l := &HashPrefixList{threats: make(map[int]map[string]string)}
+	for _, list := range update.ListUpdateResponses {
+		if list.ResponseType != "" && list.ResponseType != "FULL_UPDATE" {
+			return nil, fmt.Errorf("list %v: only full updates are supported, got %v", list.ThreatType, list.ResponseType)
+		}
+		prefixes := make([]string, 0)
+		for _, addition := range list.Additions {
+			if addition.CompressionType != "" && addition.CompressionType != "RAW" {
+				return nil, fmt.Errorf("list %v: only raw hashes are supported, got %v", list.ThreatType, addition.CompressionType)
+			}
+			if addition.RawHashes == nil {
+				continue
+			}
+			size, raw := addition.RawHashes.PrefixSize, addition.RawHashes.RawHashes
+			if size < _MIN_PREFIX_SIZE || size > sha256.Size || len(raw)%size != 0 {
+				return nil, fmt.Errorf("list %v: invalid hashes of prefix size %v", list.ThreatType, size)
+			}
+			for i := 0; i < len(raw); i += size {
+				prefixes = append(prefixes, string(raw[i:i+size]))
+			}
+		}
+		// the checksum is the hash of the concatenation of the sorted prefixes
+		if list.Checksum != nil && len(list.Checksum.Sha256) > 0 {
+			sort.Strings(prefixes)
+			h := sha256.New()
+			for _, prefix := range prefixes {
+				h.Write([]byte(prefix))
+			}
+			if !bytes.Equal(h.Sum(nil), list.Checksum.Sha256) {
+				return nil, fmt.Errorf("list %v: checksum mismatch", list.ThreatType)
+			}
+		}
+		for _, prefix := range prefixes {
+			if l.threats[len(prefix)] == nil {
+				l.threats[len(prefix)] = make(map[string]string)
+			}
+			if _, exists := l.threats[len(prefix)][prefix]; !exists {
+				l.count++
+			}
+			l.threats[len(prefix)][prefix] = list.ThreatType
+		}
+	}
+	return l, nil
+}
+
+// LoadHashPrefixList parses the threat lists of the file.
+func LoadHashPrefixList(path string) (*HashPrefixList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseHashPrefixList(f)
+}
+
+// Len returns the number of distinct hash prefixes.
+func (l *HashPrefixList) Len() int {
+	return l.count
+}
+
+func (l *HashPrefixList) Screen(ctx context.Context, rawURL string) (Verdict, error) {
+	expressions, err := _Expressions(rawURL)
+	if err != nil {
+		return Verdict{}, err
+	}
+	unconfirmed := ""
+	for _, expression := range expressions {
+		hash := sha256.Sum256([]byte(expression))
+		if threat, ok := l.threats[sha256.Size][string(hash[:])]; ok {
+			return Verdict{Malicious: true, Threat: threat, Provider: PROVIDER_HASH_LIST}, nil
+		}
+		for size, threats := range l.threats {
+			if threat, ok := threats[string(hash[:size])]; ok && size < sha256.Size && len(unconfirmed) == 0 {
+				unconfirmed = threat
+			}
+		}
+	}
+	if len(unconfirmed) > 0 {
+		return Verdict{}, fmt.Errorf("%w: %v", ErrUnconfirmed, unconfirmed)
+	}
+	return Verdict{}, nil
+}
+
+// HashPrefixFile screens the URLs with the threat lists of a file, reloaded when it changes.
+type HashPrefixFile struct {
+	list atomic.Pointer[HashPrefixList]
+}
+
+// WatchHashPrefixFile loads the threat lists of the file and reloads them whenever
+// the file changes, checking every interval. An invalid file keeps the previous lists in place.
+// The returned function stops watching.
+func WatchHashPrefixFile(path string, interval time.Duration) (*HashPrefixFile, func(), error) {
+	l, err := LoadHashPrefixList(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	f := &HashPrefixFile{}
+	f.list.Store(l)
+	slog.Info("loaded the hash prefix list", "path", path, "prefixes", l.Len())
+
+	stop := utils.WatchFile(path, interval, func() {
+		l, err := LoadHashPrefixList(path)
+		if err != nil {
+			slog.Error("could not reload the hash prefix list, keeping the previous one", "path", path, "err", err)
+			return
+		}
+		f.list.Store(l)
+		slog.Info("reloaded the hash prefix list", "path", path, "prefixes", l.Len())
+	})
+	return f, stop, nil
+}
+
+func (f *HashPrefixFile) Screen(ctx context.Context, rawURL string) (Verdict, error) {
+	return f.list.Load().Screen(ctx, rawURL)
+}
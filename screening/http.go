@@ -0,0 +1,76 @@
+package screening
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	PROVIDER_HTTP string = "http"
+
+	_MAX_RESPONSE_SIZE = 64 * 1024
+)
+
+type _HTTPRequest struct {
+	URL string `json:"url"`
+}
+
+type _HTTPResponse struct {
+	Malicious bool   `json:"malicious"`
+	Threat    string `json:"threat"`
+}
+
+// HTTPScreener asks a reputation service about every URL. The service receives
+//
+//	POST {"url": "https://example.com/login"}
+//
+// and answers 200 with {"malicious": true, "threat": "SOCIAL_ENGINEERING"}.
+type HTTPScreener struct {
+	endpoint string
+	token    string
+	client   *http.Client
+}
+
+// NewHTTPScreener calls the service at the endpoint, with the token as a bearer token
+// if it is not empty, and gives up on a URL after the timeout.
+func NewHTTPScreener(endpoint string, token string, timeout time.Duration) *HTTPScreener {
+	return &HTTPScreener{endpoint: endpoint, token: token, client: &http.Client{Timeout: timeout}}
+}
+
+func (s *HTTPScreener) Screen(ctx context.Context, rawURL string) (Verdict, error) {
+	body, err := json.Marshal(&_HTTPRequest{URL: rawURL})
+	if err != nil {
+		return Verdict{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return Verdict{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if len(s.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return Verdict{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, _MAX_RESPONSE_SIZE))
+		return Verdict{}, fmt.Errorf("reputation service returned %v", resp.Status)
+	}
+	result := &_HTTPResponse{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, _MAX_RESPONSE_SIZE)).Decode(result); err != nil {
+		return Verdict{}, fmt.Errorf("invalid reputation service response: %w", err)
+	}
+	if !result.Malicious {
+		return Verdict{}, nil
+	}
+	return Verdict{Malicious: true, Threat: result.Threat, Provider: PROVIDER_HTTP}, nil
+}
@@ -0,0 +1,41 @@
+// Package screening checks the destinations of URL spits against reputation providers,
+// local hash-prefix lists in the Safe Browsing format or remote reputation services.
+package screening
+
+import (
+	"context"
+	"errors"
+)
+
+// Verdict is the outcome of screening a URL.
+type Verdict struct {
+	Malicious bool
+	// Threat is the kind of threat reported by the provider, e.g. MALWARE or SOCIAL_ENGINEERING
+	Threat string
+	// Provider is the screener that reported the threat
+	Provider string
+}
+
+// URLScreener decides whether a URL is malicious. An error means the screener could not tell.
+type URLScreener interface {
+	Screen(ctx context.Context, rawURL string) (Verdict, error)
+}
+
+// Chain consults every screener in order and returns the first malicious verdict.
+// The errors of the screeners are only returned if none of them found a threat.
+type Chain []URLScreener
+
+func (c Chain) Screen(ctx context.Context, rawURL string) (Verdict, error) {
+	var errs []error
+	for _, s := range c {
+		v, err := s.Screen(ctx, rawURL)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if v.Malicious {
+			return v, nil
+		}
+	}
+	return Verdict{}, errors.Join(errs...)
+}
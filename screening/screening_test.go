@@ -0,0 +1,219 @@
+package screening
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpressions(t *testing.T) {
+	cases := map[string][]string{
+		"http://a.b.c/1/2.html?param=1": {
+			"a.b.c/1/2.html?param=1", "a.b.c/1/2.html", "a.b.c/", "a.b.c/1/",
+			"b.c/1/2.html?param=1", "b.c/1/2.html", "b.c/", "b.c/1/",
+		},
+		"http://a.b.c.d.e.f.g/1.html": {
+			"a.b.c.d.e.f.g/1.html", "a.b.c.d.e.f.g/",
+			"c.d.e.f.g/1.html", "c.d.e.f.g/",
+			"d.e.f.g/1.html", "d.e.f.g/",
+			"e.f.g/1.html", "e.f.g/",
+			"f.g/1.html", "f.g/",
+		},
+		"http://1.2.3.4/1/": {"1.2.3.4/1/", "1.2.3.4/"},
+		"HTTP://www.GOOgle.com/a/./b/../c#frag": {
+			"www.google.com/a/c", "www.google.com/", "www.google.com/a/",
+			"google.com/a/c", "google.com/", "google.com/a/",
+		},
+		"http://www.example.com/%2561%2562": {
+			"www.example.com/ab", "www.example.com/", "example.com/ab", "example.com/",
+		},
+	}
+	for url, expected := range cases {
+		expressions, err := _Expressions(url)
+		if err != nil {
+			t.Fatalf("_Expressions(%q): %v", url, err)
+		}
+		if !reflect.DeepEqual(expressions, expected) {
+			t.Errorf("_Expressions(%q) = %q, expected %q", url, expressions, expected)
+		}
+	}
+}
+
+// writeList writes a threat list update with the prefixes of the expressions
+func writeList(t *testing.T, path string, threatType string, prefixSize int, expressions ...string) {
+	raw, prefixes := []byte{}, []string{}
+	for _, e := range expressions {
+		hash := sha256.Sum256([]byte(e))
+		raw = append(raw, hash[:prefixSize]...)
+		prefixes = append(prefixes, string(hash[:prefixSize]))
+	}
+	sort.Strings(prefixes)
+	checksum := sha256.Sum256([]byte(strings.Join(prefixes, "")))
+	update := map[string]any{
+		"listUpdateResponses": []any{map[string]any{
+			"threatType":   threatType,
+			"responseType": "FULL_UPDATE",
+			"additions": []any{map[string]any{
+				"compressionType": "RAW",
+				"rawHashes":       map[string]any{"prefixSize": prefixSize, "rawHashes": base64.StdEncoding.EncodeToString(raw)},
+			}},
+			"checksum": map[string]any{"sha256": base64.StdEncoding.EncodeToString(checksum[:])},
+		}},
+	}
+	data, err := json.Marshal(update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHashPrefixList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "threats.json")
+	writeList(t, path, "SOCIAL_ENGINEERING", 32, "evil.com/", "example.org/phish/")
+	l, err := LoadHashPrefixList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Len() != 2 {
+		t.Errorf("expected 2 prefixes, got %v", l.Len())
+	}
+	cases := map[string]bool{
+		"https://evil.com/":                  true,
+		"https://login.evil.com/account?x=1": true,
+		"https://example.org/phish/bank":     true,
+		"https://example.org/":               false,
+		"https://notevil.com/":               false,
+	}
+	for url, malicious := range cases {
+		v, err := l.Screen(t.Context(), url)
+		if err != nil {
+			t.Fatalf("Screen(%q): %v", url, err)
+		}
+		if v.Malicious != malicious {
+			t.Errorf("Screen(%q) = %+v, expected malicious %v", url, v, malicious)
+		}
+		if malicious && (v.Threat != "SOCIAL_ENGINEERING" || v.Provider != PROVIDER_HASH_LIST) {
+			t.Errorf("Screen(%q) = %+v, expected the threat of the list", url, v)
+		}
+	}
+}
+
+func TestHashPrefixListShortPrefixes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "threats.json")
+	writeList(t, path, "MALWARE", 4, "evil.com/")
+	l, err := LoadHashPrefixList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a short prefix could be a harmless URL with the same prefix
+	v, err := l.Screen(t.Context(), "https://evil.com/")
+	if v.Malicious || !errors.Is(err, ErrUnconfirmed) {
+		t.Errorf("a prefix hit should be unconfirmed, got %+v %v", v, err)
+	}
+	if v, err := l.Screen(t.Context(), "https://example.com/"); v.Malicious || err != nil {
+		t.Errorf("expected no threat, got %+v %v", v, err)
+	}
+	if v, err := (Chain{l}).Screen(t.Context(), "https://evil.com/"); v.Malicious || !errors.Is(err, ErrUnconfirmed) {
+		t.Errorf("the chain should not tell either, got %+v %v", v, err)
+	}
+}
+
+func TestHashPrefixListChecksum(t *testing.T) {
+	update := `{"listUpdateResponses": [{"threatType": "MALWARE", "responseType": "FULL_UPDATE",
+		"additions": [{"compressionType": "RAW", "rawHashes": {"prefixSize": 4, "rawHashes": "AAAAAA=="}}],
+		"checksum": {"sha256": "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="}}]}`
+	if _, err := ParseHashPrefixList(strings.NewReader(update)); err == nil {
+		t.Error("a list not matching its checksum should be rejected")
+	}
+	partial := `{"listUpdateResponses": [{"threatType": "MALWARE", "responseType": "PARTIAL_UPDATE"}]}`
+	if _, err := ParseHashPrefixList(strings.NewReader(partial)); err == nil {
+		t.Error("partial updates should be rejected")
+	}
+}
+
+func TestWatchHashPrefixFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "threats.json")
+	writeList(t, path, "MALWARE", 32, "evil.com/")
+	f, stop, err := WatchHashPrefixFile(path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	// make sure the modification time changes even on coarse filesystems
+	time.Sleep(20 * time.Millisecond)
+	writeList(t, path, "MALWARE", 32, "bad.example/")
+	os.Chtimes(path, time.Now().Add(time.Second), time.Now().Add(time.Second))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if v, _ := f.Screen(t.Context(), "https://bad.example/"); v.Malicious {
+			if v, _ := f.Screen(t.Context(), "https://evil.com/"); v.Malicious {
+				t.Error("the previous list should be replaced")
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("the list was not reloaded")
+}
+
+func TestHTTPScreener(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		req := &_HTTPRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if strings.Contains(req.URL, "evil.com") {
+			w.Write([]byte(`{"malicious": true, "threat": "MALWARE"}`))
+			return
+		}
+		w.Write([]byte(`{"malicious": false}`))
+	}))
+	defer server.Close()
+
+	s := NewHTTPScreener(server.URL, "secret", time.Second)
+	if v, err := s.Screen(t.Context(), "https://evil.com/x"); err != nil || !v.Malicious || v.Threat != "MALWARE" || v.Provider != PROVIDER_HTTP {
+		t.Errorf("expected a malicious verdict, got %+v %v", v, err)
+	}
+	if v, err := s.Screen(t.Context(), "https://example.com/"); err != nil || v.Malicious {
+		t.Errorf("expected a harmless verdict, got %+v %v", v, err)
+	}
+	if _, err := NewHTTPScreener(server.URL, "wrong", time.Second).Screen(t.Context(), "https://example.com/"); err == nil {
+		t.Error("failed requests should return an error")
+	}
+}
+
+func TestChain(t *testing.T) {
+	failing := NewHTTPScreener("http://127.0.0.1:1", "", 100*time.Millisecond)
+	path := filepath.Join(t.TempDir(), "threats.json")
+	writeList(t, path, "MALWARE", 32, "evil.com/")
+	l, err := LoadHashPrefixList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := Chain{failing, l}
+	if v, err := c.Screen(t.Context(), "https://evil.com/"); err != nil || !v.Malicious {
+		t.Errorf("a threat should be reported despite the failing screener, got %+v %v", v, err)
+	}
+	if _, err := c.Screen(t.Context(), "https://example.com/"); err == nil {
+		t.Error("the error of the failing screener should be returned without a threat")
+	}
+}
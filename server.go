@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/lambrospetrou/spito/utils"
+)
+
+// newServer() returns the HTTP server with the timeouts and limits from the environment
+func newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: utils.EnvDuration("SPITO_READ_HEADER_TIMEOUT", 5*time.Second),
+		ReadTimeout:       utils.EnvDuration("SPITO_READ_TIMEOUT", 15*time.Second),
+		WriteTimeout:      utils.EnvDuration("SPITO_WRITE_TIMEOUT", 30*time.Second),
+		IdleTimeout:       utils.EnvDuration("SPITO_IDLE_TIMEOUT", 120*time.Second),
+		MaxHeaderBytes:    utils.EnvInt("SPITO_MAX_HEADER_BYTES", 1<<16),
+	}
+}
+
+// serverListener is a server together with the way it listens, e.g. ListenAndServeTLS
+type serverListener struct {
+	server *http.Server
+	listen func(server *http.Server) error
+}
+
+// runServers() serves until one of the listeners fails or SIGINT/SIGTERM is received.
+// Then all the servers drain their in-flight requests and the shutdown hooks run
+// in reverse order, e.g. stopping the sweeper.
+// It returns the exit code of the process, non-zero if a listener failed.
+func runServers(listeners []serverListener, shutdownHooks ...func(ctx context.Context)) int {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, len(listeners))
+	for _, l := range listeners {
+		slog.Info("listening", "addr", l.server.Addr)
+		go func(l serverListener) {
+			serverErr <- l.listen(l.server)
+		}(l)
+	}
+
+	exitCode := 0
+	select {
+	case err := <-serverErr:
+		slog.Error("listener failed", "err", err)
+		exitCode = 1
+	case <-ctx.Done():
+		slog.Info("shutting down, draining in-flight requests")
+	}
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(),
+		utils.EnvDuration("SPITO_SHUTDOWN_TIMEOUT", 30*time.Second))
+	defer cancel()
+	for _, l := range listeners {
+		if err := l.server.Shutdown(shutdownCtx); err != nil {
+			slog.Error("could not drain all requests", "addr", l.server.Addr, "err", err)
+			exitCode = 1
+		}
+	}
+	// the click counters are written by the requests themselves so they are flushed
+	// once the requests are drained, the hooks only stop the background work
+	for i := len(shutdownHooks) - 1; i >= 0; i-- {
+		shutdownHooks[i](shutdownCtx)
+	}
+	slog.Info("stopped")
+	return exitCode
+}
@@ -0,0 +1,126 @@
+// Package session signs small values into tamper-proof cookies.
+package session
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// MIN_SECRET_SIZE is the minimum size of the signing secret in bytes
+const MIN_SECRET_SIZE int = 32
+
+var (
+	ErrInvalid = errors.New("Invalid session")
+	ErrExpired = errors.New("Session expired")
+)
+
+// Codec signs and verifies the values, anyone can read them but nobody can change them.
+type Codec struct {
+	secret []byte
+}
+
+// the purpose is signed along the value, so that a value signed for one cookie
+// cannot be replayed as another one with the same secret
+type _envelope struct {
+	Purpose string          `json:"p"`
+	Value   json.RawMessage `json:"v"`
+	Expires int64           `json:"e"`
+}
+
+func NewCodec(secret []byte) (*Codec, error) {
+	if len(secret) < MIN_SECRET_SIZE {
+		return nil, errors.New("the session secret has to be at least 32 bytes")
+	}
+	return &Codec{secret: secret}, nil
+}
+
+func (c *Codec) sign(payload string) string {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Encode signs the value so that it is valid for ttl, and only decoded for the same purpose.
+func (c *Codec) Encode(purpose string, v interface{}, ttl time.Duration) (string, error) {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(&_envelope{Purpose: purpose, Value: value, Expires: time.Now().Add(ttl).Unix()})
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + c.sign(payload), nil
+}
+
+// Decode verifies the signature, the purpose and the expiration and unmarshals the value into v.
+func (c *Codec) Decode(purpose string, s string, v interface{}) error {
+	payload, signature, ok := strings.Cut(s, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(c.sign(payload))) {
+		return ErrInvalid
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return ErrInvalid
+	}
+	envelope := &_envelope{}
+	if err := json.Unmarshal(b, envelope); err != nil || envelope.Purpose != purpose {
+		return ErrInvalid
+	}
+	if time.Now().Unix() >= envelope.Expires {
+		return ErrExpired
+	}
+	if err := json.Unmarshal(envelope.Value, v); err != nil {
+		return ErrInvalid
+	}
+	return nil
+}
+
+// SetCookie stores the signed value in an HttpOnly cookie valid for ttl,
+// the name of the cookie is its purpose.
+// The cookies are SameSite=Lax so other sites cannot make requests with them.
+func (c *Codec) SetCookie(w http.ResponseWriter, name string, v interface{}, ttl time.Duration, secure bool) error {
+	value, err := c.Encode(name, v, ttl)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// ReadCookie decodes the value of the cookie into v.
+func (c *Codec) ReadCookie(r *http.Request, name string, v interface{}) error {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return ErrInvalid
+	}
+	return c.Decode(name, cookie.Value, v)
+}
+
+// ClearCookie removes the cookie from the browser.
+func ClearCookie(w http.ResponseWriter, name string, secure bool) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
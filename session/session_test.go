@@ -0,0 +1,55 @@
+package session
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type testValue struct {
+	Owner string `json:"owner"`
+}
+
+func TestCodec(t *testing.T) {
+	c, err := NewCodec([]byte(strings.Repeat("k", MIN_SECRET_SIZE)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := c.Encode("session", &testValue{Owner: "alice"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := &testValue{}
+	if err := c.Decode("session", s, v); err != nil || v.Owner != "alice" {
+		t.Fatalf("Decode() = %v, %v", v, err)
+	}
+
+	// a value signed for someone else cannot be swapped in
+	other, _ := c.Encode("session", &testValue{Owner: "mallory"}, time.Minute)
+	payload, _, _ := strings.Cut(other, ".")
+	_, signature, _ := strings.Cut(s, ".")
+	if err := c.Decode("session", payload+"."+signature, v); err != ErrInvalid {
+		t.Fatalf("tampered session should be invalid, got %v", err)
+	}
+
+	otherCodec, _ := NewCodec([]byte(strings.Repeat("x", MIN_SECRET_SIZE)))
+	if err := otherCodec.Decode("session", s, v); err != ErrInvalid {
+		t.Fatalf("session of another secret should be invalid, got %v", err)
+	}
+
+	if err := c.Decode("login", s, v); err != ErrInvalid {
+		t.Fatalf("value signed for another purpose should be invalid, got %v", err)
+	}
+
+	expired, _ := c.Encode("session", &testValue{Owner: "alice"}, -time.Second)
+	if err := c.Decode("session", expired, v); err != ErrExpired {
+		t.Fatalf("expired session should be rejected, got %v", err)
+	}
+}
+
+func TestNewCodecShortSecret(t *testing.T) {
+	if _, err := NewCodec([]byte("short")); err == nil {
+		t.Fatal("short secrets should be rejected")
+	}
+}
@@ -0,0 +1,172 @@
+package spit
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lambrospetrou/spito/utils"
+)
+
+const (
+	// API keys look like spk_<id>_<secret>, only the hash of the secret is stored
+	_API_KEY_PREFIX       string = "spk_"
+	_API_KEY_ID_BYTES     int    = 8
+	_API_KEY_SECRET_BYTES int    = 24
+
+	_API_KEY_CACHE_CLEANUP_INTERVAL time.Duration = time.Minute
+)
+
+// the verified keys are cached, so a revoked key keeps working for up to this long
+var _API_KEY_CACHE_TTL = utils.EnvDuration("SPITO_API_KEY_CACHE_TTL", time.Minute)
+
+var ErrInvalidAPIKey = errors.New("Invalid API key")
+
+// APIKey identifies the owner of the requests, a user or a team.
+type APIKey struct {
+	Id          string `json:"id"`
+	OwnerId     string `json:"owner_id"`
+	Name        string `json:"name"`
+	Hash        string `json:"hash"`
+	DateCreated string `json:"date_created"`
+	Revoked     bool   `json:"revoked,omitempty"`
+}
+
+type _cachedAPIKey struct {
+	key     *APIKey
+	expires time.Time
+}
+
+var _apiKeyCache = struct {
+	sync.Mutex
+	byId        map[string]_cachedAPIKey
+	lastCleanup time.Time
+}{byId: make(map[string]_cachedAPIKey)}
+
+func _RandomString(n int, encode func([]byte) string) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encode(b), nil
+}
+
+// the secrets are random so a plain hash is enough, unlike the passwords
+func _HashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func _ParseAPIKey(key string) (string, string, bool) {
+	if !strings.HasPrefix(key, _API_KEY_PREFIX) {
+		return "", "", false
+	}
+	id, secret, ok := strings.Cut(strings.TrimPrefix(key, _API_KEY_PREFIX), "_")
+	if !ok || len(id) == 0 || len(secret) == 0 {
+		return "", "", false
+	}
+	return id, secret, true
+}
+
+// NewAPIKey creates and stores a key for the owner.
+// It returns the key to hand to the owner, it cannot be recovered later.
+func NewAPIKey(ctx context.Context, ownerId string, name string) (string, *APIKey, error) {
+	if len(strings.TrimSpace(ownerId)) == 0 {
+		return "", nil, errors.New("the owner of the key cannot be empty")
+	}
+	id, err := _RandomString(_API_KEY_ID_BYTES, hex.EncodeToString)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := _RandomString(_API_KEY_SECRET_BYTES, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return "", nil, err
+	}
+	k := &APIKey{
+		Id:          id,
+		OwnerId:     ownerId,
+		Name:        name,
+		Hash:        _HashAPIKeySecret(secret),
+		DateCreated: time.Now().UTC().Format(time.RFC3339),
+	}
+	if err := storager.PutAPIKey(ctx, k); err != nil {
+		return "", nil, err
+	}
+	return _API_KEY_PREFIX + id + "_" + secret, k, nil
+}
+
+// RevokeAPIKey revokes the key with the given id, the part after spk_ and before the secret.
+func RevokeAPIKey(ctx context.Context, id string) error {
+	if err := storager.RevokeAPIKey(ctx, id); err != nil {
+		return err
+	}
+	_apiKeyCache.Lock()
+	delete(_apiKeyCache.byId, id)
+	_apiKeyCache.Unlock()
+	return nil
+}
+
+func _LookupAPIKey(ctx context.Context, id string) (*APIKey, error) {
+	now := time.Now()
+	_apiKeyCache.Lock()
+	cached, ok := _apiKeyCache.byId[id]
+	_apiKeyCache.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.key, nil
+	}
+
+	k, err := storager.GetAPIKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	_apiKeyCache.Lock()
+	_CleanupAPIKeyCache(now)
+	_apiKeyCache.byId[id] = _cachedAPIKey{key: k, expires: now.Add(_API_KEY_CACHE_TTL)}
+	_apiKeyCache.Unlock()
+	return k, nil
+}
+
+// _CleanupAPIKeyCache() drops the stale entries so the map does not grow forever,
+// at most once per interval. The caller must hold the lock.
+func _CleanupAPIKeyCache(now time.Time) {
+	if now.Sub(_apiKeyCache.lastCleanup) < _API_KEY_CACHE_CLEANUP_INTERVAL {
+		return
+	}
+	_apiKeyCache.lastCleanup = now
+	for id, c := range _apiKeyCache.byId {
+		if now.After(c.expires) {
+			delete(_apiKeyCache.byId, id)
+		}
+	}
+}
+
+// Authenticate returns the API key if the given key is valid and not revoked.
+// Return
+//
+//	ErrInvalidAPIKey if the key is malformed, unknown, wrong or revoked
+//	other errors if the storage failed
+func Authenticate(ctx context.Context, key string) (*APIKey, error) {
+	id, secret, ok := _ParseAPIKey(key)
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+	k, err := _LookupAPIKey(ctx, id)
+	if err != nil {
+		if _, notFound := err.(DynamoDbItemNotFoundError); notFound {
+			return nil, ErrInvalidAPIKey
+		}
+		return nil, err
+	}
+	hash := _HashAPIKeySecret(secret)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(k.Hash)) != 1 || k.Revoked {
+		return nil, ErrInvalidAPIKey
+	}
+	return k, nil
+}
@@ -0,0 +1,175 @@
+package spit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func (f *_FakeStorager) PutAPIKey(ctx context.Context, k *APIKey) error {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.call("PutAPIKey"); err != nil {
+		return err
+	}
+	stored := *k
+	f.apiKeys[k.Id] = &stored
+	return nil
+}
+
+func (f *_FakeStorager) GetAPIKey(ctx context.Context, id string) (*APIKey, error) {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.call("GetAPIKey"); err != nil {
+		return nil, err
+	}
+	k, ok := f.apiKeys[id]
+	if !ok {
+		return nil, _NotFound(id)
+	}
+	found := *k
+	return &found, nil
+}
+
+func (f *_FakeStorager) RevokeAPIKey(ctx context.Context, id string) error {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.call("RevokeAPIKey"); err != nil {
+		return err
+	}
+	k, ok := f.apiKeys[id]
+	if !ok {
+		return _NotFound(id)
+	}
+	k.Revoked = true
+	return nil
+}
+
+// withEmptyAPIKeyCache makes sure that no key is served from the cache of another test
+func withEmptyAPIKeyCache(t *testing.T) {
+	reset := func() {
+		_apiKeyCache.Lock()
+		_apiKeyCache.byId = make(map[string]_cachedAPIKey)
+		_apiKeyCache.lastCleanup = time.Time{}
+		_apiKeyCache.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestParseAPIKey(t *testing.T) {
+	cases := map[string]bool{
+		"spk_abc_secret":      true,
+		"spk_abc_sec_ret":     true,
+		"abc_secret":          false,
+		"spk_abc":             false,
+		"spk__secret":         false,
+		"spk_abc_":            false,
+		"":                    false,
+		"Bearer spk_a_secret": false,
+	}
+	for key, valid := range cases {
+		if _, _, ok := _ParseAPIKey(key); ok != valid {
+			t.Errorf("%q: expected valid %v", key, valid)
+		}
+	}
+	id, secret, _ := _ParseAPIKey("spk_abc_sec_ret")
+	if id != "abc" || secret != "sec_ret" {
+		t.Errorf("unexpected id %q and secret %q", id, secret)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	f := withFakeStorager(t)
+	withEmptyAPIKeyCache(t)
+	ctx := context.Background()
+
+	key, stored, err := NewAPIKey(ctx, "alice", "ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, err := Authenticate(ctx, key); err != nil || k.OwnerId != "alice" {
+		t.Fatalf("expected the key of alice, got %+v %v", k, err)
+	}
+	if _, err := Authenticate(ctx, key+"x"); err != ErrInvalidAPIKey {
+		t.Errorf("a wrong secret should be rejected, got %v", err)
+	}
+	if _, err := Authenticate(ctx, "spk_unknown_secret"); err != ErrInvalidAPIKey {
+		t.Errorf("an unknown key should be rejected, got %v", err)
+	}
+	if _, err := Authenticate(ctx, "not-a-key"); err != ErrInvalidAPIKey {
+		t.Errorf("a malformed key should be rejected, got %v", err)
+	}
+
+	f.failNext("GetAPIKey", errors.New("throttled"))
+	_apiKeyCache.Lock()
+	delete(_apiKeyCache.byId, stored.Id)
+	_apiKeyCache.Unlock()
+	if _, err := Authenticate(ctx, key); err == nil || err == ErrInvalidAPIKey {
+		t.Errorf("storage errors should not look like an invalid key, got %v", err)
+	}
+
+	if err := RevokeAPIKey(ctx, stored.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Authenticate(ctx, key); err != ErrInvalidAPIKey {
+		t.Errorf("a revoked key should be rejected, got %v", err)
+	}
+}
+
+func TestAuthenticateCache(t *testing.T) {
+	f := withFakeStorager(t)
+	withEmptyAPIKeyCache(t)
+	ctx := context.Background()
+
+	key, stored, err := NewAPIKey(ctx, "alice", "ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := Authenticate(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls := f.callCount("GetAPIKey"); calls != 1 {
+		t.Errorf("the key should be loaded once, got %v loads", calls)
+	}
+
+	// another instance revoked the key, this one only notices after the cache expires
+	f.apiKeys[stored.Id].Revoked = true
+	if _, err := Authenticate(ctx, key); err != nil {
+		t.Errorf("the cached key should still be valid, got %v", err)
+	}
+	_apiKeyCache.Lock()
+	cached := _apiKeyCache.byId[stored.Id]
+	cached.expires = time.Now().Add(-time.Second)
+	_apiKeyCache.byId[stored.Id] = cached
+	_apiKeyCache.Unlock()
+	if _, err := Authenticate(ctx, key); err != ErrInvalidAPIKey {
+		t.Errorf("the revocation should be seen after the cache expires, got %v", err)
+	}
+}
+
+func TestCleanupAPIKeyCache(t *testing.T) {
+	withEmptyAPIKeyCache(t)
+	now := time.Now()
+	_apiKeyCache.Lock()
+	defer _apiKeyCache.Unlock()
+	_apiKeyCache.byId["stale"] = _cachedAPIKey{key: &APIKey{Id: "stale"}, expires: now.Add(-time.Second)}
+	_apiKeyCache.byId["fresh"] = _cachedAPIKey{key: &APIKey{Id: "fresh"}, expires: now.Add(time.Minute)}
+
+	_CleanupAPIKeyCache(now)
+	if _, ok := _apiKeyCache.byId["stale"]; ok {
+		t.Error("the stale key should be dropped")
+	}
+	if _, ok := _apiKeyCache.byId["fresh"]; !ok {
+		t.Error("the fresh key should be kept")
+	}
+
+	_apiKeyCache.byId["stale"] = _cachedAPIKey{key: &APIKey{Id: "stale"}, expires: now.Add(-time.Second)}
+	_CleanupAPIKeyCache(now.Add(time.Second))
+	if _, ok := _apiKeyCache.byId["stale"]; !ok {
+		t.Error("the cache should not be swept again within the interval")
+	}
+}
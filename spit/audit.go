@@ -0,0 +1,62 @@
+package spit
+
+import (
+	"context"
+	"encoding/hex"
+	"time"
+)
+
+// the actions of the moderators recorded in the audit log
+const (
+	AUDIT_ACTION_LOOKUP         string = "lookup"
+	AUDIT_ACTION_DISABLE        string = "disable"
+	AUDIT_ACTION_ENABLE         string = "enable"
+	AUDIT_ACTION_DISABLE_DOMAIN string = "disable_domain"
+	// AUDIT_ACTION_DISABLE_DOMAIN_DONE records the spits the background job of a disable_domain disabled
+	AUDIT_ACTION_DISABLE_DOMAIN_DONE string = "disable_domain_done"
+	// AUDIT_ACTION_FAILED follows up an action that was recorded but then failed, the note has the cause
+	AUDIT_ACTION_FAILED string = "failed"
+
+	AUDIT_LIST_MAX_LIMIT int = 1000
+)
+
+// AuditEntry records one action of a moderator, the entries are grouped by day.
+type AuditEntry struct {
+	Day string `json:"day"`
+	// Id sorts the entries of the day chronologically
+	Id     string   `json:"id"`
+	Date   string   `json:"date"`
+	Actor  string   `json:"actor"`
+	Action string   `json:"action"`
+	SpitId string   `json:"spit_id,omitempty"`
+	Domain string   `json:"domain,omitempty"`
+	Reason string   `json:"reason,omitempty"`
+	Note   string   `json:"note,omitempty"`
+	Spits  []string `json:"spits,omitempty"`
+	// Job is the background job of the action, if it runs in one
+	Job string `json:"job,omitempty"`
+}
+
+// Audit records the action in the audit log.
+func Audit(ctx context.Context, e *AuditEntry) error {
+	now := time.Now().UTC()
+	suffix, err := _RandomString(4, hex.EncodeToString)
+	if err != nil {
+		return err
+	}
+	e.Day = now.Format(time.DateOnly)
+	e.Date = now.Format(time.RFC3339Nano)
+	e.Id = now.Format("15:04:05.000000000") + "#" + suffix
+	return storager.PutAudit(ctx, e)
+}
+
+// ListAudit returns up to limit entries of the day (YYYY-MM-DD) newest first.
+func ListAudit(ctx context.Context, day string, limit int) ([]*AuditEntry, error) {
+	if _, err := time.Parse(time.DateOnly, day); err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > AUDIT_LIST_MAX_LIMIT {
+		limit = AUDIT_LIST_MAX_LIMIT
+	}
+	return storager.ListAudit(ctx, day, limit)
+}
@@ -1,33 +1,66 @@
 package spit
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"math/rand"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/lambrospetrou/spito/ids"
+	"github.com/lambrospetrou/spito/tracing"
 	"github.com/lambrospetrou/spito/utils"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 ///////////////////////////////////////////////////////////////////////
 
+var (
+	_TABLE_NAME_SPITS_DATA = utils.EnvString("SPITO_TABLE_SPITS_DATA", "SpitsData")
+	_TABLE_NAME_SPITS_META = utils.EnvString("SPITO_TABLE_SPITS_META", "SpitsMeta")
+
+	// _STORAGE_OP_TIMEOUT is the default deadline of the storage operations serving a request
+	_STORAGE_OP_TIMEOUT = utils.EnvDuration("SPITO_STORAGE_TIMEOUT", 3*time.Second)
+	// _STORAGE_OP_TIMEOUTS overrides the deadline per operation, the scans get a deadline per page
+	_STORAGE_OP_TIMEOUTS, _storageOpTimeoutsErr = _ParseOpTimeouts(utils.EnvString("SPITO_STORAGE_OP_TIMEOUTS", ""))
+
+	// _LEGACY_KEYS_READ falls back to the legacy items when a Spit is not found,
+	// it can be turned off once `spito migrate-keys` has finished.
+	_LEGACY_KEYS_READ = utils.EnvBool("SPITO_LEGACY_KEYS_READ", true)
+)
+
 const (
-	// TODO read the following parameters from the environment of the application
-	_TABLE_NAME_SPITS_DATA     = "SpitsData"
-	_TABLE_NAME_SPITS_META     = "SpitsMeta"
-	_SPIT_ID_CNT_TOTAL     int = 4
+	_SPIT_ID_CNT_TOTAL int = 4
 
 	_SPIT_ID_CNT_PREFIX   string = "spit::cnt::"
 	_SPIT_ID_CHARS_PREFIX string = "spit::chars::"
-	_SPIT_KEY_PREFIX      string = "spit::id::"
+	_SPIT_LEASE_PREFIX    string = "spit::lease::"
+
+	// _SPIT_KEY_PREFIX was prepended to the ids of the legacy Spit items,
+	// it is only used to read them until they are migrated.
+	_SPIT_KEY_PREFIX string = "spit::id::"
+	// the version of the Spit items, legacy items do not have it
+	_SPIT_RECORD_VERSION           int    = 2
+	_SPIT_RECORD_VERSION_ATTRIBUTE string = "v"
+
+	// the attribute holding the expiration in epoch seconds used by DynamoDB TTL
+	_TTL_ATTRIBUTE_NAME string = "ttl"
+
+	// the index of the Spits by owner_id, sorted by date_created
+	_OWNER_INDEX_NAME string = "owner_id-date_created-index"
+	// the index of the Spits by workspace_id, sorted by date_created
+	_WORKSPACE_INDEX_NAME string = "workspace_id-date_created-index"
 )
 
 type awsDynamoDBStorager struct {
@@ -53,7 +86,7 @@ type _SpitIdCharModel struct {
 // init() will try to fetch the sequence generators to be used when encoding the ids.
 // If no sequence exists in the database new ones will be created.
 func (p *awsDynamoDBStorager) init() {
-	log.Println("dynamo_adapter::init()")
+	ctx := context.Background()
 
 	// Create the key generators
 	// 62 valid characters A-Za-z0-9
@@ -62,7 +95,7 @@ func (p *awsDynamoDBStorager) init() {
 	for i := 0; i < _SPIT_ID_CNT_TOTAL; i++ {
 		chars = append(chars, utils.ShuffleString(base62Chars))
 	}
-	log.Println("Will try to use: ", chars)
+	slog.Debug("loading the id generators", "candidates", chars)
 
 	finalChars := make([]string, 0)
 
@@ -72,7 +105,6 @@ func (p *awsDynamoDBStorager) init() {
 		charsNew := chars[i]
 		item, _ := dynamodbattribute.Marshal(&_SpitIdCharModel{key, charsNew})
 
-		log.Println("Trying item:", item)
 		params := &dynamodb.PutItemInput{
 			Item:                item.M,
 			TableName:           aws.String(_TABLE_NAME_SPITS_META),
@@ -82,35 +114,83 @@ func (p *awsDynamoDBStorager) init() {
 			},
 			ReturnValues: aws.String("ALL_OLD"),
 		}
-		resp, err := p.svc.PutItem(params)
+		_, err := p.svc.PutItemWithContext(ctx, params)
 		if err == nil {
 			// Added the new character sequence
 			finalChars = append(finalChars, charsNew)
 		} else {
 			// Print the error, cast err to awserr.Error to get the Code and Message from an error.
-			log.Println("dynamo_adapter::init::", err.Error(), resp)
+			slog.ErrorContext(ctx, "dynamodb request failed", "op", "init", "err", err)
 
 			// Try to get the Item since we failed to put it
 			charExisting := &_SpitIdCharModel{}
-			err = p.GetRaw(_TABLE_NAME_SPITS_META, "key", key, charExisting)
+			err = p.GetRaw(ctx, _TABLE_NAME_SPITS_META, "key", key, charExisting)
 			if err != nil {
-				log.Fatalln("Failed to get the existing ID Char generators from DB.")
-				return
+				slog.Error("could not get the existing id generators", "key", key, "err", err)
+				os.Exit(1)
 			}
 			finalChars = append(finalChars, charExisting.Value)
 		}
 	}
-	log.Println("Final sequence generators used: ", finalChars)
+	slog.Debug("loaded the id generators", "generators", finalChars)
 	// Initialize the ID generator
 	ids.InitWith(finalChars...)
 }
 
+// _ParseOpTimeouts() parses the deadlines of SPITO_STORAGE_OP_TIMEOUTS, e.g. "Get=1s,ScanURLs=1m",
+// on top of the defaults of the slow operations.
+func _ParseOpTimeouts(s string) (map[string]time.Duration, error) {
+	timeouts := map[string]time.Duration{
+		"ScanURLs":      30 * time.Second,
+		"DeleteExpired": 30 * time.Second,
+	}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+		op, value, ok := strings.Cut(entry, "=")
+		timeout, err := time.ParseDuration(strings.TrimSpace(value))
+		if !ok || err != nil || timeout <= 0 {
+			return timeouts, fmt.Errorf("invalid storage timeout %q, expected operation=duration", entry)
+		}
+		timeouts[strings.TrimSpace(op)] = timeout
+	}
+	return timeouts, nil
+}
+
+// _OpContext() bounds the storage operation by its deadline.
+func _OpContext(ctx context.Context, op string) (context.Context, context.CancelFunc) {
+	timeout, ok := _STORAGE_OP_TIMEOUTS[op]
+	if !ok {
+		timeout = _STORAGE_OP_TIMEOUT
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+func _IsAwsErrorCode(err error, code string) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == code
+}
+
+func _IsConditionalCheckFailed(err error) bool {
+	return _IsAwsErrorCode(err, dynamodb.ErrCodeConditionalCheckFailedException)
+}
+
+// _WrapAwsError() marks the errors that are worth retrying as a TransientError.
+func _WrapAwsError(err error) error {
+	if request.IsErrorThrottle(err) || request.IsErrorRetryable(err) {
+		return &TransientError{err}
+	}
+	return err
+}
+
 func _BuildSpitFromDynamo(dbAttrValue map[string]*dynamodb.AttributeValue, ns *Spit) (*Spit, error) {
 	if ns == nil {
 		ns = &Spit{}
 	}
 	if err := dynamodbattribute.UnmarshalMap(dbAttrValue, ns); err != nil {
-		log.Println("Error while unmarshalling DynamoDB item: ", err)
+		slog.Error("could not unmarshal dynamodb item", "err", err)
 		return nil, err
 	}
 	return ns, nil
@@ -119,78 +199,294 @@ func _BuildSpitFromDynamo(dbAttrValue map[string]*dynamodb.AttributeValue, ns *S
 func _BuildDynamoAtributeValueFromSpit(s *Spit) *dynamodb.AttributeValue {
 	av, err := dynamodbattribute.Marshal(s)
 	if err != nil {
-		log.Println("Error while marshalling Spit to DynamoDB item: ", err)
+		slog.Error("could not marshal spit to dynamodb item", "err", err, "spit", s)
 		return nil
 	}
 	return av
 }
 
-func (p *awsDynamoDBStorager) Put(s *Spit) error {
+// _BuildSpitItem() builds the current layout of the Spit item.
+func _BuildSpitItem(s *Spit) (map[string]*dynamodb.AttributeValue, error) {
 	av := _BuildDynamoAtributeValueFromSpit(s)
 	if av == nil {
-		return errors.New("dynamo_adapter::Put::Could not marshal Spit")
+		return nil, errors.New("dynamo_adapter::_BuildSpitItem::Could not marshal Spit")
+	}
+	av.M[_SPIT_RECORD_VERSION_ATTRIBUTE] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(_SPIT_RECORD_VERSION))}
+	if ttl := _SpitTTL(s); ttl > 0 {
+		av.M[_TTL_ATTRIBUTE_NAME] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(ttl, 10))}
+	}
+	return av.M, nil
+}
+
+// _SpitTTL() returns the expiration in epoch seconds, 0 if the Spit never expires.
+// DynamoDB TTL only works with epoch seconds, not the RFC3339 expiration date.
+func _SpitTTL(s *Spit) int64 {
+	if !s.Expires() {
+		return 0
+	}
+	timeThen, err := time.Parse(time.RFC3339, s.DateExpiration)
+	if err != nil {
+		return 0
 	}
+	return timeThen.Unix()
+}
 
+func (p *awsDynamoDBStorager) Put(ctx context.Context, s *Spit) error {
+	ctx, cancel := _OpContext(ctx, "Put")
+	defer cancel()
+	item, err := _BuildSpitItem(s)
+	if err != nil {
+		return err
+	}
+
+	// never overwrite the Spit of someone else if the id is reused
 	params := &dynamodb.PutItemInput{
-		Item:      av.M,
-		TableName: aws.String(_TABLE_NAME_SPITS_DATA),
+		Item:                     item,
+		TableName:                aws.String(_TABLE_NAME_SPITS_DATA),
+		ConditionExpression:      aws.String("attribute_not_exists(#id)"),
+		ExpressionAttributeNames: map[string]*string{"#id": aws.String("id")},
 	}
-	resp, err := p.svc.PutItem(params)
-	if err != nil {
+	if _, err := p.svc.PutItemWithContext(ctx, params); err != nil {
+		if _IsConditionalCheckFailed(err) {
+			return ErrSpitIdTaken
+		}
 		// Print the error, cast err to awserr.Error to get the Code and Message from an error.
-		log.Println("dynamo_adapter::Put::", err.Error(), resp)
-		return err
+		slog.ErrorContext(ctx, "dynamodb request failed", "op", "Put", "err", err)
+		return _WrapAwsError(err)
 	}
 	return nil
 }
 
-func (p *awsDynamoDBStorager) Get(key string) (*Spit, error) {
+func _BuildLegacySpitKey(id string) string {
+	return _SPIT_KEY_PREFIX + id
+}
+
+func _BuildSpitKeyAttribute(key string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"id": {
+			S: aws.String(key),
+		},
+	}
+}
+
+// getItem() returns the Spit with the given id and the key of its item.
+// During the key migration it falls back to the legacy item with the prefixed key.
+func (p *awsDynamoDBStorager) getItem(ctx context.Context, id string) (*Spit, string, error) {
+	key := id
 	s := &Spit{}
-	err := p.GetRaw(_TABLE_NAME_SPITS_DATA, "id", key, s)
+	err := p.GetRaw(ctx, _TABLE_NAME_SPITS_DATA, "id", key, s)
+	if _, ok := err.(DynamoDbItemNotFoundError); ok && _LEGACY_KEYS_READ {
+		key = _BuildLegacySpitKey(id)
+		s = &Spit{}
+		err = p.GetRaw(ctx, _TABLE_NAME_SPITS_DATA, "id", key, s)
+	}
 	if err != nil {
 		// Only log if it is an error, not just Item not Found
 		if _, ok := err.(DynamoDbItemNotFoundError); !ok {
-			log.Println("dynamo_adapter::Get::", err)
+			slog.ErrorContext(ctx, "dynamodb request failed", "op", "getItem", "err", err)
 		}
-		return nil, err
+		return nil, "", err
 	}
+	s.Id = id
 
-	// Check the expiration date and delete it if necessary
-	timeNow := time.Now().UTC()
-	timeThen, _ := time.Parse(time.RFC3339, s.DateExpiration)
-	if s.Exp > 0 && timeThen.Before(timeNow) {
+	// Check the expiration date and delete it if necessary.
+	if _IsExpiredAt(s, time.Now().UTC()) {
 		// delete the item and return nil
-		params := &dynamodb.DeleteItemInput{
-			Key: map[string]*dynamodb.AttributeValue{ // Required
-				"id": {
-					S: aws.String(key),
-				},
-			},
-			TableName: aws.String(_TABLE_NAME_SPITS_DATA),
+		if err := p.deleteItem(ctx, key); err != nil {
+			slog.ErrorContext(ctx, "dynamodb request failed", "op", "getItem", "err", err)
+			return nil, "", err
 		}
-		resp, err := p.svc.DeleteItem(params)
+		return nil, "", DynamoDbItemNotFoundError{fmt.Sprintf("%v:%v:%v expired", _TABLE_NAME_SPITS_DATA, "id", key)}
+	}
+	return s, key, nil
+}
+
+func (p *awsDynamoDBStorager) Get(ctx context.Context, id string) (*Spit, error) {
+	ctx, cancel := _OpContext(ctx, "Get")
+	defer cancel()
+	s, _, err := p.getItem(ctx, id)
+	return s, err
+}
+
+func (p *awsDynamoDBStorager) deleteItem(ctx context.Context, key string) error {
+	params := &dynamodb.DeleteItemInput{
+		Key:       _BuildSpitKeyAttribute(key), // Required
+		TableName: aws.String(_TABLE_NAME_SPITS_DATA),
+	}
+	_, err := p.svc.DeleteItemWithContext(ctx, params)
+	if err != nil {
+		// Print the error, cast err to awserr.Error to get the Code and Message from an error.
+		slog.ErrorContext(ctx, "dynamodb request failed", "op", "deleteItem", "err", err)
+		return err
+	}
+	return nil
+}
+
+func (p *awsDynamoDBStorager) Delete(ctx context.Context, id string) error {
+	ctx, cancel := _OpContext(ctx, "Delete")
+	defer cancel()
+	if err := p.deleteItem(ctx, id); err != nil {
+		return err
+	}
+	if _LEGACY_KEYS_READ {
+		return p.deleteItem(ctx, _BuildLegacySpitKey(id))
+	}
+	return nil
+}
+
+// _IsExpiredAt() checks the expiration of the item since
+// DynamoDB TTL deletes expired items within a few days, until then they are still returned.
+func _IsExpiredAt(s *Spit, now time.Time) bool {
+	timeThen, _ := time.Parse(time.RFC3339, s.DateExpiration)
+	return s.Exp > 0 && (timeThen.Before(now) || (s.TTL > 0 && s.TTL <= now.Unix()))
+}
+
+func (p *awsDynamoDBStorager) ListByOwner(ctx context.Context, ownerId string, spitType string, after *ListPosition, limit int) ([]*Spit, bool, error) {
+	return p.listByIndex(ctx, "ListByOwner", _OWNER_INDEX_NAME, "owner_id", ownerId, spitType, after, limit)
+}
+
+func (p *awsDynamoDBStorager) ListByWorkspace(ctx context.Context, workspaceId string, spitType string, after *ListPosition, limit int) ([]*Spit, bool, error) {
+	return p.listByIndex(ctx, "ListByWorkspace", _WORKSPACE_INDEX_NAME, "workspace_id", workspaceId, spitType, after, limit)
+}
+
+// listByIndex() queries the index by the given attribute newest first, skipping the expired Spits.
+// The filtered and expired Spits still count against the limit of every query,
+// so it keeps querying until the page is full or the index is exhausted.
+func (p *awsDynamoDBStorager) listByIndex(ctx context.Context, op string, indexName string, partitionKey string, value string,
+	spitType string, after *ListPosition, limit int) ([]*Spit, bool, error) {
+	ctx, cancel := _OpContext(ctx, op)
+	defer cancel()
+
+	names := map[string]*string{"#partition": aws.String(partitionKey)}
+	values := map[string]*dynamodb.AttributeValue{":partition": {S: aws.String(value)}}
+	var filter *string
+	if len(spitType) > 0 {
+		filter = aws.String("#type = :type")
+		names["#type"] = aws.String("spit_type")
+		values[":type"] = &dynamodb.AttributeValue{S: aws.String(spitType)}
+	}
+	var startKey map[string]*dynamodb.AttributeValue
+	if after != nil {
+		startKey = map[string]*dynamodb.AttributeValue{
+			"id":           {S: aws.String(after.Id)},
+			partitionKey:   {S: aws.String(value)},
+			"date_created": {S: aws.String(after.DateCreated)},
+		}
+	}
+
+	now := time.Now().UTC()
+	spits := make([]*Spit, 0, limit)
+	for {
+		resp, err := p.svc.QueryWithContext(ctx, &dynamodb.QueryInput{
+			TableName:                 aws.String(_TABLE_NAME_SPITS_DATA),
+			IndexName:                 aws.String(indexName),
+			KeyConditionExpression:    aws.String("#partition = :partition"),
+			FilterExpression:          filter,
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+			ScanIndexForward:          aws.Bool(false),
+			Limit:                     aws.Int64(int64(limit - len(spits))),
+			ExclusiveStartKey:         startKey,
+		})
 		if err != nil {
-			// Print the error, cast err to awserr.Error to get the Code and Message from an error.
-			log.Println("dynamo_adapter::Get::", err.Error(), resp)
-			return nil, err
+			slog.ErrorContext(ctx, "dynamodb request failed", "op", op, "err", err)
+			return nil, false, _WrapAwsError(err)
+		}
+		for _, item := range resp.Items {
+			s, err := _BuildSpitFromDynamo(item, nil)
+			if err != nil {
+				return nil, false, err
+			}
+			if !_IsExpiredAt(s, now) {
+				spits = append(spits, s)
+			}
+		}
+		if len(resp.LastEvaluatedKey) == 0 {
+			return spits, false, nil
 		}
-		return nil, errors.New("Spit expired!")
+		if len(spits) >= limit {
+			return spits, true, nil
+		}
+		startKey = resp.LastEvaluatedKey
 	}
-	return s, nil
 }
 
-func (p *awsDynamoDBStorager) GetWithAnalytics(key string) (*Spit, error) {
-	_, err := p.Get(key)
+// Update() changes the content and the expiration of an existing Spit,
+// leaving its counters untouched.
+func (p *awsDynamoDBStorager) Update(ctx context.Context, s *Spit) error {
+	ctx, cancel := _OpContext(ctx, "Update")
+	defer cancel()
+	params := _BuildUpdateInput(s)
+	if _, err := p.svc.UpdateItemWithContext(ctx, params); err != nil {
+		if _IsConditionalCheckFailed(err) {
+			return DynamoDbItemNotFoundError{fmt.Sprintf("%v:%v:%v", _TABLE_NAME_SPITS_DATA, "id", s.Id)}
+		}
+		slog.ErrorContext(ctx, "dynamodb request failed", "op", "Update", "err", err)
+		return _WrapAwsError(err)
+	}
+	return nil
+}
+
+// _BuildUpdateInput() only names the attributes its expression uses, since DynamoDB rejects
+// the requests with unused names.
+func _BuildUpdateInput(s *Spit) *dynamodb.UpdateItemInput {
+	dateExpiration := &dynamodb.AttributeValue{NULL: aws.Bool(true)}
+	if len(s.DateExpiration) > 0 {
+		dateExpiration = &dynamodb.AttributeValue{S: aws.String(s.DateExpiration)}
+	}
+	updateExpression := "SET #content = :content, #exp = :exp, #dateExp = :dateExp"
+	names := map[string]*string{
+		"#id":      aws.String("id"),
+		"#content": aws.String("content"),
+		"#exp":     aws.String("exp"),
+		"#dateExp": aws.String("date_expiration"),
+	}
+	values := map[string]*dynamodb.AttributeValue{
+		":content": {S: aws.String(s.Content)},
+		":exp":     {N: aws.String(strconv.Itoa(s.Exp))},
+		":dateExp": dateExpiration,
+	}
+	removes := make([]string, 0, 2)
+	if ttl := _SpitTTL(s); ttl > 0 {
+		updateExpression += ", #ttl = :ttl"
+		names["#ttl"] = aws.String(_TTL_ATTRIBUTE_NAME)
+		values[":ttl"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(ttl, 10))}
+	} else {
+		removes = append(removes, "#ttl")
+		names["#ttl"] = aws.String(_TTL_ATTRIBUTE_NAME)
+	}
+	// new content has not been reviewed by the moderators
+	if !s.Reviewed {
+		removes = append(removes, "#reviewed")
+		names["#reviewed"] = aws.String("reviewed")
+	}
+	if len(removes) > 0 {
+		updateExpression += " REMOVE " + strings.Join(removes, ", ")
+	}
+
+	return &dynamodb.UpdateItemInput{
+		Key:                       _BuildSpitKeyAttribute(s.Id),
+		UpdateExpression:          aws.String(updateExpression),
+		ConditionExpression:       aws.String("attribute_exists(#id)"),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		TableName:                 aws.String(_TABLE_NAME_SPITS_DATA),
+	}
+}
+
+func (p *awsDynamoDBStorager) GetWithAnalytics(ctx context.Context, id string) (*Spit, error) {
+	ctx, cancel := _OpContext(ctx, "GetWithAnalytics")
+	defer cancel()
+	s, key, err := p.getItem(ctx, id)
 	if err != nil {
 		return nil, err
 	}
+	if s.MaxViews > 0 {
+		return p.consumeView(ctx, id, key)
+	}
 	// Update the clicks
 	params := &dynamodb.UpdateItemInput{
-		Key: map[string]*dynamodb.AttributeValue{ // Required
-			"id": {
-				S: aws.String(key),
-			},
-		},
+		Key: _BuildSpitKeyAttribute(key), // Required
 		AttributeUpdates: map[string]*dynamodb.AttributeValueUpdate{
 			"metric_clicks": {
 				Action: aws.String("ADD"),
@@ -202,17 +498,60 @@ func (p *awsDynamoDBStorager) GetWithAnalytics(key string) (*Spit, error) {
 		ReturnValues: aws.String("ALL_NEW"),
 		TableName:    aws.String(_TABLE_NAME_SPITS_DATA),
 	}
-	resp, err := p.svc.UpdateItem(params)
+	resp, err := p.svc.UpdateItemWithContext(ctx, params)
 	if err != nil {
 		// Print the error, cast err to awserr.Error to get the Code and Message from an error.
-		log.Println("dynamo_adapter::GetWithAnalytics::", err.Error(), resp)
+		slog.ErrorContext(ctx, "dynamodb request failed", "op", "GetWithAnalytics", "err", err)
 		return nil, err
 	}
 
-	return _BuildSpitFromDynamo(resp.Attributes, nil)
+	if s, err = _BuildSpitFromDynamo(resp.Attributes, nil); err != nil {
+		return nil, err
+	}
+	s.Id = id
+	return s, nil
 }
 
-func (p *awsDynamoDBStorager) GetRaw(tableName string, keyName string, keyValue string, o interface{}) error {
+// consumeView() counts the view of a Spit with limited views.
+// The decrement is conditional so concurrent readers cannot both get the last view,
+// and the reader that got the last view deletes the Spit.
+func (p *awsDynamoDBStorager) consumeView(ctx context.Context, id string, key string) (*Spit, error) {
+	params := &dynamodb.UpdateItemInput{
+		Key:                 _BuildSpitKeyAttribute(key), // Required
+		UpdateExpression:    aws.String("SET views_left = views_left - :one ADD metric_clicks :one"),
+		ConditionExpression: aws.String("views_left > :zero"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one":  {N: aws.String("1")},
+			":zero": {N: aws.String("0")},
+		},
+		ReturnValues: aws.String("ALL_NEW"),
+		TableName:    aws.String(_TABLE_NAME_SPITS_DATA),
+	}
+	resp, err := p.svc.UpdateItemWithContext(ctx, params)
+	if err != nil {
+		if _IsConditionalCheckFailed(err) {
+			// someone else got the last view
+			return nil, DynamoDbItemNotFoundError{fmt.Sprintf("%v:%v:%v", _TABLE_NAME_SPITS_DATA, "id", key)}
+		}
+		slog.ErrorContext(ctx, "dynamodb request failed", "op", "consumeView", "err", err)
+		return nil, err
+	}
+
+	s, err := _BuildSpitFromDynamo(resp.Attributes, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.Id = id
+	if s.ViewsLeft <= 0 {
+		// the view is already ours so failing to delete only leaves an unreachable item behind
+		if err := p.deleteItem(ctx, key); err != nil {
+			slog.ErrorContext(ctx, "dynamodb request failed", "op", "consumeView", "err", err)
+		}
+	}
+	return s, nil
+}
+
+func (p *awsDynamoDBStorager) GetRaw(ctx context.Context, tableName string, keyName string, keyValue string, o interface{}) error {
 	params := &dynamodb.QueryInput{
 		KeyConditionExpression: aws.String("#idName = :idVal"),
 		ExpressionAttributeNames: map[string]*string{
@@ -225,23 +564,26 @@ func (p *awsDynamoDBStorager) GetRaw(tableName string, keyName string, keyValue
 		},
 		TableName: aws.String(tableName),
 	}
-	resp, err := p.svc.Query(params)
+	resp, err := p.svc.QueryWithContext(ctx, params)
 	if err != nil {
 		// Print the error, cast err to awserr.Error to get the Code and Message from an error.
-		log.Println("dynamo_adapter::GetRaw::", err.Error())
+		slog.ErrorContext(ctx, "dynamodb request failed", "op", "GetRaw", "err", err)
 		return err
 	}
 	if len(resp.Items) == 0 {
 		return DynamoDbItemNotFoundError{fmt.Sprintf("%v:%v:%v", tableName, keyName, keyValue)}
 	}
 	if err := dynamodbattribute.UnmarshalMap(resp.Items[0], o); err != nil {
-		log.Println("dynamo_adapter::GetRaw::", "Error while unmarshalling DynamoDB item: ", err)
+		slog.ErrorContext(ctx, "could not unmarshal dynamodb item", "op", "GetRaw", "err", err)
 		return err
 	}
 	return nil
 }
 
-func (p *awsDynamoDBStorager) FAI(tableName string, keyName string, keyValue string, valueName string, diff int) (int, error) {
+func (p *awsDynamoDBStorager) FAI(ctx context.Context, tableName string, keyName string, keyValue string, valueName string, diff int) (_ int, err error) {
+	ctx, span := tracing.Start(ctx, "spit.FAI", attribute.String("spito.counter", keyValue))
+	defer func() { tracing.End(span, err) }()
+
 	params := &dynamodb.UpdateItemInput{
 		Key: map[string]*dynamodb.AttributeValue{ // Required
 			keyName: {
@@ -259,24 +601,26 @@ func (p *awsDynamoDBStorager) FAI(tableName string, keyName string, keyValue str
 		ReturnValues: aws.String("ALL_NEW"),
 		TableName:    aws.String(tableName),
 	}
-	resp, err := p.svc.UpdateItem(params)
+	resp, err := p.svc.UpdateItemWithContext(ctx, params)
 
 	if err != nil {
 		// Print the error, cast err to awserr.Error to get the Code and Message from an error.
-		log.Println("dynamo_adapter::FAI::", err.Error())
-		return 0, err
+		slog.ErrorContext(ctx, "dynamodb request failed", "op", "FAI", "err", err)
+		return 0, _WrapAwsError(err)
 	}
 
 	defaultValue := 0
 	if err := dynamodbattribute.Unmarshal(resp.Attributes[valueName], &defaultValue); err != nil {
-		log.Println("dynamo_adapter::FAI::", "Error while unmarshalling DynamoDB item: ", err)
+		slog.ErrorContext(ctx, "could not unmarshal dynamodb item", "op", "FAI", "err", err)
 		return 0, nil
 	}
 	return defaultValue, nil
 }
 
 // NextId() generates the next unique ID to be used as id
-func (p *awsDynamoDBStorager) NextId() (string, error) {
+func (p *awsDynamoDBStorager) NextId(ctx context.Context) (string, error) {
+	ctx, cancel := _OpContext(ctx, "NextId")
+	defer cancel()
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
 	cntTotal := _SPIT_ID_CNT_TOTAL
 	cntInc := r.Intn(cntTotal) + 1
@@ -287,7 +631,7 @@ func (p *awsDynamoDBStorager) NextId() (string, error) {
 			diff = 1
 		}
 		// increase the counter selected randomly only
-		cntCurrent, err := p.FAI(_TABLE_NAME_SPITS_META, "key", _SPIT_ID_CNT_PREFIX+strconv.Itoa(i), "value", diff)
+		cntCurrent, err := p.FAI(ctx, _TABLE_NAME_SPITS_META, "key", _SPIT_ID_CNT_PREFIX+strconv.Itoa(i), "value", diff)
 		if err != nil {
 			return "-_-INVALID-_-", err
 		}
@@ -296,3 +640,207 @@ func (p *awsDynamoDBStorager) NextId() (string, error) {
 	}
 	return nextId, nil
 }
+
+// _ScanCursor() is the position of the scan after the item, the Spits table has only a hash key.
+func _ScanCursor(item map[string]*dynamodb.AttributeValue) string {
+	if id, ok := item["id"]; ok && id.S != nil {
+		return *id.S
+	}
+	return ""
+}
+
+func _ScanStartKey(cursor string) map[string]*dynamodb.AttributeValue {
+	if len(cursor) == 0 {
+		return nil
+	}
+	return map[string]*dynamodb.AttributeValue{"id": {S: aws.String(cursor)}}
+}
+
+// DeleteExpired() scans the Spits table from the cursor a page at a time, every page with
+// its own deadline, until it deletes limit expired Spits or reaches the end of the table.
+// It returns the cursor to continue from, even on errors, empty at the end of the table.
+func (p *awsDynamoDBStorager) DeleteExpired(ctx context.Context, now time.Time, cursor string, limit int) (int, string, error) {
+	expiredCondition := aws.String("#exp > :zero AND #dateExp < :now")
+	expiredNames := map[string]*string{
+		"#exp":     aws.String("exp"),
+		"#dateExp": aws.String("date_expiration"),
+	}
+	expiredValues := map[string]*dynamodb.AttributeValue{
+		":zero": {N: aws.String("0")},
+		":now":  {S: aws.String(now.UTC().Format(time.RFC3339))},
+	}
+
+	params := &dynamodb.ScanInput{
+		TableName:            aws.String(_TABLE_NAME_SPITS_DATA),
+		ExclusiveStartKey:    _ScanStartKey(cursor),
+		FilterExpression:     expiredCondition,
+		ProjectionExpression: aws.String("#id"),
+		ExpressionAttributeNames: map[string]*string{
+			"#id":      aws.String("id"),
+			"#exp":     expiredNames["#exp"],
+			"#dateExp": expiredNames["#dateExp"],
+		},
+		ExpressionAttributeValues: expiredValues,
+	}
+	deleted := 0
+	for {
+		pageCtx, cancel := _OpContext(ctx, "DeleteExpired")
+		resp, err := p.svc.ScanWithContext(pageCtx, params)
+		if err != nil {
+			cancel()
+			slog.ErrorContext(ctx, "dynamodb request failed", "op", "DeleteExpired", "err", err)
+			return deleted, cursor, _WrapAwsError(err)
+		}
+		for _, item := range resp.Items {
+			// only delete it if it is still expired, some other instance might have already done it
+			params := &dynamodb.DeleteItemInput{
+				Key:                       map[string]*dynamodb.AttributeValue{"id": item["id"]},
+				TableName:                 aws.String(_TABLE_NAME_SPITS_DATA),
+				ConditionExpression:       expiredCondition,
+				ExpressionAttributeNames:  expiredNames,
+				ExpressionAttributeValues: expiredValues,
+			}
+			if _, err := p.svc.DeleteItemWithContext(pageCtx, params); err != nil && !_IsConditionalCheckFailed(err) {
+				cancel()
+				slog.ErrorContext(ctx, "dynamodb request failed", "op", "DeleteExpired", "err", err)
+				return deleted, cursor, _WrapAwsError(err)
+			} else if err == nil {
+				deleted++
+			}
+			cursor = _ScanCursor(item)
+			if deleted >= limit {
+				cancel()
+				return deleted, cursor, nil
+			}
+		}
+		cancel()
+		// an empty LastEvaluatedKey means we reached the end and start over next time
+		cursor = _ScanCursor(resp.LastEvaluatedKey)
+		if len(cursor) == 0 {
+			return deleted, "", nil
+		}
+		params.ExclusiveStartKey = resp.LastEvaluatedKey
+	}
+}
+
+// AcquireLease() takes the named lease in the meta table if nobody holds it, it expired
+// or it is already ours in which case it gets renewed. The cursor of the lease is kept.
+func (p *awsDynamoDBStorager) AcquireLease(ctx context.Context, name string, owner string, ttl time.Duration) (*Lease, error) {
+	ctx, cancel := _OpContext(ctx, "AcquireLease")
+	defer cancel()
+	now := time.Now()
+	params := &dynamodb.UpdateItemInput{
+		Key:                 map[string]*dynamodb.AttributeValue{"key": {S: aws.String(_SPIT_LEASE_PREFIX + name)}},
+		TableName:           aws.String(_TABLE_NAME_SPITS_META),
+		UpdateExpression:    aws.String("SET #value = :owner, #expires = :expires"),
+		ConditionExpression: aws.String("attribute_not_exists(#key) OR #expires < :now OR #value = :owner"),
+		ExpressionAttributeNames: map[string]*string{
+			"#key":     aws.String("key"),
+			"#expires": aws.String("expires"),
+			"#value":   aws.String("value"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now":     {N: aws.String(strconv.FormatInt(now.Unix(), 10))},
+			":expires": {N: aws.String(strconv.FormatInt(now.Add(ttl).Unix(), 10))},
+			":owner":   {S: aws.String(owner)},
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueAllNew),
+	}
+	resp, err := p.svc.UpdateItemWithContext(ctx, params)
+	if err != nil {
+		if _IsConditionalCheckFailed(err) {
+			return nil, nil
+		}
+		slog.ErrorContext(ctx, "dynamodb request failed", "op", "AcquireLease", "err", err)
+		return nil, _WrapAwsError(err)
+	}
+	lease := &Lease{Name: name, Owner: owner}
+	if c, ok := resp.Attributes["cursor"]; ok && c.S != nil {
+		lease.Cursor = *c.S
+	}
+	return lease, nil
+}
+
+// SaveLeaseCursor() stores the cursor with the lease as long as we still hold it.
+func (p *awsDynamoDBStorager) SaveLeaseCursor(ctx context.Context, lease *Lease, cursor string) error {
+	ctx, cancel := _OpContext(ctx, "SaveLeaseCursor")
+	defer cancel()
+	params := &dynamodb.UpdateItemInput{
+		Key:                 map[string]*dynamodb.AttributeValue{"key": {S: aws.String(_SPIT_LEASE_PREFIX + lease.Name)}},
+		TableName:           aws.String(_TABLE_NAME_SPITS_META),
+		ConditionExpression: aws.String("#value = :owner"),
+		ExpressionAttributeNames: map[string]*string{
+			"#value":  aws.String("value"),
+			"#cursor": aws.String("cursor"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":owner": {S: aws.String(lease.Owner)}},
+	}
+	if len(cursor) == 0 {
+		params.UpdateExpression = aws.String("REMOVE #cursor")
+	} else {
+		params.UpdateExpression = aws.String("SET #cursor = :cursor")
+		params.ExpressionAttributeValues[":cursor"] = &dynamodb.AttributeValue{S: aws.String(cursor)}
+	}
+	if _, err := p.svc.UpdateItemWithContext(ctx, params); err != nil {
+		if _IsConditionalCheckFailed(err) {
+			return ErrLeaseLost
+		}
+		slog.ErrorContext(ctx, "dynamodb request failed", "op", "SaveLeaseCursor", "err", err)
+		return _WrapAwsError(err)
+	}
+	lease.Cursor = cursor
+	return nil
+}
+
+// Ping() reads the key of the first id alphabet, a single small read on the meta table.
+func (p *awsDynamoDBStorager) Ping(ctx context.Context) error {
+	ctx, cancel := _OpContext(ctx, "Ping")
+	defer cancel()
+	_, err := p.svc.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:                aws.String(_TABLE_NAME_SPITS_META),
+		Key:                      map[string]*dynamodb.AttributeValue{"key": {S: aws.String(_SPIT_ID_CHARS_PREFIX + "1")}},
+		ProjectionExpression:     aws.String("#key"),
+		ExpressionAttributeNames: map[string]*string{"#key": aws.String("key")},
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "dynamodb request failed", "op", "Ping", "err", err)
+		return _WrapAwsError(err)
+	}
+	return nil
+}
+
+// EnableTTL() turns on DynamoDB TTL for the Spits table on the ttl attribute.
+// It does nothing if TTL is already enabled.
+func (p *awsDynamoDBStorager) EnableTTL(ctx context.Context) error {
+	descResp, err := p.svc.DescribeTimeToLiveWithContext(ctx, &dynamodb.DescribeTimeToLiveInput{
+		TableName: aws.String(_TABLE_NAME_SPITS_DATA),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "dynamodb request failed", "op", "EnableTTL", "err", err)
+		return err
+	}
+	if desc := descResp.TimeToLiveDescription; desc != nil && desc.TimeToLiveStatus != nil {
+		status := *desc.TimeToLiveStatus
+		if status == dynamodb.TimeToLiveStatusEnabled || status == dynamodb.TimeToLiveStatusEnabling {
+			if aws.StringValue(desc.AttributeName) != _TTL_ATTRIBUTE_NAME {
+				return fmt.Errorf("dynamo_adapter::EnableTTL::TTL already enabled on attribute %v",
+					aws.StringValue(desc.AttributeName))
+			}
+			slog.InfoContext(ctx, "TTL already enabled", "status", status)
+			return nil
+		}
+	}
+
+	params := &dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(_TABLE_NAME_SPITS_DATA),
+		TimeToLiveSpecification: &dynamodb.TimeToLiveSpecification{
+			AttributeName: aws.String(_TTL_ATTRIBUTE_NAME),
+			Enabled:       aws.Bool(true),
+		},
+	}
+	if _, err := p.svc.UpdateTimeToLiveWithContext(ctx, params); err != nil {
+		slog.ErrorContext(ctx, "dynamodb request failed", "op", "EnableTTL", "err", err)
+		return err
+	}
+	return nil
+}
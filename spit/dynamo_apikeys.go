@@ -0,0 +1,72 @@
+package spit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+const (
+	// the API keys are kept in the meta table next to the id generators
+	_API_KEY_KEY_PREFIX string = "spit::apikey::"
+)
+
+func (p *awsDynamoDBStorager) PutAPIKey(ctx context.Context, k *APIKey) error {
+	ctx, cancel := _OpContext(ctx, "PutAPIKey")
+	defer cancel()
+	item, err := dynamodbattribute.MarshalMap(k)
+	if err != nil {
+		return err
+	}
+	item["key"] = &dynamodb.AttributeValue{S: aws.String(_API_KEY_KEY_PREFIX + k.Id)}
+
+	params := &dynamodb.PutItemInput{
+		Item:                     item,
+		TableName:                aws.String(_TABLE_NAME_SPITS_META),
+		ConditionExpression:      aws.String("attribute_not_exists(#key)"),
+		ExpressionAttributeNames: map[string]*string{"#key": aws.String("key")},
+	}
+	if _, err := p.svc.PutItemWithContext(ctx, params); err != nil {
+		slog.ErrorContext(ctx, "dynamodb request failed", "op", "PutAPIKey", "err", err)
+		return _WrapAwsError(err)
+	}
+	return nil
+}
+
+func (p *awsDynamoDBStorager) GetAPIKey(ctx context.Context, id string) (*APIKey, error) {
+	ctx, cancel := _OpContext(ctx, "GetAPIKey")
+	defer cancel()
+	k := &APIKey{}
+	if err := p.GetRaw(ctx, _TABLE_NAME_SPITS_META, "key", _API_KEY_KEY_PREFIX+id, k); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (p *awsDynamoDBStorager) RevokeAPIKey(ctx context.Context, id string) error {
+	ctx, cancel := _OpContext(ctx, "RevokeAPIKey")
+	defer cancel()
+	key := _API_KEY_KEY_PREFIX + id
+	params := &dynamodb.UpdateItemInput{
+		Key:                      map[string]*dynamodb.AttributeValue{"key": {S: aws.String(key)}},
+		UpdateExpression:         aws.String("SET revoked = :revoked"),
+		ConditionExpression:      aws.String("attribute_exists(#key)"),
+		ExpressionAttributeNames: map[string]*string{"#key": aws.String("key")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":revoked": {BOOL: aws.Bool(true)},
+		},
+		TableName: aws.String(_TABLE_NAME_SPITS_META),
+	}
+	if _, err := p.svc.UpdateItemWithContext(ctx, params); err != nil {
+		if _IsConditionalCheckFailed(err) {
+			return DynamoDbItemNotFoundError{fmt.Sprintf("%v:%v:%v", _TABLE_NAME_SPITS_META, "key", key)}
+		}
+		slog.ErrorContext(ctx, "dynamodb request failed", "op", "RevokeAPIKey", "err", err)
+		return _WrapAwsError(err)
+	}
+	return nil
+}
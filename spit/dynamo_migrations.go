@@ -0,0 +1,312 @@
+package spit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lambrospetrou/spito/utils"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const (
+	_SPIT_SCHEMA_VERSION_KEY string = "spit::schema::version"
+)
+
+// the billing mode of the tables, PROVISIONED also uses the capacity units below
+var (
+	_DYNAMODB_BILLING_MODE = utils.EnvString("SPITO_DYNAMODB_BILLING_MODE", dynamodb.BillingModePayPerRequest)
+	_DYNAMODB_READ_UNITS   = utils.EnvInt("SPITO_DYNAMODB_READ_UNITS", 5)
+	_DYNAMODB_WRITE_UNITS  = utils.EnvInt("SPITO_DYNAMODB_WRITE_UNITS", 5)
+)
+
+type _SpitSchemaVersionModel struct {
+	Key   string `json:"key"`
+	Value int    `json:"value"`
+}
+
+// _dynamoMigration is one versioned step of the DynamoDB schema.
+// Every step has to be idempotent since concurrent or interrupted runs might repeat it.
+type _dynamoMigration struct {
+	version     int
+	description string
+	up          func(ctx context.Context, p *awsDynamoDBStorager) error
+}
+
+// _DynamoMigrations are applied in order, new steps are only ever appended.
+var _DynamoMigrations = []_dynamoMigration{
+	{1, "create the spits table", func(ctx context.Context, p *awsDynamoDBStorager) error {
+		return p.createTable(ctx, _TABLE_NAME_SPITS_DATA, "id", "")
+	}},
+	{2, "enable TTL on the spits table", func(ctx context.Context, p *awsDynamoDBStorager) error {
+		return p.EnableTTL(ctx)
+	}},
+	{3, "index the spits by owner", func(ctx context.Context, p *awsDynamoDBStorager) error {
+		// anonymous Spits have no owner_id so they are not listed
+		return p.createIndex(ctx, _OWNER_INDEX_NAME, "owner_id")
+	}},
+	{4, "index the spits by workspace", func(ctx context.Context, p *awsDynamoDBStorager) error {
+		return p.createIndex(ctx, _WORKSPACE_INDEX_NAME, "workspace_id")
+	}},
+}
+
+// Migrate() brings the tables to the latest schema version.
+// The version is recorded in the meta table so only the missing steps are applied.
+func (p *awsDynamoDBStorager) Migrate(ctx context.Context) error {
+	// the meta table keeps the schema version so it has to exist before anything else
+	if err := p.createTable(ctx, _TABLE_NAME_SPITS_META, "key", ""); err != nil {
+		return err
+	}
+
+	current, err := p.schemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "current schema version", "version", current)
+
+	for _, m := range _DynamoMigrations {
+		if m.version <= current {
+			continue
+		}
+		slog.InfoContext(ctx, "applying migration", "version", m.version, "description", m.description)
+		if err := m.up(ctx, p); err != nil {
+			return fmt.Errorf("migration %v (%v) failed: %v", m.version, m.description, err)
+		}
+		if err := p.setSchemaVersion(ctx, m.version); err != nil {
+			return err
+		}
+	}
+	slog.InfoContext(ctx, "schema is up to date")
+	return nil
+}
+
+func (p *awsDynamoDBStorager) schemaVersion(ctx context.Context) (int, error) {
+	version := &_SpitSchemaVersionModel{}
+	err := p.GetRaw(ctx, _TABLE_NAME_SPITS_META, "key", _SPIT_SCHEMA_VERSION_KEY, version)
+	if err != nil {
+		if _, ok := err.(DynamoDbItemNotFoundError); ok {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return version.Value, nil
+}
+
+// setSchemaVersion() never moves the version backwards in case of concurrent runs.
+func (p *awsDynamoDBStorager) setSchemaVersion(ctx context.Context, version int) error {
+	versionValue := aws.String(strconv.Itoa(version))
+	params := &dynamodb.PutItemInput{
+		Item: map[string]*dynamodb.AttributeValue{
+			"key":   {S: aws.String(_SPIT_SCHEMA_VERSION_KEY)},
+			"value": {N: versionValue},
+		},
+		TableName:           aws.String(_TABLE_NAME_SPITS_META),
+		ConditionExpression: aws.String("attribute_not_exists(#key) OR #value < :version"),
+		ExpressionAttributeNames: map[string]*string{
+			"#key":   aws.String("key"),
+			"#value": aws.String("value"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":version": {N: versionValue},
+		},
+	}
+	if _, err := p.svc.PutItemWithContext(ctx, params); err != nil && !_IsConditionalCheckFailed(err) {
+		slog.ErrorContext(ctx, "dynamodb request failed", "op", "setSchemaVersion", "err", err)
+		return err
+	}
+	return nil
+}
+
+func _DynamoBillingParams() (*string, *dynamodb.ProvisionedThroughput) {
+	if _DYNAMODB_BILLING_MODE == dynamodb.BillingModeProvisioned {
+		return aws.String(dynamodb.BillingModeProvisioned), &dynamodb.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(int64(_DYNAMODB_READ_UNITS)),
+			WriteCapacityUnits: aws.Int64(int64(_DYNAMODB_WRITE_UNITS)),
+		}
+	}
+	return aws.String(dynamodb.BillingModePayPerRequest), nil
+}
+
+// createTable() creates the table with a string hash key, and a string range key unless it is empty,
+// or updates the billing mode of the table if it already exists.
+func (p *awsDynamoDBStorager) createTable(ctx context.Context, tableName string, keyName string, rangeKeyName string) error {
+	billingMode, throughput := _DynamoBillingParams()
+
+	descResp, err := p.svc.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+	if err == nil {
+		current := dynamodb.BillingModeProvisioned
+		if summary := descResp.Table.BillingModeSummary; summary != nil && summary.BillingMode != nil {
+			current = *summary.BillingMode
+		}
+		if current == *billingMode {
+			slog.InfoContext(ctx, "table already exists", "table", tableName)
+			return nil
+		}
+		slog.InfoContext(ctx, "updating billing mode", "table", tableName, "billing_mode", *billingMode)
+		_, err = p.svc.UpdateTableWithContext(ctx, &dynamodb.UpdateTableInput{
+			TableName:             aws.String(tableName),
+			BillingMode:           billingMode,
+			ProvisionedThroughput: throughput,
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "dynamodb request failed", "op", "createTable", "err", err)
+			return err
+		}
+		return p.svc.WaitUntilTableExistsWithContext(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+	}
+	if !_IsAwsErrorCode(err, dynamodb.ErrCodeResourceNotFoundException) {
+		slog.ErrorContext(ctx, "dynamodb request failed", "op", "createTable", "err", err)
+		return err
+	}
+
+	slog.InfoContext(ctx, "creating table", "table", tableName)
+	params := &dynamodb.CreateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{AttributeName: aws.String(keyName), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{AttributeName: aws.String(keyName), KeyType: aws.String(dynamodb.KeyTypeHash)},
+		},
+		BillingMode:           billingMode,
+		ProvisionedThroughput: throughput,
+	}
+	if len(rangeKeyName) > 0 {
+		params.AttributeDefinitions = append(params.AttributeDefinitions,
+			&dynamodb.AttributeDefinition{AttributeName: aws.String(rangeKeyName), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)})
+		params.KeySchema = append(params.KeySchema,
+			&dynamodb.KeySchemaElement{AttributeName: aws.String(rangeKeyName), KeyType: aws.String(dynamodb.KeyTypeRange)})
+	}
+	if _, err := p.svc.CreateTableWithContext(ctx, params); err != nil && !_IsAwsErrorCode(err, dynamodb.ErrCodeResourceInUseException) {
+		slog.ErrorContext(ctx, "dynamodb request failed", "op", "createTable", "err", err)
+		return err
+	}
+	return p.svc.WaitUntilTableExistsWithContext(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+}
+
+// createIndex() adds the index listing the Spits by the partition key newest first.
+// The Spits without the partition key are not in the index at all.
+func (p *awsDynamoDBStorager) createIndex(ctx context.Context, indexName string, partitionKey string) error {
+	descInput := &dynamodb.DescribeTableInput{TableName: aws.String(_TABLE_NAME_SPITS_DATA)}
+	descResp, err := p.svc.DescribeTableWithContext(ctx, descInput)
+	if err != nil {
+		slog.ErrorContext(ctx, "dynamodb request failed", "op", "createIndex", "err", err)
+		return err
+	}
+	exists := false
+	for _, index := range descResp.Table.GlobalSecondaryIndexes {
+		if aws.StringValue(index.IndexName) == indexName {
+			exists = true
+		}
+	}
+
+	if !exists {
+		slog.InfoContext(ctx, "creating index", "table", _TABLE_NAME_SPITS_DATA, "index", indexName)
+		_, throughput := _DynamoBillingParams()
+		_, err = p.svc.UpdateTableWithContext(ctx, &dynamodb.UpdateTableInput{
+			TableName: aws.String(_TABLE_NAME_SPITS_DATA),
+			AttributeDefinitions: []*dynamodb.AttributeDefinition{
+				{AttributeName: aws.String(partitionKey), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+				{AttributeName: aws.String("date_created"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+			},
+			GlobalSecondaryIndexUpdates: []*dynamodb.GlobalSecondaryIndexUpdate{{
+				Create: &dynamodb.CreateGlobalSecondaryIndexAction{
+					IndexName: aws.String(indexName),
+					KeySchema: []*dynamodb.KeySchemaElement{
+						{AttributeName: aws.String(partitionKey), KeyType: aws.String(dynamodb.KeyTypeHash)},
+						{AttributeName: aws.String("date_created"), KeyType: aws.String(dynamodb.KeyTypeRange)},
+					},
+					Projection:            &dynamodb.Projection{ProjectionType: aws.String(dynamodb.ProjectionTypeAll)},
+					ProvisionedThroughput: throughput,
+				},
+			}},
+		})
+		if err != nil && !_IsAwsErrorCode(err, dynamodb.ErrCodeResourceInUseException) {
+			slog.ErrorContext(ctx, "dynamodb request failed", "op", "createIndex", "err", err)
+			return err
+		}
+	}
+
+	// the index is only usable once it is backfilled
+	for {
+		descResp, err = p.svc.DescribeTableWithContext(ctx, descInput)
+		if err != nil {
+			return err
+		}
+		for _, index := range descResp.Table.GlobalSecondaryIndexes {
+			if aws.StringValue(index.IndexName) == indexName &&
+				aws.StringValue(index.IndexStatus) == dynamodb.IndexStatusActive {
+				return nil
+			}
+		}
+		slog.InfoContext(ctx, "waiting for the index to become active", "index", indexName)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Second):
+		}
+	}
+}
+
+// MigrateLegacyKeys() rewrites the legacy Spit items with the prefixed keys into the current layout.
+// It is safe to run while serving since the reads fall back to the legacy items until they are
+// deleted, and safe to run again after an interruption since existing new items are kept.
+// Clicks counted on a legacy item while it is being copied are lost.
+func (p *awsDynamoDBStorager) MigrateLegacyKeys(ctx context.Context) (int, error) {
+	migrated := 0
+	var startKey map[string]*dynamodb.AttributeValue
+	for {
+		resp, err := p.svc.ScanWithContext(ctx, &dynamodb.ScanInput{
+			TableName:                aws.String(_TABLE_NAME_SPITS_DATA),
+			Limit:                    aws.Int64(100),
+			ExclusiveStartKey:        startKey,
+			FilterExpression:         aws.String("begins_with(#id, :prefix)"),
+			ExpressionAttributeNames: map[string]*string{"#id": aws.String("id")},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":prefix": {S: aws.String(_SPIT_KEY_PREFIX)},
+			},
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "dynamodb request failed", "op", "MigrateLegacyKeys", "err", err)
+			return migrated, err
+		}
+
+		for _, legacyItem := range resp.Items {
+			s, err := _BuildSpitFromDynamo(legacyItem, nil)
+			if err != nil {
+				return migrated, err
+			}
+			legacyKey := s.Id
+			s.Id = strings.TrimPrefix(legacyKey, _SPIT_KEY_PREFIX)
+
+			item, err := _BuildSpitItem(s)
+			if err != nil {
+				return migrated, err
+			}
+			params := &dynamodb.PutItemInput{
+				Item:                     item,
+				TableName:                aws.String(_TABLE_NAME_SPITS_DATA),
+				ConditionExpression:      aws.String("attribute_not_exists(#id)"),
+				ExpressionAttributeNames: map[string]*string{"#id": aws.String("id")},
+			}
+			if _, err := p.svc.PutItemWithContext(ctx, params); err != nil && !_IsConditionalCheckFailed(err) {
+				slog.ErrorContext(ctx, "dynamodb request failed", "op", "MigrateLegacyKeys", "err", err)
+				return migrated, err
+			}
+			if err := p.deleteItem(ctx, legacyKey); err != nil {
+				return migrated, err
+			}
+			migrated++
+		}
+
+		if len(resp.LastEvaluatedKey) == 0 {
+			return migrated, nil
+		}
+		startKey = resp.LastEvaluatedKey
+	}
+}
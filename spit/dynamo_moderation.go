@@ -0,0 +1,168 @@
+package spit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+const (
+	// the audit log is kept in the meta table too, every day has a counter at the prefix
+	// and its entries are numbered after it, e.g. spit::audit::2024-05-01::0000000042
+	_AUDIT_KEY_PREFIX string = "spit::audit::"
+	// the most keys a BatchGetItem request reads
+	_BATCH_GET_MAX_KEYS int = 100
+)
+
+func _AuditKey(day string, n int) string {
+	return fmt.Sprintf("%v%v::%010d", _AUDIT_KEY_PREFIX, day, n)
+}
+
+func (p *awsDynamoDBStorager) SetDisabled(ctx context.Context, id string, reason string) error {
+	ctx, cancel := _OpContext(ctx, "SetDisabled")
+	defer cancel()
+	params := &dynamodb.UpdateItemInput{
+		Key:                 _BuildSpitKeyAttribute(id),
+		ConditionExpression: aws.String("attribute_exists(#id)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#id":       aws.String("id"),
+			"#disabled": aws.String("disabled"),
+		},
+		TableName: aws.String(_TABLE_NAME_SPITS_DATA),
+	}
+	if len(reason) == 0 {
+		params.UpdateExpression = aws.String("REMOVE #disabled SET #reviewed = :reviewed")
+		params.ExpressionAttributeNames["#reviewed"] = aws.String("reviewed")
+		params.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{":reviewed": {BOOL: aws.Bool(true)}}
+	} else {
+		params.UpdateExpression = aws.String("SET #disabled = :reason")
+		params.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{":reason": {S: aws.String(reason)}}
+	}
+	if _, err := p.svc.UpdateItemWithContext(ctx, params); err != nil {
+		if _IsConditionalCheckFailed(err) {
+			return DynamoDbItemNotFoundError{fmt.Sprintf("%v:%v:%v", _TABLE_NAME_SPITS_DATA, "id", id)}
+		}
+		slog.ErrorContext(ctx, "dynamodb request failed", "op", "SetDisabled", "err", err)
+		return _WrapAwsError(err)
+	}
+	return nil
+}
+
+// ScanURLs() scans the Spits table from the cursor a page at a time, every page with its own
+// deadline, until fn was called with limit live URL Spits or it reaches the end of the table.
+// It returns the cursor after the last Spit fn accepted, even on errors, empty at the end of the table.
+func (p *awsDynamoDBStorager) ScanURLs(ctx context.Context, cursor string, limit int, fn func(s *Spit) error) (string, error) {
+	params := &dynamodb.ScanInput{
+		TableName:                 aws.String(_TABLE_NAME_SPITS_DATA),
+		ExclusiveStartKey:         _ScanStartKey(cursor),
+		FilterExpression:          aws.String("#type = :type"),
+		ExpressionAttributeNames:  map[string]*string{"#type": aws.String("spit_type")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":type": {S: aws.String(SPIT_TYPE_URL)}},
+	}
+	visited := 0
+	for {
+		pageCtx, cancel := _OpContext(ctx, "ScanURLs")
+		resp, err := p.svc.ScanWithContext(pageCtx, params)
+		cancel()
+		if err != nil {
+			slog.ErrorContext(ctx, "dynamodb request failed", "op", "ScanURLs", "err", err)
+			return cursor, _WrapAwsError(err)
+		}
+		now := time.Now().UTC()
+		for _, item := range resp.Items {
+			s, err := _BuildSpitFromDynamo(item, nil)
+			if err != nil {
+				return cursor, err
+			}
+			if !_IsExpiredAt(s, now) {
+				if err := fn(s); err != nil {
+					return cursor, err
+				}
+				visited++
+			}
+			cursor = _ScanCursor(item)
+			if visited >= limit {
+				return cursor, nil
+			}
+		}
+		cursor = _ScanCursor(resp.LastEvaluatedKey)
+		if len(cursor) == 0 {
+			return "", nil
+		}
+		params.ExclusiveStartKey = resp.LastEvaluatedKey
+	}
+}
+
+func (p *awsDynamoDBStorager) PutAudit(ctx context.Context, e *AuditEntry) error {
+	ctx, cancel := _OpContext(ctx, "PutAudit")
+	defer cancel()
+	item, err := dynamodbattribute.MarshalMap(e)
+	if err != nil {
+		return err
+	}
+	n, err := p.FAI(ctx, _TABLE_NAME_SPITS_META, "key", _AUDIT_KEY_PREFIX+e.Day, "value", 1)
+	if err != nil {
+		return err
+	}
+	item["key"] = &dynamodb.AttributeValue{S: aws.String(_AuditKey(e.Day, n))}
+
+	params := &dynamodb.PutItemInput{
+		Item:      item,
+		TableName: aws.String(_TABLE_NAME_SPITS_META),
+	}
+	if _, err := p.svc.PutItemWithContext(ctx, params); err != nil {
+		slog.ErrorContext(ctx, "dynamodb request failed", "op", "PutAudit", "err", err)
+		return _WrapAwsError(err)
+	}
+	return nil
+}
+
+// ListAudit() reads the last limit entries of the day by their numbers, a number whose entry
+// failed to be stored is skipped.
+func (p *awsDynamoDBStorager) ListAudit(ctx context.Context, day string, limit int) ([]*AuditEntry, error) {
+	ctx, cancel := _OpContext(ctx, "ListAudit")
+	defer cancel()
+	counter := &_SpitSchemaVersionModel{}
+	if err := p.GetRaw(ctx, _TABLE_NAME_SPITS_META, "key", _AUDIT_KEY_PREFIX+day, counter); err != nil {
+		if _, ok := err.(DynamoDbItemNotFoundError); ok {
+			return []*AuditEntry{}, nil
+		}
+		return nil, err
+	}
+
+	keys := make([]map[string]*dynamodb.AttributeValue, 0, limit)
+	for n := counter.Value; n > 0 && len(keys) < limit; n-- {
+		keys = append(keys, map[string]*dynamodb.AttributeValue{"key": {S: aws.String(_AuditKey(day, n))}})
+	}
+	items := make([]map[string]*dynamodb.AttributeValue, 0, len(keys))
+	for len(keys) > 0 {
+		batch := keys[:min(len(keys), _BATCH_GET_MAX_KEYS)]
+		keys = keys[len(batch):]
+		resp, err := p.svc.BatchGetItemWithContext(ctx, &dynamodb.BatchGetItemInput{
+			RequestItems: map[string]*dynamodb.KeysAndAttributes{_TABLE_NAME_SPITS_META: {Keys: batch}},
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "dynamodb request failed", "op", "ListAudit", "err", err)
+			return nil, _WrapAwsError(err)
+		}
+		items = append(items, resp.Responses[_TABLE_NAME_SPITS_META]...)
+		// the keys DynamoDB did not get to are read again with the next batch
+		if unprocessed, ok := resp.UnprocessedKeys[_TABLE_NAME_SPITS_META]; ok {
+			keys = append(keys, unprocessed.Keys...)
+		}
+	}
+	// the batches come back in any order, the zero padded keys sort by number
+	sort.Slice(items, func(i, j int) bool { return aws.StringValue(items[i]["key"].S) > aws.StringValue(items[j]["key"].S) })
+
+	entries := make([]*AuditEntry, 0, len(items))
+	if err := dynamodbattribute.UnmarshalListOfMaps(items, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
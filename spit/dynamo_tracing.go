@@ -0,0 +1,27 @@
+package spit
+
+import (
+	"github.com/lambrospetrou/spito/tracing"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// _TraceDynamoRequests() adds a span for every DynamoDB request, e.g. Query or UpdateItem,
+// covering all its retries.
+func _TraceDynamoRequests(svc *dynamodb.DynamoDB) {
+	svc.Handlers.Build.PushFront(func(r *request.Request) {
+		ctx, _ := tracing.Start(r.Context(), "dynamodb."+r.Operation.Name,
+			attribute.String("db.system", "dynamodb"),
+			attribute.String("db.operation", r.Operation.Name),
+		)
+		r.SetContext(ctx)
+	})
+	svc.Handlers.Complete.PushBack(func(r *request.Request) {
+		span := trace.SpanFromContext(r.Context())
+		span.SetAttributes(attribute.Int("spito.dynamodb.retries", r.RetryCount))
+		tracing.End(span, r.Error)
+	})
+}
@@ -0,0 +1,38 @@
+package spit
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+var _expressionTokens = regexp.MustCompile(`[#:][A-Za-z]+`)
+
+func TestBuildUpdateInputNamesOnlyWhatItUses(t *testing.T) {
+	expiration := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	for _, s := range []*Spit{
+		{Id: "a", Content: "c", Exp: 3600, DateExpiration: expiration},
+		{Id: "a", Content: "c", Exp: 3600, DateExpiration: expiration, Reviewed: true},
+		{Id: "a", Content: "c", Exp: SPIT_EXP_NEVER},
+		{Id: "a", Content: "c", Exp: SPIT_EXP_NEVER, Reviewed: true},
+	} {
+		in := _BuildUpdateInput(s)
+		used := make(map[string]bool)
+		for _, token := range _expressionTokens.FindAllString(aws.StringValue(in.UpdateExpression)+" "+aws.StringValue(in.ConditionExpression), -1) {
+			used[token] = true
+		}
+		defined := make(map[string]bool)
+		for name := range in.ExpressionAttributeNames {
+			defined[name] = true
+		}
+		for value := range in.ExpressionAttributeValues {
+			defined[value] = true
+		}
+		if fmt.Sprint(used) != fmt.Sprint(defined) {
+			t.Errorf("exp %v reviewed %v: the expressions use %v but the input defines %v", s.Exp, s.Reviewed, used, defined)
+		}
+	}
+}
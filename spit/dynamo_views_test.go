@@ -0,0 +1,264 @@
+package spit
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/private/protocol/json/jsonutil"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// _FakeDynamo serves the DynamoDB requests of reading and consuming the views of the Spits
+// and of the audit log, holding the items of all the tables by their key.
+type _FakeDynamo struct {
+	sync.Mutex
+	items   map[string]map[string]*dynamodb.AttributeValue
+	deletes int
+}
+
+func (f *_FakeDynamo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	var out interface{}
+	switch op := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810."); op {
+	case "Query":
+		in := &dynamodb.QueryInput{}
+		if err := jsonutil.UnmarshalJSON(in, r.Body); err != nil {
+			_FakeDynamoError(w, "ValidationException", err.Error())
+			return
+		}
+		items := make([]map[string]*dynamodb.AttributeValue, 0)
+		if item, ok := f.items[aws.StringValue(in.ExpressionAttributeValues[":idVal"].S)]; ok {
+			items = append(items, item)
+		}
+		out = &dynamodb.QueryOutput{Items: items, Count: aws.Int64(int64(len(items)))}
+	case "UpdateItem":
+		in := &dynamodb.UpdateItemInput{}
+		if err := jsonutil.UnmarshalJSON(in, r.Body); err != nil {
+			_FakeDynamoError(w, "ValidationException", err.Error())
+			return
+		}
+		if counter, ok := in.AttributeUpdates["value"]; ok {
+			// the counters of FAI()
+			key := aws.StringValue(in.Key["key"].S)
+			if _, ok := f.items[key]; !ok {
+				f.items[key] = map[string]*dynamodb.AttributeValue{"key": in.Key["key"]}
+			}
+			value := _FakeDynamoNumber(f.items[key]["value"]) + _FakeDynamoNumber(counter.Value)
+			f.items[key]["value"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(value))}
+			out = &dynamodb.UpdateItemOutput{Attributes: f.items[key]}
+			break
+		}
+		if aws.StringValue(in.ConditionExpression) != "views_left > :zero" {
+			_FakeDynamoError(w, "ValidationException", "only the views and the counters are updated by the fake")
+			return
+		}
+		item, ok := f.items[aws.StringValue(in.Key["id"].S)]
+		if !ok || _FakeDynamoNumber(item["views_left"]) <= 0 {
+			_FakeDynamoError(w, "ConditionalCheckFailedException", "The conditional request failed")
+			return
+		}
+		item["views_left"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(_FakeDynamoNumber(item["views_left"]) - 1))}
+		item["metric_clicks"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(_FakeDynamoNumber(item["metric_clicks"]) + 1))}
+		out = &dynamodb.UpdateItemOutput{Attributes: item}
+	case "PutItem":
+		in := &dynamodb.PutItemInput{}
+		if err := jsonutil.UnmarshalJSON(in, r.Body); err != nil {
+			_FakeDynamoError(w, "ValidationException", err.Error())
+			return
+		}
+		f.items[aws.StringValue(in.Item["key"].S)] = in.Item
+		out = &dynamodb.PutItemOutput{}
+	case "BatchGetItem":
+		in := &dynamodb.BatchGetItemInput{}
+		if err := jsonutil.UnmarshalJSON(in, r.Body); err != nil {
+			_FakeDynamoError(w, "ValidationException", err.Error())
+			return
+		}
+		responses := make(map[string][]map[string]*dynamodb.AttributeValue)
+		for table, keys := range in.RequestItems {
+			// the items are returned in any order
+			for i := len(keys.Keys) - 1; i >= 0; i-- {
+				if item, ok := f.items[aws.StringValue(keys.Keys[i]["key"].S)]; ok {
+					responses[table] = append(responses[table], item)
+				}
+			}
+		}
+		out = &dynamodb.BatchGetItemOutput{Responses: responses}
+	case "DeleteItem":
+		in := &dynamodb.DeleteItemInput{}
+		if err := jsonutil.UnmarshalJSON(in, r.Body); err != nil {
+			_FakeDynamoError(w, "ValidationException", err.Error())
+			return
+		}
+		delete(f.items, aws.StringValue(in.Key["id"].S))
+		f.deletes++
+		out = &dynamodb.DeleteItemOutput{}
+	default:
+		_FakeDynamoError(w, "UnknownOperationException", op)
+		return
+	}
+	body, err := jsonutil.BuildJSON(out)
+	if err != nil {
+		_FakeDynamoError(w, "InternalServerError", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	w.Write(body)
+}
+
+func _FakeDynamoNumber(av *dynamodb.AttributeValue) int {
+	if av == nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(aws.StringValue(av.N))
+	return n
+}
+
+func _FakeDynamoError(w http.ResponseWriter, code string, message string) {
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	w.WriteHeader(http.StatusBadRequest)
+	fmt.Fprintf(w, `{"__type":"com.amazonaws.dynamodb.v20120810#%s","message":%q}`, code, message)
+}
+
+func (f *_FakeDynamo) put(t *testing.T, s *Spit) {
+	item, err := dynamodbattribute.MarshalMap(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Lock()
+	defer f.Unlock()
+	f.items[s.Id] = item
+}
+
+func (f *_FakeDynamo) viewsLeft(id string) (int, bool) {
+	f.Lock()
+	defer f.Unlock()
+	item, ok := f.items[id]
+	if !ok {
+		return 0, false
+	}
+	return _FakeDynamoNumber(item["views_left"]), true
+}
+
+// withFakeDynamo() serves the storage of the package with the Dynamo adapter
+// talking to a fake DynamoDB for the test.
+func withFakeDynamo(t *testing.T) *_FakeDynamo {
+	f := &_FakeDynamo{items: make(map[string]map[string]*dynamodb.AttributeValue)}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("SPITO_DYNAMODB_ENDPOINT", server.URL)
+
+	previous := storager
+	storager = _NewDynamoClient()
+	t.Cleanup(func() { storager = previous })
+	return f
+}
+
+func _NewMaxViewsSpit(id string, views int) *Spit {
+	return &Spit{Id: id, SpitType: SPIT_TYPE_TEXT, Content: "secret", Exp: SPIT_EXP_NEVER, MaxViews: views, ViewsLeft: views}
+}
+
+func TestMaxViewsLastViewDeletes(t *testing.T) {
+	f := withFakeDynamo(t)
+	f.put(t, _NewMaxViewsSpit("views", 2))
+
+	s, err := Load(t.Context(), "views")
+	if err != nil || s.ViewsLeft != 1 || s.MetricClicks != 1 {
+		t.Fatalf("the first view should leave one, got %+v %v", s, err)
+	}
+	if _, ok := f.viewsLeft("views"); !ok || f.deletes != 0 {
+		t.Fatal("the spit should be kept while it has views left")
+	}
+	if s, err = Load(t.Context(), "views"); err != nil || s.ViewsLeft != 0 || s.Content != "secret" {
+		t.Fatalf("the last view should still return the content, got %+v %v", s, err)
+	}
+	if _, ok := f.viewsLeft("views"); ok {
+		t.Error("the last view should delete the spit")
+	}
+	if _, err := Load(t.Context(), "views"); !_IsNotFound(err) {
+		t.Errorf("expected not found after the last view, got %v", err)
+	}
+}
+
+func TestMaxViewsNoViewsLeft(t *testing.T) {
+	f := withFakeDynamo(t)
+	// a reader loaded the spit right before another one took its last view and before it was deleted
+	s := _NewMaxViewsSpit("gone", 1)
+	s.ViewsLeft = 0
+	f.put(t, s)
+
+	if _, err := Load(t.Context(), "gone"); !_IsNotFound(err) {
+		t.Errorf("expected not found once the views ran out, got %v", err)
+	}
+	if views, _ := f.viewsLeft("gone"); views != 0 {
+		t.Errorf("the views should not go below 0, got %v", views)
+	}
+}
+
+func TestMaxViewsConcurrentReads(t *testing.T) {
+	f := withFakeDynamo(t)
+	f.put(t, _NewMaxViewsSpit("once", 1))
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	loaded, notFound := 0, 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := Load(t.Context(), "once")
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				loaded++
+			} else if _IsNotFound(err) {
+				notFound++
+			} else {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if loaded != 1 || notFound != 7 {
+		t.Errorf("only one reader should get the single view, loaded %v, not found %v", loaded, notFound)
+	}
+}
+
+func TestMaxViewsWrongPassword(t *testing.T) {
+	f := withFakeDynamo(t)
+	s := _NewMaxViewsSpit("protected-views", 1)
+	hash, err := _HashPassword("right")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.PasswordHash = hash
+	f.put(t, s)
+
+	if _, err := LoadWithPassword(t.Context(), "protected-views", "wrong"); err != ErrPasswordWrong {
+		t.Fatalf("expected the wrong password error, got %v", err)
+	}
+	if views, ok := f.viewsLeft("protected-views"); !ok || views != 1 {
+		t.Fatalf("a wrong password should not use up a view, views left %v", views)
+	}
+	if s, err := LoadWithPassword(t.Context(), "protected-views", "right"); err != nil || s.Content != "secret" {
+		t.Fatalf("the right password should return the content, got %v", err)
+	}
+	if _, ok := f.viewsLeft("protected-views"); ok {
+		t.Error("the right password should use up the last view")
+	}
+}
+
+func _IsNotFound(err error) bool {
+	_, ok := err.(DynamoDbItemNotFoundError)
+	return ok
+}
@@ -0,0 +1,163 @@
+package spit
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+const (
+	// the workspaces and the slugs of their links are kept in the meta table too
+	_WORKSPACE_KEY_PREFIX string = "spit::workspace::"
+	_SLUG_KEY_PREFIX      string = "spit::slug::"
+)
+
+type _SlugModel struct {
+	Key    string `json:"key"`
+	SpitId string `json:"spit_id"`
+}
+
+func (p *awsDynamoDBStorager) PutWorkspace(ctx context.Context, ws *Workspace) error {
+	ctx, cancel := _OpContext(ctx, "PutWorkspace")
+	defer cancel()
+	item, err := dynamodbattribute.MarshalMap(ws)
+	if err != nil {
+		return err
+	}
+	item["key"] = &dynamodb.AttributeValue{S: aws.String(_WORKSPACE_KEY_PREFIX + ws.Id)}
+
+	params := &dynamodb.PutItemInput{
+		Item:                     item,
+		TableName:                aws.String(_TABLE_NAME_SPITS_META),
+		ConditionExpression:      aws.String("attribute_not_exists(#key)"),
+		ExpressionAttributeNames: map[string]*string{"#key": aws.String("key")},
+	}
+	if _, err := p.svc.PutItemWithContext(ctx, params); err != nil {
+		if _IsConditionalCheckFailed(err) {
+			return ErrWorkspaceIdTaken
+		}
+		slog.ErrorContext(ctx, "dynamodb request failed", "op", "PutWorkspace", "err", err)
+		return _WrapAwsError(err)
+	}
+	return nil
+}
+
+func (p *awsDynamoDBStorager) GetWorkspace(ctx context.Context, id string) (*Workspace, error) {
+	ctx, cancel := _OpContext(ctx, "GetWorkspace")
+	defer cancel()
+	ws := &Workspace{}
+	if err := p.GetRaw(ctx, _TABLE_NAME_SPITS_META, "key", _WORKSPACE_KEY_PREFIX+id, ws); err != nil {
+		return nil, err
+	}
+	if ws.Members == nil {
+		ws.Members = make(map[string]string)
+	}
+	return ws, nil
+}
+
+// SetWorkspaceMember() only touches the role of the member and only if the version
+// is the one the caller checked the members against, e.g. that another admin is left,
+// so that two admins cannot demote each other at the same time.
+func (p *awsDynamoDBStorager) SetWorkspaceMember(ctx context.Context, workspaceId string, version int, memberId string, role string) error {
+	ctx, cancel := _OpContext(ctx, "SetWorkspaceMember")
+	defer cancel()
+	key := _WORKSPACE_KEY_PREFIX + workspaceId
+	// the workspaces created before the versions have none
+	condition := "attribute_exists(#key) AND #version = :version"
+	if version == 0 {
+		condition = "attribute_exists(#key) AND (attribute_not_exists(#version) OR #version = :version)"
+	}
+	params := &dynamodb.UpdateItemInput{
+		Key:                 map[string]*dynamodb.AttributeValue{"key": {S: aws.String(key)}},
+		ConditionExpression: aws.String(condition),
+		ExpressionAttributeNames: map[string]*string{
+			"#key":     aws.String("key"),
+			"#members": aws.String("members"),
+			"#member":  aws.String(memberId),
+			"#version": aws.String("version"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":version": {N: aws.String(strconv.Itoa(version))},
+			":next":    {N: aws.String(strconv.Itoa(version + 1))},
+		},
+		TableName: aws.String(_TABLE_NAME_SPITS_META),
+	}
+	if len(role) == 0 {
+		params.UpdateExpression = aws.String("REMOVE #members.#member SET #version = :next")
+	} else {
+		params.UpdateExpression = aws.String("SET #members.#member = :role, #version = :next")
+		params.ExpressionAttributeValues[":role"] = &dynamodb.AttributeValue{S: aws.String(role)}
+	}
+	if _, err := p.svc.UpdateItemWithContext(ctx, params); err != nil {
+		// the workspace was loaded just before, so it is most likely a concurrent change
+		if _IsConditionalCheckFailed(err) {
+			return ErrWorkspaceChanged
+		}
+		slog.ErrorContext(ctx, "dynamodb request failed", "op", "SetWorkspaceMember", "err", err)
+		return _WrapAwsError(err)
+	}
+	return nil
+}
+
+func (p *awsDynamoDBStorager) PutSlug(ctx context.Context, slug string, spitId string, replaces string) error {
+	ctx, cancel := _OpContext(ctx, "PutSlug")
+	defer cancel()
+	item, err := dynamodbattribute.MarshalMap(&_SlugModel{Key: _SLUG_KEY_PREFIX + slug, SpitId: spitId})
+	if err != nil {
+		return err
+	}
+	params := &dynamodb.PutItemInput{
+		Item:                     item,
+		TableName:                aws.String(_TABLE_NAME_SPITS_META),
+		ConditionExpression:      aws.String("attribute_not_exists(#key)"),
+		ExpressionAttributeNames: map[string]*string{"#key": aws.String("key")},
+	}
+	if len(replaces) > 0 {
+		params.ConditionExpression = aws.String("#spitId = :replaces")
+		params.ExpressionAttributeNames = map[string]*string{"#spitId": aws.String("spit_id")}
+		params.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{":replaces": {S: aws.String(replaces)}}
+	}
+	if _, err := p.svc.PutItemWithContext(ctx, params); err != nil {
+		if _IsConditionalCheckFailed(err) {
+			return ErrSlugTaken
+		}
+		slog.ErrorContext(ctx, "dynamodb request failed", "op", "PutSlug", "err", err)
+		return _WrapAwsError(err)
+	}
+	return nil
+}
+
+func (p *awsDynamoDBStorager) GetSlug(ctx context.Context, slug string) (string, error) {
+	ctx, cancel := _OpContext(ctx, "GetSlug")
+	defer cancel()
+	m := &_SlugModel{}
+	if err := p.GetRaw(ctx, _TABLE_NAME_SPITS_META, "key", _SLUG_KEY_PREFIX+slug, m); err != nil {
+		return "", err
+	}
+	return m.SpitId, nil
+}
+
+func (p *awsDynamoDBStorager) DeleteSlug(ctx context.Context, slug string, spitId string) error {
+	ctx, cancel := _OpContext(ctx, "DeleteSlug")
+	defer cancel()
+	params := &dynamodb.DeleteItemInput{
+		Key:                       map[string]*dynamodb.AttributeValue{"key": {S: aws.String(_SLUG_KEY_PREFIX + slug)}},
+		ConditionExpression:       aws.String("#spitId = :spitId"),
+		ExpressionAttributeNames:  map[string]*string{"#spitId": aws.String("spit_id")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":spitId": {S: aws.String(spitId)}},
+		TableName:                 aws.String(_TABLE_NAME_SPITS_META),
+	}
+	if _, err := p.svc.DeleteItemWithContext(ctx, params); err != nil {
+		// the slug was already claimed by another Spit
+		if _IsConditionalCheckFailed(err) {
+			return nil
+		}
+		slog.ErrorContext(ctx, "dynamodb request failed", "op", "DeleteSlug", "err", err)
+		return _WrapAwsError(err)
+	}
+	return nil
+}
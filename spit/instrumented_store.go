@@ -0,0 +1,229 @@
+package spit
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/lambrospetrou/spito/metrics"
+	"github.com/lambrospetrou/spito/tracing"
+)
+
+// _InstrumentedStorager records the latency and the errors of every operation
+// of the wrapped Storager and traces it.
+type _InstrumentedStorager struct {
+	storager Storager
+}
+
+// NewInstrumentedStorager wraps the storage with the storage metrics.
+func NewInstrumentedStorager(s Storager) Storager {
+	return &_InstrumentedStorager{storager: s}
+}
+
+func _StorageErrorKind(err error) string {
+	var notFound DynamoDbItemNotFoundError
+	switch {
+	case errors.As(err, &notFound):
+		return "not_found"
+	case errors.Is(err, ErrSpitIdTaken), errors.Is(err, ErrSlugTaken), errors.Is(err, ErrWorkspaceIdTaken):
+		return "id_taken"
+	case _IsTransient(err):
+		return "transient"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return "timeout"
+	}
+	return "other"
+}
+
+// _StartStorageOp() starts the span of the operation,
+// the returned function ends it and records the metrics.
+func _StartStorageOp(ctx context.Context, op string) (context.Context, func(err error)) {
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, "storage."+op)
+	return ctx, func(err error) {
+		_ObserveStorage(op, start, err)
+		tracing.End(span, err)
+	}
+}
+
+func _ObserveStorage(op string, start time.Time, err error) {
+	metrics.StorageDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.StorageErrors.WithLabelValues(op, _StorageErrorKind(err)).Inc()
+	}
+}
+
+func (p *_InstrumentedStorager) Put(ctx context.Context, s *Spit) error {
+	ctx, done := _StartStorageOp(ctx, "Put")
+	err := p.storager.Put(ctx, s)
+	done(err)
+	return err
+}
+
+func (p *_InstrumentedStorager) Get(ctx context.Context, id string) (*Spit, error) {
+	ctx, done := _StartStorageOp(ctx, "Get")
+	s, err := p.storager.Get(ctx, id)
+	done(err)
+	return s, err
+}
+
+func (p *_InstrumentedStorager) GetWithAnalytics(ctx context.Context, id string) (*Spit, error) {
+	ctx, done := _StartStorageOp(ctx, "GetWithAnalytics")
+	s, err := p.storager.GetWithAnalytics(ctx, id)
+	done(err)
+	return s, err
+}
+
+func (p *_InstrumentedStorager) Update(ctx context.Context, s *Spit) error {
+	ctx, done := _StartStorageOp(ctx, "Update")
+	err := p.storager.Update(ctx, s)
+	done(err)
+	return err
+}
+
+func (p *_InstrumentedStorager) Delete(ctx context.Context, id string) error {
+	ctx, done := _StartStorageOp(ctx, "Delete")
+	err := p.storager.Delete(ctx, id)
+	done(err)
+	return err
+}
+
+func (p *_InstrumentedStorager) ListByOwner(ctx context.Context, ownerId string, spitType string, after *ListPosition, limit int) ([]*Spit, bool, error) {
+	ctx, done := _StartStorageOp(ctx, "ListByOwner")
+	spits, more, err := p.storager.ListByOwner(ctx, ownerId, spitType, after, limit)
+	done(err)
+	return spits, more, err
+}
+
+func (p *_InstrumentedStorager) ListByWorkspace(ctx context.Context, workspaceId string, spitType string, after *ListPosition, limit int) ([]*Spit, bool, error) {
+	ctx, done := _StartStorageOp(ctx, "ListByWorkspace")
+	spits, more, err := p.storager.ListByWorkspace(ctx, workspaceId, spitType, after, limit)
+	done(err)
+	return spits, more, err
+}
+
+func (p *_InstrumentedStorager) PutWorkspace(ctx context.Context, ws *Workspace) error {
+	ctx, done := _StartStorageOp(ctx, "PutWorkspace")
+	err := p.storager.PutWorkspace(ctx, ws)
+	done(err)
+	return err
+}
+
+func (p *_InstrumentedStorager) GetWorkspace(ctx context.Context, id string) (*Workspace, error) {
+	ctx, done := _StartStorageOp(ctx, "GetWorkspace")
+	ws, err := p.storager.GetWorkspace(ctx, id)
+	done(err)
+	return ws, err
+}
+
+func (p *_InstrumentedStorager) SetWorkspaceMember(ctx context.Context, workspaceId string, version int, memberId string, role string) error {
+	ctx, done := _StartStorageOp(ctx, "SetWorkspaceMember")
+	err := p.storager.SetWorkspaceMember(ctx, workspaceId, version, memberId, role)
+	done(err)
+	return err
+}
+
+func (p *_InstrumentedStorager) PutSlug(ctx context.Context, slug string, spitId string, replaces string) error {
+	ctx, done := _StartStorageOp(ctx, "PutSlug")
+	err := p.storager.PutSlug(ctx, slug, spitId, replaces)
+	done(err)
+	return err
+}
+
+func (p *_InstrumentedStorager) GetSlug(ctx context.Context, slug string) (string, error) {
+	ctx, done := _StartStorageOp(ctx, "GetSlug")
+	id, err := p.storager.GetSlug(ctx, slug)
+	done(err)
+	return id, err
+}
+
+func (p *_InstrumentedStorager) DeleteSlug(ctx context.Context, slug string, spitId string) error {
+	ctx, done := _StartStorageOp(ctx, "DeleteSlug")
+	err := p.storager.DeleteSlug(ctx, slug, spitId)
+	done(err)
+	return err
+}
+
+func (p *_InstrumentedStorager) SetDisabled(ctx context.Context, id string, reason string) error {
+	ctx, done := _StartStorageOp(ctx, "SetDisabled")
+	err := p.storager.SetDisabled(ctx, id, reason)
+	done(err)
+	return err
+}
+
+func (p *_InstrumentedStorager) ScanURLs(ctx context.Context, cursor string, limit int, fn func(s *Spit) error) (string, error) {
+	ctx, done := _StartStorageOp(ctx, "ScanURLs")
+	next, err := p.storager.ScanURLs(ctx, cursor, limit, fn)
+	done(err)
+	return next, err
+}
+
+func (p *_InstrumentedStorager) PutAudit(ctx context.Context, e *AuditEntry) error {
+	ctx, done := _StartStorageOp(ctx, "PutAudit")
+	err := p.storager.PutAudit(ctx, e)
+	done(err)
+	return err
+}
+
+func (p *_InstrumentedStorager) ListAudit(ctx context.Context, day string, limit int) ([]*AuditEntry, error) {
+	ctx, done := _StartStorageOp(ctx, "ListAudit")
+	entries, err := p.storager.ListAudit(ctx, day, limit)
+	done(err)
+	return entries, err
+}
+
+func (p *_InstrumentedStorager) PutAPIKey(ctx context.Context, k *APIKey) error {
+	ctx, done := _StartStorageOp(ctx, "PutAPIKey")
+	err := p.storager.PutAPIKey(ctx, k)
+	done(err)
+	return err
+}
+
+func (p *_InstrumentedStorager) GetAPIKey(ctx context.Context, id string) (*APIKey, error) {
+	ctx, done := _StartStorageOp(ctx, "GetAPIKey")
+	k, err := p.storager.GetAPIKey(ctx, id)
+	done(err)
+	return k, err
+}
+
+func (p *_InstrumentedStorager) RevokeAPIKey(ctx context.Context, id string) error {
+	ctx, done := _StartStorageOp(ctx, "RevokeAPIKey")
+	err := p.storager.RevokeAPIKey(ctx, id)
+	done(err)
+	return err
+}
+
+func (p *_InstrumentedStorager) NextId(ctx context.Context) (string, error) {
+	ctx, done := _StartStorageOp(ctx, "NextId")
+	id, err := p.storager.NextId(ctx)
+	done(err)
+	return id, err
+}
+
+func (p *_InstrumentedStorager) DeleteExpired(ctx context.Context, now time.Time, cursor string, limit int) (int, string, error) {
+	ctx, done := _StartStorageOp(ctx, "DeleteExpired")
+	n, next, err := p.storager.DeleteExpired(ctx, now, cursor, limit)
+	done(err)
+	return n, next, err
+}
+
+func (p *_InstrumentedStorager) Ping(ctx context.Context) error {
+	ctx, done := _StartStorageOp(ctx, "Ping")
+	err := p.storager.Ping(ctx)
+	done(err)
+	return err
+}
+
+func (p *_InstrumentedStorager) AcquireLease(ctx context.Context, name string, owner string, ttl time.Duration) (*Lease, error) {
+	ctx, done := _StartStorageOp(ctx, "AcquireLease")
+	lease, err := p.storager.AcquireLease(ctx, name, owner, ttl)
+	done(err)
+	return lease, err
+}
+
+func (p *_InstrumentedStorager) SaveLeaseCursor(ctx context.Context, lease *Lease, cursor string) error {
+	ctx, done := _StartStorageOp(ctx, "SaveLeaseCursor")
+	err := p.storager.SaveLeaseCursor(ctx, lease, cursor)
+	done(err)
+	return err
+}
@@ -0,0 +1,81 @@
+package spit
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const (
+	SPIT_LIST_DEFAULT_LIMIT int = 20
+	SPIT_LIST_MAX_LIMIT     int = 100
+)
+
+var ErrInvalidCursor = errors.New("Invalid cursor")
+
+// ListPosition is the last Spit of a page, the next page starts after it.
+type ListPosition struct {
+	Id          string `json:"i"`
+	DateCreated string `json:"d"`
+}
+
+// the cursors are opaque to the clients, they only hold the position within
+// the Spits of the same owner or workspace so they cannot reach the Spits of others
+func _EncodeCursor(s *Spit) string {
+	b, _ := json.Marshal(&ListPosition{Id: s.Id, DateCreated: s.DateCreated})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func _DecodeCursor(cursor string) (*ListPosition, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	position := &ListPosition{}
+	if err := json.Unmarshal(b, position); err != nil || len(position.Id) == 0 || !ValidateSpitId(position.Id) {
+		return nil, ErrInvalidCursor
+	}
+	if _, err := time.Parse(time.RFC3339, position.DateCreated); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return position, nil
+}
+
+// ListByOwner returns a page of the Spits of the owner newest first, optionally only
+// of the given type, and the cursor of the next page, empty on the last page.
+// Return
+//
+//	ErrInvalidCursor if the cursor was not returned by a previous call
+func ListByOwner(ctx context.Context, ownerId string, spitType string, cursor string, limit int) ([]*Spit, string, error) {
+	return _List(ctx, cursor, limit, func(after *ListPosition, limit int) ([]*Spit, bool, error) {
+		return storager.ListByOwner(ctx, ownerId, spitType, after, limit)
+	})
+}
+
+// _List() decodes the cursor and clamps the limit of the page before querying it
+func _List(ctx context.Context, cursor string, limit int, query func(after *ListPosition, limit int) ([]*Spit, bool, error)) ([]*Spit, string, error) {
+	if limit <= 0 {
+		limit = SPIT_LIST_DEFAULT_LIMIT
+	} else if limit > SPIT_LIST_MAX_LIMIT {
+		limit = SPIT_LIST_MAX_LIMIT
+	}
+	var after *ListPosition
+	if len(cursor) > 0 {
+		var err error
+		if after, err = _DecodeCursor(cursor); err != nil {
+			return nil, "", err
+		}
+	}
+
+	spits, more, err := query(after, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	next := ""
+	if more && len(spits) > 0 {
+		next = _EncodeCursor(spits[len(spits)-1])
+	}
+	return spits, next, nil
+}
@@ -0,0 +1,139 @@
+package spit
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/lambrospetrou/spito/ids"
+)
+
+func (f *_FakeStorager) list(match func(s *Spit) bool, spitType string, after *ListPosition, limit int) ([]*Spit, bool, error) {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.call("List"); err != nil {
+		return nil, false, err
+	}
+	now := time.Now().UTC()
+	all := make([]*Spit, 0)
+	for _, s := range f.spits {
+		if match(s) && (len(spitType) == 0 || s.SpitType == spitType) && !_IsExpiredAt(s, now) {
+			found := *s
+			all = append(all, &found)
+		}
+	}
+	// newest first like the indexes
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].DateCreated != all[j].DateCreated {
+			return all[i].DateCreated > all[j].DateCreated
+		}
+		return all[i].Id > all[j].Id
+	})
+	start := 0
+	if after != nil {
+		for start < len(all) && (all[start].DateCreated > after.DateCreated ||
+			(all[start].DateCreated == after.DateCreated && all[start].Id >= after.Id)) {
+			start++
+		}
+	}
+	all = all[start:]
+	if len(all) > limit {
+		return all[:limit], true, nil
+	}
+	return all, false, nil
+}
+
+func (f *_FakeStorager) ListByOwner(ctx context.Context, ownerId string, spitType string, after *ListPosition, limit int) ([]*Spit, bool, error) {
+	return f.list(func(s *Spit) bool { return s.OwnerId == ownerId }, spitType, after, limit)
+}
+
+// withIds loads an id alphabet like the one of the storage, the cursors only hold valid ids
+func withIds(t *testing.T) {
+	ids.InitWith("abcdefghijklmnopqrstuvwxyz0123456789")
+	t.Cleanup(func() { ids.InitWith() })
+}
+
+func TestDecodeCursor(t *testing.T) {
+	withIds(t)
+	s := &Spit{Id: "abc1", DateCreated: "2026-01-02T03:04:05Z"}
+	position, err := _DecodeCursor(_EncodeCursor(s))
+	if err != nil || position.Id != s.Id || position.DateCreated != s.DateCreated {
+		t.Fatalf("expected the position of the spit, got %+v %v", position, err)
+	}
+
+	encode := func(json string) string { return base64.RawURLEncoding.EncodeToString([]byte(json)) }
+	tampered := []string{
+		"not base64!",
+		_EncodeCursor(s) + "=",
+		encode(`not json`),
+		encode(`{"i":"abc1"}`),
+		encode(`{"d":"2026-01-02T03:04:05Z"}`),
+		encode(`{"i":"ABC/../1","d":"2026-01-02T03:04:05Z"}`),
+		encode(`["abc1","2026-01-02T03:04:05Z"]`),
+		encode(`{"i":"abc1","d":"2026-01-02"}`),
+		encode(`{"i":"","d":"2026-01-02T03:04:05Z"}`),
+	}
+	for _, cursor := range tampered {
+		if _, err := _DecodeCursor(cursor); err != ErrInvalidCursor {
+			t.Errorf("%q: expected ErrInvalidCursor, got %v", cursor, err)
+		}
+	}
+}
+
+func TestListByOwnerPages(t *testing.T) {
+	withIds(t)
+	f := withFakeStorager(t)
+	created := time.Now().UTC().Add(-time.Hour)
+	for i := 0; i < 5; i++ {
+		id := fmt.Sprintf("id%d", i)
+		f.spits[id] = &Spit{Id: id, OwnerId: "alice", SpitType: SPIT_TYPE_TEXT, DateCreated: created.Add(time.Duration(i) * time.Minute).Format(time.RFC3339)}
+	}
+	f.spits["other"] = &Spit{Id: "other", OwnerId: "bob", SpitType: SPIT_TYPE_TEXT, DateCreated: created.Format(time.RFC3339)}
+	ctx := context.Background()
+
+	var seen []string
+	cursor := ""
+	for page := 0; page < 3; page++ {
+		spits, next, err := ListByOwner(ctx, "alice", "", cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range spits {
+			seen = append(seen, s.Id)
+		}
+		if (page < 2) != (len(next) > 0) {
+			t.Fatalf("page %d: unexpected next cursor %q", page, next)
+		}
+		cursor = next
+	}
+	if fmt.Sprint(seen) != "[id4 id3 id2 id1 id0]" {
+		t.Errorf("expected the spits of alice newest first, got %v", seen)
+	}
+
+	if _, _, err := ListByOwner(ctx, "alice", "", "garbage", 2); err != ErrInvalidCursor {
+		t.Errorf("expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestListLimits(t *testing.T) {
+	cases := map[int]int{
+		0:                       SPIT_LIST_DEFAULT_LIMIT,
+		-1:                      SPIT_LIST_DEFAULT_LIMIT,
+		5:                       5,
+		SPIT_LIST_MAX_LIMIT + 1: SPIT_LIST_MAX_LIMIT,
+	}
+	for limit, expected := range cases {
+		_, _, err := _List(context.Background(), "", limit, func(after *ListPosition, got int) ([]*Spit, bool, error) {
+			if got != expected {
+				t.Errorf("limit %v: expected a page of %v, got %v", limit, expected, got)
+			}
+			return nil, false, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
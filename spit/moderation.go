@@ -0,0 +1,83 @@
+package spit
+
+import (
+	"context"
+	"errors"
+	"math"
+
+	"github.com/lambrospetrou/spito/utils"
+)
+
+// the reasons the moderators disable Spits for
+const (
+	// DISABLED_ABUSE Spits are served as 410 Gone
+	DISABLED_ABUSE string = "abuse"
+	// DISABLED_LEGAL Spits are served as 451 Unavailable For Legal Reasons
+	DISABLED_LEGAL string = "legal"
+)
+
+var ActiveDisableReasons = map[string]bool{
+	DISABLED_ABUSE: true,
+	DISABLED_LEGAL: true,
+}
+
+var (
+	ErrSpitRemoved          = errors.New("Spit was removed for abuse")
+	ErrSpitUnavailableLegal = errors.New("Spit is unavailable for legal reasons")
+	ErrInvalidDisableReason = errors.New("Invalid reason, use abuse or legal")
+)
+
+// DisabledError returns the error to serve instead of the disabled Spit, nil for live Spits.
+func (spit *Spit) DisabledError() error {
+	switch spit.Disabled {
+	case "":
+		return nil
+	case DISABLED_LEGAL:
+		return ErrSpitUnavailableLegal
+	default:
+		return ErrSpitRemoved
+	}
+}
+
+// LoadAny fetches any Spit for the moderators, disabled or not,
+// without counting a click, consuming a view or asking for its password.
+func LoadAny(ctx context.Context, id string) (*Spit, error) {
+	return storager.Get(ctx, id)
+}
+
+// Disable stops serving the Spit for the reason, an empty reason serves it again
+// and marks it as reviewed so that the rescreener does not disable it again.
+func Disable(ctx context.Context, id string, reason string) error {
+	if len(reason) > 0 && !ActiveDisableReasons[reason] {
+		return ErrInvalidDisableReason
+	}
+	return storager.SetDisabled(ctx, id, reason)
+}
+
+// DisableByDomain disables the live URL Spits pointing to the domain or its subdomains
+// and returns their ids. It scans all the Spits so it takes a while on big tables.
+func DisableByDomain(ctx context.Context, domain string, reason string) ([]string, error) {
+	if !ActiveDisableReasons[reason] {
+		return nil, ErrInvalidDisableReason
+	}
+	domain = utils.NormalizeDomain(domain)
+	if len(domain) == 0 {
+		return nil, errors.New("the domain cannot be empty")
+	}
+	disabled := make([]string, 0)
+	// the job runs in the background so it scans to the end of the table at once
+	_, err := storager.ScanURLs(ctx, "", math.MaxInt, func(s *Spit) error {
+		if len(s.Disabled) > 0 || !utils.HostMatchesDomain(utils.URLHost(s.Content), domain) {
+			return nil
+		}
+		if err := storager.SetDisabled(ctx, s.Id, reason); err != nil {
+			if _, notFound := err.(DynamoDbItemNotFoundError); notFound {
+				return nil
+			}
+			return err
+		}
+		disabled = append(disabled, s.Id)
+		return nil
+	})
+	return disabled, err
+}
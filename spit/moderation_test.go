@@ -0,0 +1,158 @@
+package spit
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func (f *_FakeStorager) SetDisabled(ctx context.Context, id string, reason string) error {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.call("SetDisabled"); err != nil {
+		return err
+	}
+	s, ok := f.spits[id]
+	if !ok {
+		return _NotFound(id)
+	}
+	s.Disabled = reason
+	if len(reason) == 0 {
+		s.Reviewed = true
+	}
+	return nil
+}
+
+func (f *_FakeStorager) ScanURLs(ctx context.Context, cursor string, limit int, fn func(s *Spit) error) (string, error) {
+	f.Lock()
+	if err := f.call("ScanURLs"); err != nil {
+		f.Unlock()
+		return cursor, err
+	}
+	now := time.Now().UTC()
+	spits := make([]*Spit, 0)
+	for _, s := range f.spits {
+		if s.SpitType == SPIT_TYPE_URL && !_IsExpiredAt(s, now) && s.Id > cursor {
+			found := *s
+			spits = append(spits, &found)
+		}
+	}
+	f.Unlock()
+	sort.Slice(spits, func(i, j int) bool { return spits[i].Id < spits[j].Id })
+	for i, s := range spits {
+		if err := fn(s); err != nil {
+			return cursor, err
+		}
+		cursor = s.Id
+		if i+1 >= limit && i+1 < len(spits) {
+			return cursor, nil
+		}
+	}
+	return "", nil
+}
+
+func (f *_FakeStorager) PutAudit(ctx context.Context, e *AuditEntry) error {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.call("PutAudit"); err != nil {
+		return err
+	}
+	stored := *e
+	f.audit = append(f.audit, &stored)
+	return nil
+}
+
+func (f *_FakeStorager) ListAudit(ctx context.Context, day string, limit int) ([]*AuditEntry, error) {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.call("ListAudit"); err != nil {
+		return nil, err
+	}
+	entries := make([]*AuditEntry, 0)
+	for i := len(f.audit) - 1; i >= 0 && len(entries) < limit; i-- {
+		if f.audit[i].Day == day {
+			entries = append(entries, f.audit[i])
+		}
+	}
+	return entries, nil
+}
+
+func TestDisableByDomain(t *testing.T) {
+	f := withFakeStorager(t)
+	for _, s := range []*Spit{
+		{Id: "a", SpitType: SPIT_TYPE_URL, Content: "https://evil.com/a", Exp: SPIT_EXP_NEVER},
+		{Id: "b", SpitType: SPIT_TYPE_URL, Content: "https://cdn.evil.com/b", Exp: SPIT_EXP_NEVER},
+		{Id: "c", SpitType: SPIT_TYPE_URL, Content: "https://notevil.com/c", Exp: SPIT_EXP_NEVER},
+		{Id: "d", SpitType: SPIT_TYPE_TEXT, Content: "https://evil.com/d", Exp: SPIT_EXP_NEVER},
+		{Id: "e", SpitType: SPIT_TYPE_URL, Content: "https://evil.com/e", Exp: SPIT_EXP_NEVER, Disabled: DISABLED_LEGAL},
+	} {
+		f.spits[s.Id] = s
+	}
+
+	if _, err := DisableByDomain(t.Context(), "evil.com", "spam"); err != ErrInvalidDisableReason {
+		t.Errorf("expected the invalid reason error, got %v", err)
+	}
+	disabled, err := DisableByDomain(t.Context(), "Evil.com.", DISABLED_ABUSE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(disabled) != 2 || disabled[0] != "a" || disabled[1] != "b" {
+		t.Errorf("expected the URL spits of the domain and its subdomains, got %v", disabled)
+	}
+	if len(f.spits["c"].Disabled) > 0 || len(f.spits["d"].Disabled) > 0 || f.spits["e"].Disabled != DISABLED_LEGAL {
+		t.Error("only the live URL spits of the domain should be disabled")
+	}
+}
+
+func TestEnableMarksReviewed(t *testing.T) {
+	f := withFakeStorager(t)
+	f.spits["a"] = &Spit{Id: "a", SpitType: SPIT_TYPE_URL, Content: "https://a.com", Exp: SPIT_EXP_NEVER, Disabled: DISABLED_ABUSE}
+	if err := Disable(t.Context(), "a", "spam"); err != ErrInvalidDisableReason {
+		t.Errorf("expected the invalid reason error, got %v", err)
+	}
+	if err := Disable(t.Context(), "a", ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.spits["a"].Disabled) > 0 || !f.spits["a"].Reviewed {
+		t.Errorf("the enabled spit should be live and reviewed, got %+v", f.spits["a"])
+	}
+}
+
+func TestAuditListsTheDayNewestFirst(t *testing.T) {
+	withFakeStorager(t)
+	for _, action := range []string{AUDIT_ACTION_DISABLE, AUDIT_ACTION_ENABLE} {
+		if err := Audit(t.Context(), &AuditEntry{Actor: "staff", Action: action, SpitId: "a"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := ListAudit(t.Context(), time.Now().UTC().Format(time.DateOnly), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Action != AUDIT_ACTION_ENABLE {
+		t.Errorf("expected the entries of today newest first, got %v", entries)
+	}
+}
+
+func TestDynamoAuditListsTheDayNewestFirst(t *testing.T) {
+	withFakeDynamo(t)
+	for i := 0; i < _BATCH_GET_MAX_KEYS+5; i++ {
+		if err := Audit(t.Context(), &AuditEntry{Actor: "staff", Action: AUDIT_ACTION_LOOKUP, SpitId: strconv.Itoa(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	today := time.Now().UTC().Format(time.DateOnly)
+	entries, err := ListAudit(t.Context(), today, _BATCH_GET_MAX_KEYS+2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != _BATCH_GET_MAX_KEYS+2 || entries[0].SpitId != strconv.Itoa(_BATCH_GET_MAX_KEYS+4) ||
+		entries[len(entries)-1].SpitId != "3" {
+		t.Errorf("expected the last entries of the day newest first, got %v entries from %v", len(entries), entries[0].SpitId)
+	}
+	if entries, err := ListAudit(t.Context(), "2000-01-01", 10); err != nil || len(entries) != 0 {
+		t.Errorf("expected no entries for a day without any, got %v %v", entries, err)
+	}
+}
@@ -0,0 +1,105 @@
+package spit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrNotOwner is returned when the Spit does not belong to the owner of the request.
+// Anonymous Spits belong to nobody.
+var ErrNotOwner = errors.New("Spit belongs to another owner")
+
+func (spit *Spit) IsOwnedBy(ownerId string) bool {
+	return len(spit.OwnerId) > 0 && spit.OwnerId == ownerId
+}
+
+// LoadOwned fetches the Spit for its owner, or a member of its workspace with
+// at least the given role, without counting a click, consuming a view or asking for its password.
+// Return
+//
+//	ErrNotOwner if the Spit belongs to another owner or workspace
+//	ErrRoleForbidden if the role of the member is not enough
+func LoadOwned(ctx context.Context, id string, ownerId string, role string) (*Spit, error) {
+	s, err := storager.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := CanAccess(ctx, s, ownerId, role); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// DeleteOwned deletes the Spit if the owner can edit it, freeing its slug.
+func DeleteOwned(ctx context.Context, id string, ownerId string) error {
+	s, err := LoadOwned(ctx, id, ownerId, ROLE_EDITOR)
+	if err != nil {
+		return err
+	}
+	if err := storager.Delete(ctx, id); err != nil {
+		return err
+	}
+	if len(s.Slug) > 0 {
+		return storager.DeleteSlug(ctx, s.Slug, s.Id)
+	}
+	return nil
+}
+
+// EditFromRequest changes the Spit with the content, exp or expires_at of the request,
+// validated like the ones of new Spits. The new expiration counts from now.
+// Return
+//
+//	a SpitError with the invalid parameters, the Spit is left untouched then
+func EditFromRequest(r *http.Request, s *Spit) error {
+	content := r.FormValue("content")
+	exp := strings.TrimSpace(r.FormValue("exp"))
+	expiresAt := strings.TrimSpace(r.FormValue("expires_at"))
+
+	spitError := &SpitError{make(map[string]string)}
+	if len(content) == 0 && len(exp) == 0 && len(expiresAt) == 0 {
+		spitError.ErrorsMap["Generic"] = "Nothing to edit, set content, exp or expires_at"
+		return spitError
+	}
+
+	edited := *s
+	if len(exp) > 0 || len(expiresAt) > 0 {
+		expInt, expErr := _ValidateExp(exp, expiresAt)
+		if len(expErr) > 0 {
+			spitError.ErrorsMap["Exp"] = expErr
+		} else if expInt == SPIT_EXP_NEVER {
+			edited.Exp = SPIT_EXP_NEVER
+			edited.DateExpiration = ""
+		} else {
+			// Exp always counts from the creation of the Spit
+			expiration := time.Now().UTC().Add(time.Duration(expInt) * time.Second)
+			edited.DateExpiration = expiration.Format(time.RFC3339)
+			edited.Exp = int(math.Ceil(expiration.Sub(s.DateCreatedTime()).Seconds()))
+		}
+	}
+	if len(content) > 0 {
+		validContent, contentErr := _ValidateContent(r.Context(), content, s.SpitType, s.Encryption)
+		if len(contentErr) > 0 {
+			spitError.ErrorsMap["Content"] = contentErr
+		}
+		if validContent != s.Content {
+			// the moderators reviewed the old content only
+			edited.Reviewed = false
+		}
+		edited.Content = validContent
+	}
+
+	if len(spitError.ErrorsMap) > 0 {
+		return spitError
+	}
+	*s = edited
+	return nil
+}
+
+// Update stores the edited content and expiration of the Spit.
+func Update(ctx context.Context, s *Spit) error {
+	return storager.Update(ctx, s)
+}
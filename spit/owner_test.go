@@ -0,0 +1,87 @@
+package spit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func (f *_FakeStorager) Update(ctx context.Context, s *Spit) error {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.call("Update"); err != nil {
+		return err
+	}
+	if _, ok := f.spits[s.Id]; !ok {
+		return _NotFound(s.Id)
+	}
+	stored := *s
+	f.spits[s.Id] = &stored
+	return nil
+}
+
+func TestEditFromRequest(t *testing.T) {
+	created := time.Now().UTC().Add(-time.Hour)
+	original := Spit{
+		Id:          "edit",
+		SpitType:    SPIT_TYPE_TEXT,
+		Content:     "before",
+		Exp:         2 * 60 * 60,
+		DateCreated: created.Format(time.RFC3339),
+		Reviewed:    true,
+	}
+	cases := []struct {
+		form  url.Values
+		field string
+	}{
+		{url.Values{}, "Generic"},
+		{url.Values{"exp": {"-5"}}, "Exp"},
+		{url.Values{"exp": {"1h"}, "expires_at": {time.Now().Add(time.Hour).Format(time.RFC3339)}}, "Exp"},
+		{url.Values{"content": {"   "}}, "Content"},
+		{url.Values{"content": {strings.Repeat("a", SPIT_MAX_CONTENT+1)}}, "Content"},
+	}
+	for _, c := range cases {
+		s := original
+		err := EditFromRequest(newFormRequest(c.form), &s)
+		spitErr, ok := err.(*SpitError)
+		if !ok || len(spitErr.ErrorsMap[c.field]) == 0 {
+			t.Errorf("%v: expected an error for %v, got %v", c.form, c.field, err)
+		}
+		if s != original {
+			t.Errorf("%v: the spit should not change on errors, got %+v", c.form, s)
+		}
+	}
+
+	s := original
+	if err := EditFromRequest(newFormRequest(url.Values{"content": {" after "}, "exp": {"3600"}}), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Content != "after" {
+		t.Errorf("expected the trimmed content, got %q", s.Content)
+	}
+	if s.Reviewed {
+		t.Error("new content should be reviewed again")
+	}
+	// the new expiration counts from now, Exp from the creation
+	if s.Exp < 2*60*60 || s.Exp > 2*60*60+5 {
+		t.Errorf("expected Exp of about two hours since the creation, got %v", s.Exp)
+	}
+
+	s = original
+	if err := EditFromRequest(newFormRequest(url.Values{"exp": {"0"}}), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Exp != SPIT_EXP_NEVER || len(s.DateExpiration) > 0 {
+		t.Errorf("expected a spit that never expires, got %+v", s)
+	}
+}
+
+func newFormRequest(form url.Values) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/v1/spits/edit", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
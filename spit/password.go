@@ -0,0 +1,118 @@
+package spit
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// bcrypt only takes into account the first 72 bytes of the password
+	SPIT_PASSWORD_MAX_LENGTH int = 72
+
+	_PASSWORD_MAX_ATTEMPTS     int           = 5
+	_PASSWORD_ATTEMPTS_WINDOW  time.Duration = 15 * time.Minute
+	_PASSWORD_CLEANUP_INTERVAL time.Duration = time.Minute
+)
+
+var (
+	ErrPasswordRequired = errors.New("Spit is password protected")
+	ErrPasswordWrong    = errors.New("Wrong Spit password")
+	ErrPasswordAttempts = errors.New("Too many wrong password attempts")
+)
+
+type _passwordAttempt struct {
+	count int
+	start time.Time
+}
+
+// _passwordAttempts keeps the wrong password attempts per Spit id
+// for the current window in order to slow down brute-forcing.
+var _passwordAttempts = struct {
+	sync.Mutex
+	byId        map[string]*_passwordAttempt
+	lastCleanup time.Time
+}{byId: make(map[string]*_passwordAttempt)}
+
+func _HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (spit *Spit) IsProtected() bool {
+	return len(spit.PasswordHash) > 0
+}
+
+// _ReservePasswordAttempt() counts the attempt before the slow comparison so that
+// concurrent guesses cannot all pass the check, it returns false if there are no attempts left.
+func _ReservePasswordAttempt(id string) bool {
+	_passwordAttempts.Lock()
+	defer _passwordAttempts.Unlock()
+
+	now := time.Now()
+	_CleanupPasswordAttempts(now)
+	attempt, ok := _passwordAttempts.byId[id]
+	if !ok || now.Sub(attempt.start) > _PASSWORD_ATTEMPTS_WINDOW {
+		attempt = &_passwordAttempt{start: now}
+		_passwordAttempts.byId[id] = attempt
+	}
+	if attempt.count >= _PASSWORD_MAX_ATTEMPTS {
+		return false
+	}
+	attempt.count++
+	return true
+}
+
+// _ReleasePasswordAttempt() gives back the attempt of a correct password.
+func _ReleasePasswordAttempt(id string) {
+	_passwordAttempts.Lock()
+	defer _passwordAttempts.Unlock()
+
+	if attempt, ok := _passwordAttempts.byId[id]; ok && attempt.count > 0 {
+		attempt.count--
+	}
+}
+
+// _CleanupPasswordAttempts() drops the stale windows so the map does not grow forever,
+// the caller holds the lock.
+func _CleanupPasswordAttempts(now time.Time) {
+	if now.Sub(_passwordAttempts.lastCleanup) < _PASSWORD_CLEANUP_INTERVAL {
+		return
+	}
+	_passwordAttempts.lastCleanup = now
+	for id, attempt := range _passwordAttempts.byId {
+		if now.Sub(attempt.start) > _PASSWORD_ATTEMPTS_WINDOW {
+			delete(_passwordAttempts.byId, id)
+		}
+	}
+}
+
+// VerifyPassword checks the given password against the one of the Spit.
+// Spits without a password always pass the check.
+// Return
+//
+//	ErrPasswordRequired if the Spit is protected and no password was given
+//	ErrPasswordWrong if the password does not match
+//	ErrPasswordAttempts if there were too many wrong attempts for this Spit recently
+func VerifyPassword(spit *Spit, password string) error {
+	if !spit.IsProtected() {
+		return nil
+	}
+	if len(password) == 0 {
+		return ErrPasswordRequired
+	}
+	id := spit.Id
+	if !_ReservePasswordAttempt(id) {
+		return ErrPasswordAttempts
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(spit.PasswordHash), []byte(password)); err != nil {
+		return ErrPasswordWrong
+	}
+	_ReleasePasswordAttempt(id)
+	return nil
+}
@@ -0,0 +1,78 @@
+package spit
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestVerifyPassword(t *testing.T) {
+	hash, err := _HashPassword("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash == "hunter2" {
+		t.Fatal("the password should not be stored as is")
+	}
+	s := &Spit{Id: "verify", PasswordHash: hash}
+	if err := VerifyPassword(s, ""); err != ErrPasswordRequired {
+		t.Errorf("expected ErrPasswordRequired, got %v", err)
+	}
+	if err := VerifyPassword(s, "wrong"); err != ErrPasswordWrong {
+		t.Errorf("expected ErrPasswordWrong, got %v", err)
+	}
+	if err := VerifyPassword(s, "hunter2"); err != nil {
+		t.Errorf("the right password should pass, got %v", err)
+	}
+	if err := VerifyPassword(&Spit{Id: "open"}, ""); err != nil {
+		t.Errorf("unprotected spits should always pass, got %v", err)
+	}
+}
+
+func TestPasswordLockout(t *testing.T) {
+	hash, err := _HashPassword("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Spit{Id: "lockout", PasswordHash: hash}
+	for i := 0; i < _PASSWORD_MAX_ATTEMPTS-1; i++ {
+		if err := VerifyPassword(s, "wrong"); err != ErrPasswordWrong {
+			t.Fatalf("attempt %v: expected ErrPasswordWrong, got %v", i, err)
+		}
+	}
+	// the right password does not use up an attempt
+	if err := VerifyPassword(s, "hunter2"); err != nil {
+		t.Fatalf("the right password should pass, got %v", err)
+	}
+	if err := VerifyPassword(s, "wrong"); err != ErrPasswordWrong {
+		t.Fatalf("the last attempt should be allowed, got %v", err)
+	}
+	if err := VerifyPassword(s, "hunter2"); err != ErrPasswordAttempts {
+		t.Errorf("expected ErrPasswordAttempts after the lockout, got %v", err)
+	}
+}
+
+func TestPasswordLockoutConcurrent(t *testing.T) {
+	hash, err := _HashPassword("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Spit{Id: "concurrent", PasswordHash: hash}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	wrong := 0
+	for i := 0; i < 4*_PASSWORD_MAX_ATTEMPTS; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if VerifyPassword(s, "wrong") == ErrPasswordWrong {
+				mu.Lock()
+				wrong++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if wrong != _PASSWORD_MAX_ATTEMPTS {
+		t.Errorf("expected %v compared guesses, got %v", _PASSWORD_MAX_ATTEMPTS, wrong)
+	}
+}
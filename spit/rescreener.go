@@ -0,0 +1,205 @@
+package spit
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lambrospetrou/spito/screening"
+	"github.com/lambrospetrou/spito/tracing"
+)
+
+const (
+	_RESCREENER_LEASE_NAME string = "rescreener"
+	// _RESCREENER_ACTOR is the actor of the audit entries of the Spits it disables
+	_RESCREENER_ACTOR string = "screener"
+	// _RESCREENER_BATCH_SIZE is how many Spits are scanned between saving the cursor
+	_RESCREENER_BATCH_SIZE int = 100
+)
+
+// RescreenerStats are the counters of the rescreener since the process started.
+type RescreenerStats struct {
+	Runs     uint64
+	Skipped  uint64
+	Screened uint64
+	Disabled uint64
+	Errors   uint64
+}
+
+var _rescreenerStats RescreenerStats
+
+// GetRescreenerStats returns a snapshot of the rescreener counters.
+func GetRescreenerStats() RescreenerStats {
+	return RescreenerStats{
+		Runs:     atomic.LoadUint64(&_rescreenerStats.Runs),
+		Skipped:  atomic.LoadUint64(&_rescreenerStats.Skipped),
+		Screened: atomic.LoadUint64(&_rescreenerStats.Screened),
+		Disabled: atomic.LoadUint64(&_rescreenerStats.Disabled),
+		Errors:   atomic.LoadUint64(&_rescreenerStats.Errors),
+	}
+}
+
+// StartRescreener starts a background goroutine that every interval screens again the live
+// URL Spits with the URL screener and disables for abuse the ones confirmed malicious,
+// since links are often weaponized after they are shared. Only the instance holding
+// the rescreener lease does the work, screening concurrency URLs at a time each
+// within urlTimeout, and it continues from where the last run stopped.
+// It returns a function that stops the rescreener, cancelling the current run, and waits for it.
+func StartRescreener(interval time.Duration, concurrency int, urlTimeout time.Duration) func() {
+	owner := _SweeperOwner()
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				_Rescreen(ctx, owner, interval, concurrency, urlTimeout)
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+func _Rescreen(ctx context.Context, owner string, interval time.Duration, concurrency int, urlTimeout time.Duration) {
+	if _urlScreener == nil {
+		return
+	}
+	atomic.AddUint64(&_rescreenerStats.Runs, 1)
+
+	// a run must never overlap with the next one
+	ctx, cancel := context.WithTimeout(ctx, interval)
+	defer cancel()
+	ctx, span := tracing.Start(ctx, "rescreener.Rescreen")
+	defer span.End()
+
+	lease, err := storager.AcquireLease(ctx, _RESCREENER_LEASE_NAME, owner, 2*interval)
+	if err != nil {
+		atomic.AddUint64(&_rescreenerStats.Errors, 1)
+		slog.ErrorContext(ctx, "rescreener could not acquire the lease", "err", err)
+		return
+	}
+	if lease == nil {
+		atomic.AddUint64(&_rescreenerStats.Skipped, 1)
+		return
+	}
+
+	// the scan continues from the cursor of the lease, whoever held it last, and the cursor
+	// moves only past whole batches so that a stopped run screens its last batch again
+	cursor := lease.Cursor
+	disabled := 0
+	defer func() {
+		if disabled > 0 {
+			slog.InfoContext(ctx, "rescreener disabled malicious spits", "count", disabled)
+		}
+	}()
+	for {
+		batch := make([]*Spit, 0, _RESCREENER_BATCH_SIZE)
+		next, err := storager.ScanURLs(ctx, cursor, _RESCREENER_BATCH_SIZE, func(s *Spit) error {
+			if len(s.Disabled) == 0 && !s.Reviewed {
+				batch = append(batch, s)
+			}
+			return nil
+		})
+		if err != nil {
+			atomic.AddUint64(&_rescreenerStats.Errors, 1)
+			slog.ErrorContext(ctx, "rescreener could not scan the spits", "err", err)
+			return
+		}
+		disabled += _RescreenBatch(ctx, batch, concurrency, urlTimeout)
+		if ctx.Err() != nil {
+			return
+		}
+		if errSave := storager.SaveLeaseCursor(context.WithoutCancel(ctx), lease, next); errSave != nil {
+			slog.WarnContext(ctx, "rescreener could not save its cursor", "err", errSave)
+		}
+		if len(next) == 0 {
+			return
+		}
+		cursor = next
+	}
+}
+
+// _RescreenBatch screens the Spits concurrency at a time and returns how many it disabled.
+func _RescreenBatch(ctx context.Context, batch []*Spit, concurrency int, urlTimeout time.Duration) int {
+	var disabled int64
+	var wg sync.WaitGroup
+	spits := make(chan *Spit)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for s := range spits {
+				if ctx.Err() == nil && _RescreenSpit(ctx, s, urlTimeout) {
+					atomic.AddInt64(&disabled, 1)
+				}
+			}
+		}()
+	}
+	for _, s := range batch {
+		spits <- s
+	}
+	close(spits)
+	wg.Wait()
+	return int(disabled)
+}
+
+// _RescreenSpit screens the URL of the Spit again and disables it if a screener confirms
+// it is malicious, a hash prefix match alone is left for the staff to review.
+func _RescreenSpit(ctx context.Context, s *Spit, urlTimeout time.Duration) bool {
+	urlCtx, cancel := context.WithTimeout(ctx, urlTimeout)
+	v, err := _urlScreener.Screen(urlCtx, s.Content)
+	cancel()
+	if err != nil {
+		if ctx.Err() != nil {
+			return false
+		}
+		if errors.Is(err, screening.ErrUnconfirmed) {
+			atomic.AddUint64(&_rescreenerStats.Screened, 1)
+			slog.InfoContext(ctx, "rescreener found an unconfirmed threat", "err", err, "spit_id", s.Id)
+			return false
+		}
+		// the next pass screens it again
+		atomic.AddUint64(&_rescreenerStats.Errors, 1)
+		slog.WarnContext(ctx, "rescreener could not screen the spit", "err", err, "spit_id", s.Id)
+		return false
+	}
+	atomic.AddUint64(&_rescreenerStats.Screened, 1)
+	if !v.Malicious {
+		return false
+	}
+
+	// the disable is recorded before it is made, so that no Spit is disabled without a trace
+	entry := &AuditEntry{Actor: _RESCREENER_ACTOR, Action: AUDIT_ACTION_DISABLE, SpitId: s.Id,
+		Reason: DISABLED_ABUSE, Note: v.Provider + ": " + v.Threat}
+	if err := Audit(ctx, entry); err != nil {
+		atomic.AddUint64(&_rescreenerStats.Errors, 1)
+		slog.ErrorContext(ctx, "could not record the audit entry", "err", err, "action", entry.Action, "spit_id", s.Id)
+		return false
+	}
+	if err := storager.SetDisabled(ctx, s.Id, DISABLED_ABUSE); err != nil {
+		if _, notFound := err.(DynamoDbItemNotFoundError); !notFound {
+			atomic.AddUint64(&_rescreenerStats.Errors, 1)
+			slog.ErrorContext(ctx, "rescreener could not disable the spit", "err", err, "spit_id", s.Id)
+		}
+		return false
+	}
+	atomic.AddUint64(&_rescreenerStats.Disabled, 1)
+	return true
+}
@@ -0,0 +1,93 @@
+package spit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lambrospetrou/spito/screening"
+)
+
+type _FakeScreener struct {
+	sync.Mutex
+	verdicts map[string]screening.Verdict
+	errs     map[string]error
+	screened []string
+}
+
+func (f *_FakeScreener) Screen(ctx context.Context, rawURL string) (screening.Verdict, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.screened = append(f.screened, rawURL)
+	return f.verdicts[rawURL], f.errs[rawURL]
+}
+
+func withFakeScreener(t *testing.T) *_FakeScreener {
+	f := &_FakeScreener{verdicts: make(map[string]screening.Verdict), errs: make(map[string]error)}
+	SetURLScreener(f, false)
+	t.Cleanup(func() { SetURLScreener(nil, false) })
+	return f
+}
+
+func TestRescreenDisablesOnlyConfirmedThreats(t *testing.T) {
+	f := withFakeStorager(t)
+	screener := withFakeScreener(t)
+	for _, s := range []*Spit{
+		{Id: "a", SpitType: SPIT_TYPE_URL, Content: "https://evil.com", Exp: SPIT_EXP_NEVER},
+		{Id: "b", SpitType: SPIT_TYPE_URL, Content: "https://maybe-evil.com", Exp: SPIT_EXP_NEVER},
+		{Id: "c", SpitType: SPIT_TYPE_URL, Content: "https://reviewed.com", Exp: SPIT_EXP_NEVER, Reviewed: true},
+		{Id: "d", SpitType: SPIT_TYPE_URL, Content: "https://good.com", Exp: SPIT_EXP_NEVER},
+	} {
+		f.spits[s.Id] = s
+	}
+	screener.verdicts["https://evil.com"] = screening.Verdict{Malicious: true, Threat: "MALWARE", Provider: "hashlist"}
+	screener.verdicts["https://reviewed.com"] = screening.Verdict{Malicious: true, Threat: "MALWARE", Provider: "hashlist"}
+	screener.errs["https://maybe-evil.com"] = fmt.Errorf("%w: MALWARE", screening.ErrUnconfirmed)
+
+	_Rescreen(t.Context(), "me", time.Minute, 2, time.Second)
+
+	if f.spits["a"].Disabled != DISABLED_ABUSE {
+		t.Error("a confirmed threat should be disabled")
+	}
+	if len(f.spits["b"].Disabled) > 0 {
+		t.Error("a prefix only match should be left for review")
+	}
+	if len(f.spits["c"].Disabled) > 0 || len(screener.screened) != 3 {
+		t.Errorf("the reviewed spits should not be screened, screened %v", screener.screened)
+	}
+	if len(f.audit) != 1 || f.audit[0].SpitId != "a" || f.audit[0].Actor != _RESCREENER_ACTOR {
+		t.Errorf("expected the disable audited, got %v", f.audit)
+	}
+	if f.leases[_RESCREENER_LEASE_NAME].Cursor != "" {
+		t.Errorf("the scan should start over at the end of the table, cursor %q", f.leases[_RESCREENER_LEASE_NAME].Cursor)
+	}
+}
+
+func TestRescreenContinuesFromTheLeaseCursor(t *testing.T) {
+	f := withFakeStorager(t)
+	screener := withFakeScreener(t)
+	for _, id := range []string{"a", "b", "c"} {
+		f.spits[id] = &Spit{Id: id, SpitType: SPIT_TYPE_URL, Content: "https://" + id + ".com", Exp: SPIT_EXP_NEVER}
+	}
+	f.leases[_RESCREENER_LEASE_NAME] = &Lease{Name: _RESCREENER_LEASE_NAME, Cursor: "a"}
+
+	_Rescreen(t.Context(), "me", time.Minute, 1, time.Second)
+	if len(screener.screened) != 2 || screener.screened[0] != "https://b.com" {
+		t.Errorf("the run should continue after a, screened %v", screener.screened)
+	}
+}
+
+func TestRescreenKeepsTheCursorOfAnUnfinishedBatch(t *testing.T) {
+	f := withFakeStorager(t)
+	withFakeScreener(t)
+	f.spits["a"] = &Spit{Id: "a", SpitType: SPIT_TYPE_URL, Content: "https://a.com", Exp: SPIT_EXP_NEVER}
+	f.leases[_RESCREENER_LEASE_NAME] = &Lease{Name: _RESCREENER_LEASE_NAME, Cursor: "0"}
+	f.failNext("ScanURLs", fmt.Errorf("scan failed"))
+
+	_Rescreen(t.Context(), "me", time.Minute, 1, time.Second)
+	if f.leases[_RESCREENER_LEASE_NAME].Cursor != "0" {
+		t.Errorf("the cursor should not move past spits that were not screened, cursor %q", f.leases[_RESCREENER_LEASE_NAME].Cursor)
+	}
+}
@@ -0,0 +1,59 @@
+package spit
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/lambrospetrou/spito/metrics"
+	"github.com/lambrospetrou/spito/screening"
+	"github.com/lambrospetrou/spito/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	ErrMaliciousURL         = errors.New("URL was reported as malicious")
+	ErrScreeningUnavailable = errors.New("URL could not be checked for threats, try again later")
+)
+
+var (
+	_urlScreener      screening.URLScreener
+	_screenFailClosed bool
+)
+
+// SetURLScreener screens the URLs of new Spits, and of the existing ones with the rescreener.
+// With failClosed the new URLs are rejected when the screener cannot tell whether they are malicious.
+func SetURLScreener(s screening.URLScreener, failClosed bool) {
+	_urlScreener = s
+	_screenFailClosed = failClosed
+}
+
+// _ScreenURL checks the URL of a new Spit with the screener, if there is one.
+func _ScreenURL(ctx context.Context, rawURL string) error {
+	if _urlScreener == nil {
+		return nil
+	}
+	ctx, span := tracing.Start(ctx, "screening.Screen")
+	defer span.End()
+	v, err := _urlScreener.Screen(ctx, rawURL)
+	if err != nil {
+		if errors.Is(err, screening.ErrUnconfirmed) {
+			metrics.URLChecks.WithLabelValues("unconfirmed").Inc()
+		} else {
+			metrics.URLChecks.WithLabelValues("screening_failed").Inc()
+		}
+		slog.WarnContext(ctx, "could not screen the URL", "err", err, "fail_closed", _screenFailClosed)
+		if _screenFailClosed {
+			return ErrScreeningUnavailable
+		}
+		return nil
+	}
+	span.SetAttributes(attribute.Bool("spito.url.malicious", v.Malicious))
+	if v.Malicious {
+		metrics.URLChecks.WithLabelValues("malicious").Inc()
+		slog.InfoContext(ctx, "rejected a malicious URL", "threat", v.Threat, "provider", v.Provider)
+		return ErrMaliciousURL
+	}
+	return nil
+}
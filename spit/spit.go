@@ -1,33 +1,64 @@
 package spit
 
 import (
+	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"math"
+	"math/rand"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/lambrospetrou/spito/ids"
+	"github.com/lambrospetrou/spito/metrics"
+	"github.com/lambrospetrou/spito/spitcrypt"
+	"github.com/lambrospetrou/spito/tracing"
+	"github.com/lambrospetrou/spito/urlpolicy"
 	"github.com/lambrospetrou/spito/utils"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const (
 	SPIT_MAX_CONTENT int = 10000
+	// encrypted content is base64 encoded and carries a 12-byte nonce and a 16-byte tag
+	SPIT_MAX_CONTENT_ENCRYPTED int = (SPIT_MAX_CONTENT + spitcrypt.NONCE_SIZE + spitcrypt.TAG_SIZE + 2) / 3 * 4
+
+	// SPIT_EXP_NEVER is the expiration of Spits that never expire,
+	// they have an empty DateExpiration.
+	SPIT_EXP_NEVER int = 0
+	// SPIT_EXP_MAX keeps the expirations far from overflowing time.Duration
+	SPIT_EXP_MAX int = 100 * 365 * 24 * 60 * 60
+
+	_SAVE_MAX_ATTEMPTS int           = 4
+	_SAVE_BACKOFF_BASE time.Duration = 50 * time.Millisecond
+	_SAVE_BACKOFF_MAX  time.Duration = time.Second
 )
 
-func _BuildSpitKey(id string) string {
-	return _SPIT_KEY_PREFIX + id
-}
+// SpitMaxLifetime is the maximum lifetime allowed for new Spits, 0 means unlimited.
+// When set, Spits that never expire are not allowed either.
+var SpitMaxLifetime time.Duration = utils.EnvDuration("SPITO_MAX_LIFETIME", 0)
 
-func _BuildSpitIdFromKey(key string) string {
-	return strings.Replace(key, _SPIT_KEY_PREFIX, "", -1)
+var storager Storager = nil
+
+// Init prepares the default storage for serving Spits.
+// It has to be called before any Spit is loaded or saved.
+func Init() {
+	storager = NewInstrumentedStorager(NewDefaultStorager())
 }
 
-var storager Storager = nil
+// InitAdmin connects to the default storage for the admin commands only.
+func InitAdmin() {
+	storager = NewDefaultAdminStorager()
+}
 
-func init() {
-	storager = NewDefaultStorager()
+// InitWith uses the given storage, e.g. a fake one in the tests of the handlers.
+func InitWith(s Storager) {
+	storager = s
 }
 
 const (
@@ -40,6 +71,11 @@ var ActiveSpitTypes map[string]bool = map[string]bool{
 	SPIT_TYPE_TEXT: true,
 }
 
+// ActiveEncryptions are the encryptions done by the clients, see package spitcrypt
+var ActiveEncryptions map[string]bool = map[string]bool{
+	spitcrypt.ENCRYPTION: true,
+}
+
 type Spit struct {
 	Id             string `json:"id"`
 	Exp            int    `json:"exp"`
@@ -48,6 +84,29 @@ type Spit struct {
 	DateExpiration string `json:"date_expiration"`
 	SpitType       string `json:"spit_type"`
 	MetricClicks   uint64 `json:"metric_clicks"`
+	PasswordHash   string `json:"password_hash,omitempty"`
+	// Encryption is empty for plain Spits, otherwise the content is an opaque blob
+	Encryption string `json:"encryption,omitempty"`
+	// MaxViews is 0 for Spits with unlimited views, otherwise the Spit
+	// is deleted once ViewsLeft reaches 0.
+	MaxViews  int `json:"max_views,omitempty"`
+	ViewsLeft int `json:"views_left,omitempty"`
+	// TTL is the expiration in epoch seconds set by storages with native expiration
+	TTL int64 `json:"ttl,omitempty"`
+	// OwnerId is the owner of the API key that created the Spit, empty for anonymous Spits
+	OwnerId string `json:"owner_id,omitempty"`
+	// WorkspaceId is the workspace sharing the Spit, empty for personal Spits
+	WorkspaceId string `json:"workspace_id,omitempty"`
+	// Slug is the path of the link of a workspace Spit, e.g. acme/q3-report
+	Slug string `json:"slug,omitempty"`
+	// Disabled is the reason the moderators disabled the Spit for, empty for live Spits
+	Disabled string `json:"disabled,omitempty"`
+	// Reviewed is set once the moderators enabled the Spit again, the rescreener leaves it alone
+	// until its content changes
+	Reviewed bool `json:"reviewed,omitempty"`
+	// the creator of the Spit, kept for the moderators to follow up on abuse reports
+	CreatorIP        string `json:"creator_ip,omitempty"`
+	CreatorUserAgent string `json:"creator_user_agent,omitempty"`
 }
 
 func (spit *Spit) DateCreatedTime() time.Time {
@@ -65,22 +124,87 @@ func (spit *Spit) RemainingExpiration() int {
 		time.Now().UTC().Unix()) - 1
 }
 
-func (spit *Spit) IdHashOnly() string {
-	return _BuildSpitIdFromKey(spit.Id)
+func (spit *Spit) Expires() bool {
+	return spit.Exp != SPIT_EXP_NEVER
 }
 
-func (spit *Spit) Save() error {
-	id, err := storager.NextId()
-	if err != nil {
-		log.Println("Error while building next id: ", err, spit)
-		return err
+// ExpirationDate returns the RFC3339 expiration date or an empty string if the Spit never expires.
+// Spits created before SPIT_EXP_NEVER was explicit have their creation date stored instead.
+func (spit *Spit) ExpirationDate() string {
+	if !spit.Expires() {
+		return ""
+	}
+	return spit.DateExpiration
+}
+
+func (spit *Spit) IsEncrypted() bool {
+	return len(spit.Encryption) > 0
+}
+
+// LogValue makes the loggers print the Spit without its content and password,
+// private pastes must never end up in the logs.
+func (spit *Spit) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("id", spit.Id),
+		slog.String("spit_type", spit.SpitType),
+		slog.Int("exp", spit.Exp),
+		slog.Int("content_length", len(spit.Content)),
+		slog.Bool("protected", spit.IsProtected()),
+		slog.String("encryption", spit.Encryption),
+		slog.Int("max_views", spit.MaxViews),
+	)
+}
+
+// _SaveBackoff returns the full jitter exponential backoff before the given retry.
+func _SaveBackoff(retry int) time.Duration {
+	backoff := _SAVE_BACKOFF_BASE << uint(retry-1)
+	if backoff > _SAVE_BACKOFF_MAX {
+		backoff = _SAVE_BACKOFF_MAX
 	}
-	spit.Id = _BuildSpitKey(id)
-	if err = storager.Put(spit); err != nil {
-		log.Println(err)
+	return time.Duration(rand.Int63n(int64(backoff)))
+}
+
+// Save assigns a new id to the Spit and stores it.
+// Transient storage errors are retried with backoff and a taken id is replaced by a new one.
+// Spits with a slug also claim it, returning ErrSlugTaken if another Spit holds it.
+// If saving fails the error is returned and the Spit is left without an id.
+func (spit *Spit) Save(ctx context.Context) error {
+	var err error
+	for attempt := 1; attempt <= _SAVE_MAX_ATTEMPTS; attempt++ {
+		if attempt > 1 && _IsTransient(err) {
+			select {
+			case <-ctx.Done():
+				spit.Id = ""
+				return ctx.Err()
+			case <-time.After(_SaveBackoff(attempt - 1)):
+			}
+		}
+
+		var id string
+		if id, err = storager.NextId(ctx); err != nil {
+			slog.WarnContext(ctx, "could not build the next id", "attempt", attempt, "err", err)
+			if _IsTransient(err) {
+				continue
+			}
+			break
+		}
+		spit.Id = id
+		if err = storager.Put(ctx, spit); err == nil {
+			if len(spit.Slug) == 0 {
+				return nil
+			}
+			if err = spit.claimSlug(ctx); err == nil {
+				return nil
+			}
+			break
+		}
+		slog.WarnContext(ctx, "could not store spit", "attempt", attempt, "spit", spit, "err", err)
+		if err != ErrSpitIdTaken && !_IsTransient(err) {
+			break
+		}
 	}
-	spit.Id = _BuildSpitIdFromKey(spit.Id)
-	return nil
+	spit.Id = ""
+	return err
 }
 
 /////////////////////////////////////////////////////
@@ -95,12 +219,49 @@ func nextSpitId(db *lpdb.CDB) (uint64, error) {
 	return raw_id, nil
 }
 */
+// EnableTTL turns on the native expiration of the storage if it supports it.
+func EnableTTL(ctx context.Context) error {
+	ttlStorager, ok := storager.(interface {
+		EnableTTL(ctx context.Context) error
+	})
+	if !ok {
+		return errors.New("Storage does not support native TTL")
+	}
+	return ttlStorager.EnableTTL(ctx)
+}
+
+// MigrateKeys rewrites the Spits stored with the legacy prefixed keys, if the storage has any,
+// and returns how many were migrated.
+func MigrateKeys(ctx context.Context) (int, error) {
+	migrator, ok := storager.(interface {
+		MigrateLegacyKeys(ctx context.Context) (int, error)
+	})
+	if !ok {
+		return 0, nil
+	}
+	return migrator.MigrateLegacyKeys(ctx)
+}
+
+// Migrate creates or updates the storage schema to the latest version if the storage supports it.
+func Migrate(ctx context.Context) error {
+	migrator, ok := storager.(interface {
+		Migrate(ctx context.Context) error
+	})
+	if !ok {
+		return errors.New("Storage does not support migrations")
+	}
+	return migrator.Migrate(ctx)
+}
+
 func ValidateSpitId(id string) bool {
 	return ids.ValidateId(id)
 }
 
 func AbsoluteUrl(spit *Spit) string {
-	return utils.AbsoluteSpitoURL(spit.IdHashOnly())
+	if len(spit.Slug) > 0 {
+		return utils.AbsoluteSpitoURL(spit.Slug)
+	}
+	return utils.AbsoluteSpitoURL(spit.Id)
 }
 
 func IsUrl(spit *Spit) bool {
@@ -115,20 +276,49 @@ func (e *SpitError) Error() string {
 	return fmt.Sprintf("SpitError: %v", e.ErrorsMap)
 }
 
-func Load(id string) (*Spit, error) {
-	return storager.GetWithAnalytics(_BuildSpitKey(id))
+func Load(ctx context.Context, id string) (*Spit, error) {
+	return storager.GetWithAnalytics(ctx, id)
+}
+
+// Peek fetches the Spit if the password matches the one of the Spit
+// without counting a click or consuming one of its views.
+// Disabled Spits return their DisabledError.
+func Peek(ctx context.Context, id string, password string) (*Spit, error) {
+	s, err := storager.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.DisabledError(); err != nil {
+		return nil, err
+	}
+	if err = VerifyPassword(s, password); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// LoadWithPassword loads the Spit only if the password matches the one of the Spit.
+// The clicks are not counted for failed attempts.
+func LoadWithPassword(ctx context.Context, id string, password string) (*Spit, error) {
+	if _, err := Peek(ctx, id, password); err != nil {
+		return nil, err
+	}
+	return Load(ctx, id)
 }
 
 func _NewSpit(content string, exp int, spit_type string) (*Spit, error) {
 	// use UTC time everywhere
 	timeNow := time.Now().UTC()
 	// Parse the expiration - Assume that now it is a number of seconds
-	expirationDate := timeNow.Add(time.Duration(exp) * time.Second)
+	dateExpiration := ""
+	if exp != SPIT_EXP_NEVER {
+		dateExpiration = timeNow.Add(time.Duration(exp) * time.Second).Format(time.RFC3339)
+	}
 
 	spit := &Spit{
 		SpitType:       spit_type,
 		Exp:            exp,
-		DateExpiration: expirationDate.Format(time.RFC3339),
+		DateExpiration: dateExpiration,
 		DateCreated:    timeNow.Format(time.RFC3339),
 		Content:        content,
 		MetricClicks:   0,
@@ -145,6 +335,118 @@ func NewTextSpit(text string, exp int) (*Spit, error) {
 	return _NewSpit(text, exp, SPIT_TYPE_TEXT)
 }
 
+var _ErrExpTooFar = errors.New("Expiration time should be within 100 years")
+
+// _ParseExp parses the expiration either as a number of seconds or as a duration like "7d" or "1h30m".
+// It returns the expiration in seconds, SPIT_EXP_NEVER for 0.
+func _ParseExp(exp string) (int, error) {
+	if expInt, err := strconv.Atoi(exp); err == nil {
+		if expInt < 0 {
+			return 0, errors.New("Negative expiration time not allowed")
+		}
+		if expInt > SPIT_EXP_MAX {
+			return 0, _ErrExpTooFar
+		}
+		return expInt, nil
+	}
+	d, err := utils.ParseHumanDuration(exp)
+	if err != nil {
+		return 0, errors.New("Invalid expiration time posted")
+	}
+	if d < 0 {
+		return 0, errors.New("Negative expiration time not allowed")
+	}
+	if d > time.Duration(SPIT_EXP_MAX)*time.Second {
+		return 0, _ErrExpTooFar
+	}
+	return int(math.Ceil(d.Seconds())), nil
+}
+
+// _ParseExpiresAt parses an absolute RFC3339 expiration timestamp and returns
+// the expiration in seconds from now.
+func _ParseExpiresAt(expiresAt string) (int, error) {
+	t, err := time.Parse(time.RFC3339, expiresAt)
+	if err != nil {
+		return 0, errors.New("Invalid expiration timestamp posted, RFC3339 expected")
+	}
+	d := t.Sub(time.Now())
+	if d <= 0 {
+		return 0, errors.New("Expiration timestamp should be in the future")
+	}
+	if d > time.Duration(SPIT_EXP_MAX)*time.Second {
+		return 0, _ErrExpTooFar
+	}
+	return int(math.Ceil(d.Seconds())), nil
+}
+
+// _ValidateExp parses the expiration from either exp or expires_at.
+// It returns the expiration in seconds from now or the error for the Exp field.
+func _ValidateExp(exp string, expiresAt string) (int, string) {
+	var expInt int
+	var err error
+	if len(exp) > 0 && len(expiresAt) > 0 {
+		return 0, "Only one of exp and expires_at is allowed"
+	} else if len(expiresAt) > 0 {
+		expInt, err = _ParseExpiresAt(expiresAt)
+	} else if len(exp) == 0 {
+		return 0, "Cannot find expiration time"
+	} else {
+		expInt, err = _ParseExp(exp)
+	}
+	if err != nil {
+		return 0, err.Error()
+	}
+	if SpitMaxLifetime > 0 {
+		if expInt == SPIT_EXP_NEVER || time.Duration(expInt)*time.Second > SpitMaxLifetime {
+			return 0, fmt.Sprintf("Spits should expire within %v", SpitMaxLifetime)
+		}
+	}
+	return expInt, ""
+}
+
+// _ValidateContent checks the content for the type and the encryption of the Spit.
+// It returns the trimmed content or the error for the Content field.
+func _ValidateContent(ctx context.Context, content string, spitType string, encryption string) (string, string) {
+	content = strings.TrimSpace(content)
+	if len(content) == 0 {
+		return "", "Empty spit is not allowed"
+	}
+	maxContent := SPIT_MAX_CONTENT
+	if len(encryption) > 0 {
+		maxContent = SPIT_MAX_CONTENT_ENCRYPTED
+	}
+	if len(content) > maxContent {
+		return "", fmt.Sprintf("Spit content should be less than %v characters", maxContent)
+	}
+	// the server never sees the plaintext, just make sure it is the expected encoding
+	if len(encryption) > 0 {
+		if _, err := base64.RawURLEncoding.DecodeString(content); err != nil {
+			return "", "Encrypted content should be base64url encoded"
+		}
+	}
+	// make sure the URL is correct if it is a URL type
+	if spitType == SPIT_TYPE_URL {
+		// the policy is cheaper than the HEAD request and blocked URLs should not be contacted at all
+		if err := urlpolicy.Check(content); err != nil {
+			metrics.URLChecks.WithLabelValues("blocked").Inc()
+			return "", err.Error()
+		}
+		if err := _ScreenURL(ctx, content); err != nil {
+			return "", err.Error()
+		}
+		urlCtx, urlSpan := tracing.Start(ctx, "utils.IsUrl")
+		isurl := utils.IsUrl(urlCtx, content)
+		urlSpan.SetAttributes(attribute.Bool("spito.url.valid", isurl))
+		urlSpan.End()
+		if !isurl {
+			metrics.URLChecks.WithLabelValues("invalid").Inc()
+			return "", "URL specified is not valid..."
+		}
+		metrics.URLChecks.WithLabelValues("valid").Inc()
+	}
+	return content, ""
+}
+
 // NewFromRequest tries to extract data from the request and map them to a newly created Spit.
 // it reads the spit_type in order to determine what spit type will return.
 // if there is an error with the parameters then a map of the errors with
@@ -154,9 +456,21 @@ func NewTextSpit(text string, exp int) (*Spit, error) {
 //      a SpitError if something went wrong that contains a map[string]string
 //			containing any errors occured validating the parameters
 func NewFromRequest(r *http.Request) (*Spit, error) {
-	exp := r.FormValue("exp")
+	ctx, span := tracing.Start(r.Context(), "spit.NewFromRequest")
+	s, err := _NewFromRequest(r.WithContext(ctx))
+	tracing.End(span, err)
+	return s, err
+}
+
+func _NewFromRequest(r *http.Request) (*Spit, error) {
+	exp := strings.TrimSpace(r.FormValue("exp"))
+	expiresAt := strings.TrimSpace(r.FormValue("expires_at"))
 	spitType := r.FormValue("spit_type")
 	content := r.FormValue("content")
+	password := r.FormValue("password")
+	encryption := strings.TrimSpace(r.FormValue("encryption"))
+	maxViews := strings.TrimSpace(r.FormValue("max_views"))
+	burnAfterReading := strings.TrimSpace(r.FormValue("burn_after_reading"))
 
 	spitError := &SpitError{make(map[string]string)}
 
@@ -171,17 +485,49 @@ func NewFromRequest(r *http.Request) (*Spit, error) {
 	}
 
 	// validate the expiration
-	var expInt int
+	expInt, expErr := _ValidateExp(exp, expiresAt)
+	if len(expErr) > 0 {
+		spitError.ErrorsMap["Exp"] = expErr
+	}
+
+	// validate the password
+	if len(password) > SPIT_PASSWORD_MAX_LENGTH {
+		spitError.ErrorsMap["Password"] = fmt.Sprintf("Password should be up to %v characters",
+			SPIT_PASSWORD_MAX_LENGTH)
+	}
+
+	// validate the encryption marker
+	if len(encryption) > 0 {
+		if !ActiveEncryptions[encryption] {
+			spitError.ErrorsMap["Encryption"] = "Invalid encryption specified"
+		} else if spitType != SPIT_TYPE_TEXT {
+			spitError.ErrorsMap["Encryption"] = "Only text spits can be encrypted"
+		}
+	}
+
+	// validate the views limit
+	var maxViewsInt int
 	var err error
-	if len(exp) == 0 {
-		spitError.ErrorsMap["Exp"] = "Cannot find expiration time"
-	} else {
-		expInt, err = strconv.Atoi(exp)
-		if err != nil {
-			spitError.ErrorsMap["Exp"] = "Invalid expiration time posted"
+	if len(maxViews) > 0 {
+		maxViewsInt, err = strconv.Atoi(maxViews)
+		if err != nil || maxViewsInt < 0 {
+			spitError.ErrorsMap["MaxViews"] = "Invalid maximum views posted"
 		}
-		if expInt < 0 {
-			spitError.ErrorsMap["Exp"] = "Negative expiration time not allowed"
+	}
+	// burn after reading is a shortcut for a single view
+	if len(burnAfterReading) > 0 {
+		burn, err := strconv.ParseBool(burnAfterReading)
+		if burnAfterReading == "on" {
+			// posted by HTML checkboxes
+			burn, err = true, nil
+		}
+		if err != nil {
+			spitError.ErrorsMap["MaxViews"] = "Invalid burn after reading posted"
+		} else if burn {
+			if maxViewsInt > 1 {
+				spitError.ErrorsMap["MaxViews"] = "Burn after reading allows only one view"
+			}
+			maxViewsInt = 1
 		}
 	}
 
@@ -190,25 +536,28 @@ func NewFromRequest(r *http.Request) (*Spit, error) {
 		return nil, spitError
 	}
 	// create the new Spit since everything is fine
-	content = strings.TrimSpace(content)
-	if len(content) == 0 {
-		spitError.ErrorsMap["Content"] = "Empty spit is not allowed"
+	content, contentErr := _ValidateContent(r.Context(), content, spitType, encryption)
+	if len(contentErr) > 0 {
+		spitError.ErrorsMap["Content"] = contentErr
 		return nil, spitError
 	}
-	if len(content) > SPIT_MAX_CONTENT {
-		spitError.ErrorsMap["Content"] = fmt.Sprintf("Spit content should be less than %v characters",
-			SPIT_MAX_CONTENT)
-		return nil, spitError
-	}
-	// make sure the URL is correct if it is a URL type
+	var nSpit *Spit
 	if spitType == SPIT_TYPE_URL {
-		isurl := utils.IsUrl(content)
-		if !isurl {
-			spitError.ErrorsMap["Content"] = "URL specified is not valid..."
-			return nil, spitError
-		}
-		return NewUrlSpit(content, expInt)
+		nSpit, err = NewUrlSpit(content, expInt)
+	} else {
+		nSpit, err = NewTextSpit(content, expInt)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	return NewTextSpit(content, expInt)
+	nSpit.Encryption = encryption
+	nSpit.MaxViews = maxViewsInt
+	nSpit.ViewsLeft = maxViewsInt
+	if len(password) > 0 {
+		if nSpit.PasswordHash, err = _HashPassword(password); err != nil {
+			return nil, err
+		}
+	}
+	return nSpit, nil
 }
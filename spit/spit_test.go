@@ -0,0 +1,111 @@
+package spit
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseExp(t *testing.T) {
+	valid := map[string]int{
+		"0":    SPIT_EXP_NEVER,
+		"3600": 3600,
+		"1h":   3600,
+		"1d":   86400,
+		"1.5s": 2,
+	}
+	for exp, expected := range valid {
+		if expInt, err := _ParseExp(exp); err != nil || expInt != expected {
+			t.Errorf("_ParseExp(%q) = %v, %v, expected %v", exp, expInt, err, expected)
+		}
+	}
+	invalid := []string{"-1", "tomorrow", strconv.Itoa(SPIT_EXP_MAX + 1), "9223372036854775807", "1000000w", "5300w"}
+	for _, exp := range invalid {
+		if _, err := _ParseExp(exp); err == nil {
+			t.Errorf("_ParseExp(%q) should fail", exp)
+		}
+	}
+}
+
+func TestParseExpiresAt(t *testing.T) {
+	in := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	if expInt, err := _ParseExpiresAt(in); err != nil || expInt < 3590 || expInt > 3600 {
+		t.Errorf("_ParseExpiresAt(%q) = %v, %v, expected about an hour", in, expInt, err)
+	}
+	invalid := []string{
+		time.Now().Add(-time.Hour).UTC().Format(time.RFC3339),
+		"9999-12-31T23:59:59Z",
+		"tomorrow",
+	}
+	for _, expiresAt := range invalid {
+		if _, err := _ParseExpiresAt(expiresAt); err == nil {
+			t.Errorf("_ParseExpiresAt(%q) should fail", expiresAt)
+		}
+	}
+}
+
+func TestValidateExpMaxLifetime(t *testing.T) {
+	defer func(maxLifetime time.Duration) { SpitMaxLifetime = maxLifetime }(SpitMaxLifetime)
+	SpitMaxLifetime = 24 * time.Hour
+
+	if expInt, errMsg := _ValidateExp("1h", ""); len(errMsg) > 0 || expInt != 3600 {
+		t.Errorf("an hour should be within the max lifetime, got %v %q", expInt, errMsg)
+	}
+	for _, exp := range []string{"0", "2d", strconv.Itoa(SPIT_EXP_MAX)} {
+		if _, errMsg := _ValidateExp(exp, ""); len(errMsg) == 0 {
+			t.Errorf("%q should exceed the max lifetime", exp)
+		}
+	}
+	if _, errMsg := _ValidateExp("1h", time.Now().Add(time.Hour).Format(time.RFC3339)); len(errMsg) == 0 {
+		t.Error("exp and expires_at together should be rejected")
+	}
+}
+
+func TestSaveRetriesTransientErrors(t *testing.T) {
+	f := withFakeStorager(t)
+	f.failNext("Put", &TransientError{errors.New("throttled")}, ErrSpitIdTaken)
+	s := &Spit{SpitType: SPIT_TYPE_TEXT, Content: "hello"}
+	if err := s.Save(t.Context()); err != nil {
+		t.Fatalf("Save should succeed after the retries: %v", err)
+	}
+	if f.callCount("Put") != 3 || f.callCount("NextId") != 3 {
+		t.Errorf("expected 3 attempts with a new id each, got %v puts and %v ids", f.callCount("Put"), f.callCount("NextId"))
+	}
+	if _, err := storager.Get(t.Context(), s.Id); err != nil {
+		t.Errorf("the spit should be stored under its final id %q: %v", s.Id, err)
+	}
+}
+
+func TestSaveGivesUp(t *testing.T) {
+	f := withFakeStorager(t)
+	permanent := errors.New("validation failed")
+	f.failNext("Put", permanent)
+	s := &Spit{SpitType: SPIT_TYPE_TEXT, Content: "hello"}
+	if err := s.Save(t.Context()); err != permanent {
+		t.Errorf("permanent errors should not be retried, got %v", err)
+	}
+	if f.callCount("Put") != 1 || len(s.Id) > 0 {
+		t.Errorf("expected a single attempt and no id, got %v puts and id %q", f.callCount("Put"), s.Id)
+	}
+
+	f.failNext("Put", ErrSpitIdTaken, ErrSpitIdTaken, ErrSpitIdTaken, ErrSpitIdTaken)
+	if err := s.Save(t.Context()); err != ErrSpitIdTaken {
+		t.Errorf("expected ErrSpitIdTaken after all the attempts, got %v", err)
+	}
+}
+
+func TestSaveStopsOnCancel(t *testing.T) {
+	f := withFakeStorager(t)
+	f.failNext("NextId", &TransientError{errors.New("throttled")})
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	s := &Spit{SpitType: SPIT_TYPE_TEXT, Content: "hello"}
+	if err := s.Save(ctx); err != context.Canceled {
+		t.Errorf("expected the cancellation instead of the backoff, got %v", err)
+	}
+	if f.callCount("NextId") != 1 {
+		t.Errorf("expected no retry after the cancellation, got %v attempts", f.callCount("NextId"))
+	}
+}
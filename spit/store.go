@@ -1,22 +1,156 @@
 package spit
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lambrospetrou/spito/ids"
+	"github.com/lambrospetrou/spito/utils"
+
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 )
 
+// ErrSpitIdTaken is returned by Put when a Spit with the same id already exists.
+var ErrSpitIdTaken = errors.New("Spit id is already taken")
+
+// ErrLeaseLost is returned by SaveLeaseCursor when another owner took the lease over.
+var ErrLeaseLost = errors.New("Lease is held by another owner")
+
+// Lease elects the instance doing a background job, the cursor of the job
+// moves with the lease so the next holder continues where the previous one stopped.
+type Lease struct {
+	Name   string
+	Owner  string
+	Cursor string
+}
+
+// TransientError wraps storage errors that are worth retrying, like throttling or timeouts.
+type TransientError struct {
+	Err error
+}
+
+func (e *TransientError) Error() string {
+	return fmt.Sprintf("TransientError: %v", e.Err)
+}
+
+func (e *TransientError) Unwrap() error {
+	return e.Err
+}
+
+func _IsTransient(err error) bool {
+	var transientErr *TransientError
+	return errors.As(err, &transientErr)
+}
+
 type Storager interface {
-	Put(s *Spit) error
-	Get(key string) (*Spit, error)
-	GetWithAnalytics(key string) (*Spit, error)
-	NextId() (string, error)
+	// Put stores a new Spit and returns ErrSpitIdTaken if its id is already used.
+	Put(ctx context.Context, s *Spit) error
+	Get(ctx context.Context, id string) (*Spit, error)
+	GetWithAnalytics(ctx context.Context, id string) (*Spit, error)
+	NextId(ctx context.Context) (string, error)
+	// Update changes the content and the expiration of an existing Spit.
+	Update(ctx context.Context, s *Spit) error
+	Delete(ctx context.Context, id string) error
+	// ListByOwner returns up to limit Spits of the owner newest first, starting after
+	// the given position, and whether there might be more.
+	ListByOwner(ctx context.Context, ownerId string, spitType string, after *ListPosition, limit int) ([]*Spit, bool, error)
+	// ListByWorkspace is like ListByOwner for the Spits of the workspace.
+	ListByWorkspace(ctx context.Context, workspaceId string, spitType string, after *ListPosition, limit int) ([]*Spit, bool, error)
+
+	// PutWorkspace stores a new workspace and returns ErrWorkspaceIdTaken if its id is already used.
+	PutWorkspace(ctx context.Context, ws *Workspace) error
+	GetWorkspace(ctx context.Context, id string) (*Workspace, error)
+	// SetWorkspaceMember sets the role of the member, an empty role removes the member,
+	// only if the workspace is still at the given version, otherwise it returns ErrWorkspaceChanged.
+	SetWorkspaceMember(ctx context.Context, workspaceId string, version int, memberId string, role string) error
+
+	// PutSlug points the slug to the Spit, only if the slug is free or still points to replaces,
+	// otherwise it returns ErrSlugTaken.
+	PutSlug(ctx context.Context, slug string, spitId string, replaces string) error
+	GetSlug(ctx context.Context, slug string) (string, error)
+	// DeleteSlug deletes the slug if it still points to the Spit.
+	DeleteSlug(ctx context.Context, slug string, spitId string) error
+
+	// PutAPIKey stores a new API key, GetAPIKey returns it even if it is revoked.
+	PutAPIKey(ctx context.Context, k *APIKey) error
+	GetAPIKey(ctx context.Context, id string) (*APIKey, error)
+	RevokeAPIKey(ctx context.Context, id string) error
+
+	// SetDisabled sets the reason the Spit is disabled for, an empty reason enables it again
+	// and marks it as reviewed.
+	SetDisabled(ctx context.Context, id string, reason string) error
+	// ScanURLs calls fn with the live URL Spits, scanning from the cursor, until it was called
+	// limit times or fn returns an error, and returns the cursor to continue from, empty at the end.
+	ScanURLs(ctx context.Context, cursor string, limit int, fn func(s *Spit) error) (string, error)
+
+	// PutAudit appends the entry to the audit log, ListAudit returns the entries of the day newest first.
+	PutAudit(ctx context.Context, e *AuditEntry) error
+	ListAudit(ctx context.Context, day string, limit int) ([]*AuditEntry, error)
+
+	// DeleteExpired deletes up to limit Spits that expired before now, scanning from the cursor,
+	// and returns how many were deleted and the cursor to continue from, empty at the end.
+	DeleteExpired(ctx context.Context, now time.Time, cursor string, limit int) (int, string, error)
+	// AcquireLease acquires or renews the named lease for owner if it is free,
+	// expired or already held by owner, it returns nil if another owner holds it.
+	AcquireLease(ctx context.Context, name string, owner string, ttl time.Duration) (*Lease, error)
+	// SaveLeaseCursor stores where the work of the lease continues from,
+	// or returns ErrLeaseLost if another owner took it over.
+	SaveLeaseCursor(ctx context.Context, lease *Lease, cursor string) error
+
+	// Ping checks cheaply that the storage is reachable.
+	Ping(ctx context.Context) error
 }
 
-func NewDynamoStorager() Storager {
+// Ping checks that the storage is initialized and reachable.
+func Ping(ctx context.Context) error {
+	if storager == nil {
+		return errors.New("storage is not initialized")
+	}
+	return storager.Ping(ctx)
+}
+
+// IdsLoaded returns true once the id alphabets are loaded from the storage.
+func IdsLoaded() bool {
+	return ids.Loaded()
+}
+
+// CheckConfig validates the storage configuration.
+func CheckConfig() error {
+	if len(_TABLE_NAME_SPITS_DATA) == 0 || len(_TABLE_NAME_SPITS_META) == 0 {
+		return errors.New("the table names cannot be empty")
+	}
+	if _STORAGE_OP_TIMEOUT <= 0 {
+		return errors.New("SPITO_STORAGE_TIMEOUT has to be positive")
+	}
+	if _storageOpTimeoutsErr != nil {
+		return _storageOpTimeoutsErr
+	}
+	if SpitMaxLifetime < 0 {
+		return errors.New("SPITO_MAX_LIFETIME cannot be negative")
+	}
+	return nil
+}
+
+// _NewDynamoClient() connects to DynamoDB, or to DynamoDB Local if SPITO_DYNAMODB_ENDPOINT is set.
+func _NewDynamoClient() *awsDynamoDBStorager {
+	// Save retries the transient errors itself, so keep the retries of the SDK short
+	config := aws.NewConfig().WithRegion(utils.EnvString("SPITO_DYNAMODB_REGION", "eu-west-1")).
+		WithMaxRetries(utils.EnvInt("SPITO_DYNAMODB_MAX_RETRIES", 2))
+	if endpoint := utils.EnvString("SPITO_DYNAMODB_ENDPOINT", ""); len(endpoint) > 0 {
+		config = config.WithEndpoint(endpoint)
+	}
 	session := session.New()
-	svc := dynamodb.New(session, aws.NewConfig().WithRegion("eu-west-1"))
-	dynamoDBStorager := &awsDynamoDBStorager{session, svc}
+	svc := dynamodb.New(session, config)
+	_TraceDynamoRequests(svc)
+	return &awsDynamoDBStorager{session: session, svc: svc}
+}
+
+func NewDynamoStorager() Storager {
+	dynamoDBStorager := _NewDynamoClient()
 	dynamoDBStorager.init()
 	return dynamoDBStorager
 }
@@ -24,3 +158,9 @@ func NewDynamoStorager() Storager {
 func NewDefaultStorager() Storager {
 	return NewDynamoStorager()
 }
+
+// NewDefaultAdminStorager returns the default storage without preparing it for serving Spits,
+// so that it can be used by the admin commands even before its tables exist.
+func NewDefaultAdminStorager() Storager {
+	return _NewDynamoClient()
+}
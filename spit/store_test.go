@@ -0,0 +1,144 @@
+package spit
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// _FakeStorager keeps everything in memory for the tests of the logic above the storage.
+// The methods of every feature live next to the tests of the feature.
+type _FakeStorager struct {
+	sync.Mutex
+	spits      map[string]*Spit
+	workspaces map[string]*Workspace
+	slugs      map[string]string
+	apiKeys    map[string]*APIKey
+	audit      []*AuditEntry
+	leases     map[string]*Lease
+	lastId     int
+	// the errors returned by the next calls of the operation, e.g. "Put"
+	errs  map[string][]error
+	calls map[string]int
+}
+
+var _ Storager = (*_FakeStorager)(nil)
+
+// withFakeStorager() replaces the storage of the package for the test.
+func withFakeStorager(t *testing.T) *_FakeStorager {
+	f := &_FakeStorager{
+		spits:      make(map[string]*Spit),
+		workspaces: make(map[string]*Workspace),
+		slugs:      make(map[string]string),
+		apiKeys:    make(map[string]*APIKey),
+		leases:     make(map[string]*Lease),
+		errs:       make(map[string][]error),
+		calls:      make(map[string]int),
+	}
+	previous := storager
+	storager = f
+	t.Cleanup(func() { storager = previous })
+	return f
+}
+
+// failNext() makes the next calls of the operation fail with the errors, in order
+func (f *_FakeStorager) failNext(op string, errs ...error) {
+	f.Lock()
+	defer f.Unlock()
+	f.errs[op] = append(f.errs[op], errs...)
+}
+
+// call() counts the call of the operation and returns its injected error, the caller holds the lock
+func (f *_FakeStorager) call(op string) error {
+	f.calls[op]++
+	if errs := f.errs[op]; len(errs) > 0 {
+		f.errs[op] = errs[1:]
+		return errs[0]
+	}
+	return nil
+}
+
+func (f *_FakeStorager) callCount(op string) int {
+	f.Lock()
+	defer f.Unlock()
+	return f.calls[op]
+}
+
+func _NotFound(key string) error {
+	return DynamoDbItemNotFoundError{key}
+}
+
+func (f *_FakeStorager) Put(ctx context.Context, s *Spit) error {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.call("Put"); err != nil {
+		return err
+	}
+	if _, exists := f.spits[s.Id]; exists {
+		return ErrSpitIdTaken
+	}
+	stored := *s
+	f.spits[s.Id] = &stored
+	return nil
+}
+
+func (f *_FakeStorager) Get(ctx context.Context, id string) (*Spit, error) {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.call("Get"); err != nil {
+		return nil, err
+	}
+	s, ok := f.spits[id]
+	if !ok || _IsExpiredAt(s, time.Now().UTC()) {
+		return nil, _NotFound(id)
+	}
+	found := *s
+	return &found, nil
+}
+
+func (f *_FakeStorager) GetWithAnalytics(ctx context.Context, id string) (*Spit, error) {
+	return f.Get(ctx, id)
+}
+
+func (f *_FakeStorager) NextId(ctx context.Context) (string, error) {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.call("NextId"); err != nil {
+		return "", err
+	}
+	f.lastId++
+	return "id" + strconv.Itoa(f.lastId), nil
+}
+
+func (f *_FakeStorager) Delete(ctx context.Context, id string) error {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.call("Delete"); err != nil {
+		return err
+	}
+	delete(f.spits, id)
+	return nil
+}
+
+func (f *_FakeStorager) Ping(ctx context.Context) error {
+	f.Lock()
+	defer f.Unlock()
+	return f.call("Ping")
+}
+
+func TestParseOpTimeouts(t *testing.T) {
+	timeouts, err := _ParseOpTimeouts(" Get=1s, ScanURLs=1m ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if timeouts["Get"] != time.Second || timeouts["ScanURLs"] != time.Minute || timeouts["DeleteExpired"] != 30*time.Second {
+		t.Errorf("unexpected timeouts %v", timeouts)
+	}
+	for _, s := range []string{"Get", "Get=soon", "Get=-1s", "Get=0s"} {
+		if _, err := _ParseOpTimeouts(s); err == nil {
+			t.Errorf("%q should be rejected", s)
+		}
+	}
+}
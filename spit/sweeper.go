@@ -0,0 +1,110 @@
+package spit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lambrospetrou/spito/tracing"
+)
+
+const (
+	_SWEEPER_LEASE_NAME string = "sweeper"
+)
+
+// SweeperStats are the counters of the expiration sweeper since the process started.
+type SweeperStats struct {
+	Runs      uint64
+	Skipped   uint64
+	Reclaimed uint64
+	Errors    uint64
+}
+
+var _sweeperStats SweeperStats
+
+// GetSweeperStats returns a snapshot of the expiration sweeper counters.
+func GetSweeperStats() SweeperStats {
+	return SweeperStats{
+		Runs:      atomic.LoadUint64(&_sweeperStats.Runs),
+		Skipped:   atomic.LoadUint64(&_sweeperStats.Skipped),
+		Reclaimed: atomic.LoadUint64(&_sweeperStats.Reclaimed),
+		Errors:    atomic.LoadUint64(&_sweeperStats.Errors),
+	}
+}
+
+func _SweeperOwner() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%v-%v-%v", hostname, os.Getpid(), rand.Int63())
+}
+
+// StartSweeper starts a background goroutine that every interval deletes up to batchSize
+// expired Spits, scanning the table until it finds them or reaches its end.
+// Only the instance holding the sweeper lease does the work, and
+// the deletes are conditional on the Spit being expired so overlapping runs are harmless.
+// It returns a function that stops the sweeper, cancelling the current run, and waits for it.
+func StartSweeper(interval time.Duration, batchSize int) func() {
+	owner := _SweeperOwner()
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				_Sweep(ctx, owner, interval, batchSize)
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+func _Sweep(ctx context.Context, owner string, interval time.Duration, batchSize int) {
+	atomic.AddUint64(&_sweeperStats.Runs, 1)
+
+	// a run must never overlap with the next one
+	ctx, cancel := context.WithTimeout(ctx, interval)
+	defer cancel()
+	ctx, span := tracing.Start(ctx, "sweeper.Sweep")
+	defer span.End()
+
+	// the lease outlives a run so that another instance takes over only if we stop renewing it
+	lease, err := storager.AcquireLease(ctx, _SWEEPER_LEASE_NAME, owner, 2*interval)
+	if err != nil {
+		atomic.AddUint64(&_sweeperStats.Errors, 1)
+		slog.ErrorContext(ctx, "sweeper could not acquire the lease", "err", err)
+		return
+	}
+	if lease == nil {
+		atomic.AddUint64(&_sweeperStats.Skipped, 1)
+		return
+	}
+
+	// the scan continues from the cursor of the lease, whoever held it last
+	n, next, err := storager.DeleteExpired(ctx, time.Now().UTC(), lease.Cursor, batchSize)
+	atomic.AddUint64(&_sweeperStats.Reclaimed, uint64(n))
+	if err != nil {
+		atomic.AddUint64(&_sweeperStats.Errors, 1)
+		slog.ErrorContext(ctx, "sweeper could not delete the expired spits", "err", err)
+	}
+	if errSave := storager.SaveLeaseCursor(context.WithoutCancel(ctx), lease, next); errSave != nil {
+		slog.WarnContext(ctx, "sweeper could not save its cursor", "err", errSave)
+	}
+	if n > 0 {
+		slog.InfoContext(ctx, "sweeper reclaimed expired spits", "count", n)
+	}
+}
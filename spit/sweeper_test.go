@@ -0,0 +1,114 @@
+package spit
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+)
+
+func (f *_FakeStorager) DeleteExpired(ctx context.Context, now time.Time, cursor string, limit int) (int, string, error) {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.call("DeleteExpired"); err != nil {
+		return 0, cursor, err
+	}
+	ids := make([]string, 0, len(f.spits))
+	for id := range f.spits {
+		if id > cursor {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	n := 0
+	for _, id := range ids {
+		if _IsExpiredAt(f.spits[id], now) {
+			delete(f.spits, id)
+			n++
+		}
+		if n >= limit {
+			return n, id, nil
+		}
+	}
+	return n, "", nil
+}
+
+func (f *_FakeStorager) AcquireLease(ctx context.Context, name string, owner string, ttl time.Duration) (*Lease, error) {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.call("AcquireLease"); err != nil {
+		return nil, err
+	}
+	// the leases without an owner stand for the expired ones
+	if lease, held := f.leases[name]; held && len(lease.Owner) > 0 && lease.Owner != owner {
+		return nil, nil
+	}
+	if _, held := f.leases[name]; !held {
+		f.leases[name] = &Lease{Name: name}
+	}
+	f.leases[name].Owner = owner
+	lease := *f.leases[name]
+	return &lease, nil
+}
+
+func (f *_FakeStorager) SaveLeaseCursor(ctx context.Context, lease *Lease, cursor string) error {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.call("SaveLeaseCursor"); err != nil {
+		return err
+	}
+	if held, ok := f.leases[lease.Name]; !ok || held.Owner != lease.Owner {
+		return ErrLeaseLost
+	}
+	f.leases[lease.Name].Cursor = cursor
+	lease.Cursor = cursor
+	return nil
+}
+
+func TestSweepContinuesFromTheLeaseCursor(t *testing.T) {
+	f := withFakeStorager(t)
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	for _, s := range []*Spit{
+		{Id: "a", Exp: 60, DateExpiration: past},
+		{Id: "b", Exp: 60, DateExpiration: future},
+		{Id: "c", Exp: 60, DateExpiration: past},
+		{Id: "d", Exp: SPIT_EXP_NEVER},
+	} {
+		f.spits[s.Id] = s
+	}
+
+	_Sweep(t.Context(), "first", time.Minute, 1)
+	if _, ok := f.spits["a"]; ok || f.leases[_SWEEPER_LEASE_NAME].Cursor != "a" {
+		t.Fatalf("the first run should delete a and stop there, cursor %q", f.leases[_SWEEPER_LEASE_NAME].Cursor)
+	}
+
+	// another instance takes over the lease once it expires and continues after a
+	f.leases[_SWEEPER_LEASE_NAME].Owner = ""
+	_Sweep(t.Context(), "second", time.Minute, 1)
+	if _, ok := f.spits["c"]; ok || f.leases[_SWEEPER_LEASE_NAME].Cursor != "c" {
+		t.Fatalf("the second run should continue to c, cursor %q", f.leases[_SWEEPER_LEASE_NAME].Cursor)
+	}
+	if _, ok := f.spits["b"]; !ok {
+		t.Error("live spits should be kept")
+	}
+
+	_Sweep(t.Context(), "second", time.Minute, 1)
+	if f.leases[_SWEEPER_LEASE_NAME].Cursor != "" {
+		t.Errorf("the scan should start over at the end of the table, cursor %q", f.leases[_SWEEPER_LEASE_NAME].Cursor)
+	}
+	if len(f.spits) != 2 {
+		t.Errorf("expected the 2 live spits left, got %v", len(f.spits))
+	}
+}
+
+func TestSweepSkipsWithoutTheLease(t *testing.T) {
+	f := withFakeStorager(t)
+	f.spits["a"] = &Spit{Id: "a", Exp: 60, DateExpiration: time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)}
+	f.leases[_SWEEPER_LEASE_NAME] = &Lease{Name: _SWEEPER_LEASE_NAME, Owner: "other"}
+	skipped := GetSweeperStats().Skipped
+	_Sweep(t.Context(), "me", time.Minute, 10)
+	if _, ok := f.spits["a"]; !ok || GetSweeperStats().Skipped != skipped+1 {
+		t.Error("only the holder of the lease should sweep")
+	}
+}
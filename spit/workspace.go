@@ -0,0 +1,235 @@
+package spit
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	ROLE_ADMIN  string = "admin"
+	ROLE_EDITOR string = "editor"
+	ROLE_VIEWER string = "viewer"
+
+	WORKSPACE_NAME_MAX_LENGTH int = 100
+)
+
+// every role can do everything the roles below it can
+var _RoleRanks = map[string]int{ROLE_VIEWER: 1, ROLE_EDITOR: 2, ROLE_ADMIN: 3}
+
+var (
+	ErrInvalidWorkspaceId = errors.New("Workspace ids are 3 to 32 lowercase letters, digits or dashes")
+	ErrWorkspaceIdTaken   = errors.New("Workspace id is already taken")
+	ErrInvalidRole        = errors.New("Invalid role, use admin, editor or viewer")
+	// ErrNotMember is returned to owners outside the workspace, they cannot tell whether it exists
+	ErrNotMember = errors.New("Not a member of the workspace")
+	// ErrRoleForbidden is returned to members whose role does not allow the operation
+	ErrRoleForbidden = errors.New("Your role in the workspace does not allow this")
+	ErrLastAdmin     = errors.New("A workspace needs at least one admin")
+	// ErrWorkspaceChanged is returned if the members changed since the workspace was loaded
+	ErrWorkspaceChanged = errors.New("Workspace was changed at the same time, please try again")
+
+	ErrInvalidSlug = errors.New("Slugs are up to 64 letters, digits, dots, dashes or underscores")
+	ErrSlugTaken   = errors.New("Slug is already taken in the workspace")
+)
+
+var (
+	_workspaceIdRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,30}[a-z0-9]$`)
+	_slugRegexp        = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+	// the workspace ids prefix the links at the root, so they cannot shadow our own paths
+	_ReservedWorkspaceIds = map[string]bool{
+		"api": true, "auth": true, "admin": true, "healthz": true, "readyz": true, "metrics": true,
+	}
+)
+
+// Workspace is shared by a team, its members see and edit its Spits according to their role.
+type Workspace struct {
+	// Id is also the prefix of the links of the workspace, e.g. spi.to/acme/q3-report
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	DateCreated string `json:"date_created"`
+	// Members maps the owner ids of the members to their role
+	Members map[string]string `json:"members"`
+	// Version counts the changes of the members, they are only changed
+	// if the workspace is still at the version they were checked against
+	Version int `json:"version"`
+}
+
+func ValidateWorkspaceId(id string) bool {
+	return _workspaceIdRegexp.MatchString(id) && !_ReservedWorkspaceIds[id]
+}
+
+func ValidateSlug(slug string) bool {
+	return _slugRegexp.MatchString(slug)
+}
+
+func ValidateRole(role string) bool {
+	return _RoleRanks[role] > 0
+}
+
+// WorkspaceSlug is the path of a link within the workspace, e.g. acme/q3-report
+func WorkspaceSlug(workspaceId string, slug string) string {
+	return workspaceId + "/" + slug
+}
+
+// Can checks whether the owner has at least the given role in the workspace.
+func (ws *Workspace) Can(ownerId string, role string) bool {
+	return len(ownerId) > 0 && ValidateRole(role) && _RoleRanks[ws.Members[ownerId]] >= _RoleRanks[role]
+}
+
+func (ws *Workspace) admins() int {
+	n := 0
+	for _, role := range ws.Members {
+		if role == ROLE_ADMIN {
+			n++
+		}
+	}
+	return n
+}
+
+// NewWorkspace creates the workspace with its creator as the only admin.
+func NewWorkspace(ctx context.Context, id string, name string, adminId string) (*Workspace, error) {
+	if !ValidateWorkspaceId(id) {
+		return nil, ErrInvalidWorkspaceId
+	}
+	if len(adminId) == 0 {
+		return nil, errors.New("the admin of the workspace cannot be empty")
+	}
+	name = strings.TrimSpace(name)
+	if len(name) == 0 {
+		name = id
+	} else if len(name) > WORKSPACE_NAME_MAX_LENGTH {
+		name = name[:WORKSPACE_NAME_MAX_LENGTH]
+	}
+	ws := &Workspace{
+		Id:          id,
+		Name:        name,
+		DateCreated: time.Now().UTC().Format(time.RFC3339),
+		Members:     map[string]string{adminId: ROLE_ADMIN},
+	}
+	if err := storager.PutWorkspace(ctx, ws); err != nil {
+		return nil, err
+	}
+	return ws, nil
+}
+
+// LoadWorkspace fetches the workspace for one of its members with at least the given role.
+// Return
+//
+//	ErrNotMember if the workspace does not exist or the owner is not a member
+//	ErrRoleForbidden if the role of the member is not enough
+func LoadWorkspace(ctx context.Context, id string, ownerId string, role string) (*Workspace, error) {
+	if !ValidateWorkspaceId(id) {
+		return nil, ErrNotMember
+	}
+	ws, err := storager.GetWorkspace(ctx, id)
+	if err != nil {
+		if _, notFound := err.(DynamoDbItemNotFoundError); notFound {
+			return nil, ErrNotMember
+		}
+		return nil, err
+	}
+	if !ws.Can(ownerId, ROLE_VIEWER) {
+		return nil, ErrNotMember
+	}
+	if !ws.Can(ownerId, role) {
+		return nil, ErrRoleForbidden
+	}
+	return ws, nil
+}
+
+// SetMemberRole adds the member to the workspace, changes its role,
+// or removes it from the workspace if the role is empty.
+// Return
+//
+//	ErrLastAdmin if the last admin would be demoted or removed
+//	ErrWorkspaceChanged if the members changed since ws was loaded
+func SetMemberRole(ctx context.Context, ws *Workspace, memberId string, role string) error {
+	if len(memberId) == 0 {
+		return errors.New("the member cannot be empty")
+	}
+	if len(role) > 0 && !ValidateRole(role) {
+		return ErrInvalidRole
+	}
+	if ws.Members[memberId] == ROLE_ADMIN && role != ROLE_ADMIN && ws.admins() <= 1 {
+		return ErrLastAdmin
+	}
+	if err := storager.SetWorkspaceMember(ctx, ws.Id, ws.Version, memberId, role); err != nil {
+		return err
+	}
+	ws.Version++
+	if len(role) == 0 {
+		delete(ws.Members, memberId)
+	} else {
+		ws.Members[memberId] = role
+	}
+	return nil
+}
+
+// ListByWorkspace returns a page of the Spits of the workspace like ListByOwner.
+func ListByWorkspace(ctx context.Context, workspaceId string, spitType string, cursor string, limit int) ([]*Spit, string, error) {
+	return _List(ctx, cursor, limit, func(after *ListPosition, limit int) ([]*Spit, bool, error) {
+		return storager.ListByWorkspace(ctx, workspaceId, spitType, after, limit)
+	})
+}
+
+// CanAccess checks that the owner may access the Spit with the given role.
+// Personal Spits are only accessible by their owner, whatever the role,
+// while the Spits of a workspace are accessible by its members with that role.
+func CanAccess(ctx context.Context, s *Spit, ownerId string, role string) error {
+	if len(s.WorkspaceId) == 0 {
+		if !s.IsOwnedBy(ownerId) {
+			return ErrNotOwner
+		}
+		return nil
+	}
+	if _, err := LoadWorkspace(ctx, s.WorkspaceId, ownerId, role); err != nil {
+		if err == ErrNotMember {
+			return ErrNotOwner
+		}
+		return err
+	}
+	return nil
+}
+
+// ResolveSlug returns the id of the Spit the link of the workspace points to.
+func ResolveSlug(ctx context.Context, workspaceId string, slug string) (string, error) {
+	if !ValidateWorkspaceId(workspaceId) || !ValidateSlug(slug) {
+		return "", DynamoDbItemNotFoundError{WorkspaceSlug(workspaceId, slug)}
+	}
+	return storager.GetSlug(ctx, WorkspaceSlug(workspaceId, slug))
+}
+
+// claimSlug() points the slug of the just stored Spit to it, or deletes the Spit again
+// if the slug cannot be claimed. The slugs of expired or deleted Spits are free to claim again.
+func (spit *Spit) claimSlug(ctx context.Context) error {
+	err := spit.putSlug(ctx)
+	if err == nil {
+		return nil
+	}
+	if errDelete := storager.Delete(ctx, spit.Id); errDelete != nil {
+		slog.ErrorContext(ctx, "could not delete the spit without its slug", "spit", spit, "err", errDelete)
+	}
+	return err
+}
+
+func (spit *Spit) putSlug(ctx context.Context) error {
+	err := storager.PutSlug(ctx, spit.Slug, spit.Id, "")
+	if err != ErrSlugTaken {
+		return err
+	}
+	current, err := storager.GetSlug(ctx, spit.Slug)
+	if err != nil {
+		return err
+	}
+	if _, err := storager.Get(ctx, current); err == nil {
+		return ErrSlugTaken
+	} else if _, notFound := err.(DynamoDbItemNotFoundError); !notFound {
+		return err
+	}
+	return storager.PutSlug(ctx, spit.Slug, spit.Id, current)
+}
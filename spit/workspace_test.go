@@ -0,0 +1,288 @@
+package spit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func (f *_FakeStorager) ListByWorkspace(ctx context.Context, workspaceId string, spitType string, after *ListPosition, limit int) ([]*Spit, bool, error) {
+	return f.list(func(s *Spit) bool { return s.WorkspaceId == workspaceId }, spitType, after, limit)
+}
+
+func (f *_FakeStorager) PutWorkspace(ctx context.Context, ws *Workspace) error {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.call("PutWorkspace"); err != nil {
+		return err
+	}
+	if _, exists := f.workspaces[ws.Id]; exists {
+		return ErrWorkspaceIdTaken
+	}
+	f.workspaces[ws.Id] = _CopyWorkspace(ws)
+	return nil
+}
+
+func _CopyWorkspace(ws *Workspace) *Workspace {
+	c := *ws
+	c.Members = make(map[string]string, len(ws.Members))
+	for member, role := range ws.Members {
+		c.Members[member] = role
+	}
+	return &c
+}
+
+func (f *_FakeStorager) GetWorkspace(ctx context.Context, id string) (*Workspace, error) {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.call("GetWorkspace"); err != nil {
+		return nil, err
+	}
+	ws, ok := f.workspaces[id]
+	if !ok {
+		return nil, _NotFound(id)
+	}
+	return _CopyWorkspace(ws), nil
+}
+
+func (f *_FakeStorager) SetWorkspaceMember(ctx context.Context, workspaceId string, version int, memberId string, role string) error {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.call("SetWorkspaceMember"); err != nil {
+		return err
+	}
+	ws, ok := f.workspaces[workspaceId]
+	if !ok || ws.Version != version {
+		return ErrWorkspaceChanged
+	}
+	if len(role) == 0 {
+		delete(ws.Members, memberId)
+	} else {
+		ws.Members[memberId] = role
+	}
+	ws.Version++
+	return nil
+}
+
+func (f *_FakeStorager) PutSlug(ctx context.Context, slug string, spitId string, replaces string) error {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.call("PutSlug"); err != nil {
+		return err
+	}
+	if current, exists := f.slugs[slug]; exists && current != replaces {
+		return ErrSlugTaken
+	} else if !exists && len(replaces) > 0 {
+		return ErrSlugTaken
+	}
+	f.slugs[slug] = spitId
+	return nil
+}
+
+func (f *_FakeStorager) GetSlug(ctx context.Context, slug string) (string, error) {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.call("GetSlug"); err != nil {
+		return "", err
+	}
+	spitId, ok := f.slugs[slug]
+	if !ok {
+		return "", _NotFound(slug)
+	}
+	return spitId, nil
+}
+
+func (f *_FakeStorager) DeleteSlug(ctx context.Context, slug string, spitId string) error {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.call("DeleteSlug"); err != nil {
+		return err
+	}
+	if f.slugs[slug] == spitId {
+		delete(f.slugs, slug)
+	}
+	return nil
+}
+
+func TestValidateWorkspaceId(t *testing.T) {
+	cases := map[string]bool{
+		"acme":     true,
+		"acme-eng": true,
+		"a1b":      true,
+		"ab":       false,
+		"Acme":     false,
+		"-acme":    false,
+		"acme-":    false,
+		"ac/me":    false,
+		"api":      false,
+		"auth":     false,
+	}
+	for id, expected := range cases {
+		if ValidateWorkspaceId(id) != expected {
+			t.Errorf("ValidateWorkspaceId(%q) should be %v", id, expected)
+		}
+	}
+}
+
+func TestValidateSlug(t *testing.T) {
+	cases := map[string]bool{
+		"q3-report":    true,
+		"Q3_report.v2": true,
+		"":             false,
+		"-q3":          false,
+		"q3/report":    false,
+		"q3 report":    false,
+	}
+	for slug, expected := range cases {
+		if ValidateSlug(slug) != expected {
+			t.Errorf("ValidateSlug(%q) should be %v", slug, expected)
+		}
+	}
+}
+
+func TestWorkspaceRoles(t *testing.T) {
+	ws := &Workspace{Id: "acme", Members: map[string]string{
+		"alice": ROLE_ADMIN, "bob": ROLE_EDITOR, "carol": ROLE_VIEWER,
+	}}
+	cases := []struct {
+		owner    string
+		role     string
+		expected bool
+	}{
+		{"alice", ROLE_ADMIN, true},
+		{"alice", ROLE_VIEWER, true},
+		{"bob", ROLE_EDITOR, true},
+		{"bob", ROLE_ADMIN, false},
+		{"carol", ROLE_VIEWER, true},
+		{"carol", ROLE_EDITOR, false},
+		{"dave", ROLE_VIEWER, false},
+		{"", ROLE_VIEWER, false},
+		{"dave", "", false},
+	}
+	for _, c := range cases {
+		if ws.Can(c.owner, c.role) != c.expected {
+			t.Errorf("Can(%q, %q) should be %v", c.owner, c.role, c.expected)
+		}
+	}
+}
+
+func TestCanAccessPersonalSpits(t *testing.T) {
+	s := &Spit{Id: "abc", OwnerId: "alice"}
+	if err := CanAccess(t.Context(), s, "alice", ROLE_ADMIN); err != nil {
+		t.Errorf("the owner should access its Spit with any role: %v", err)
+	}
+	if err := CanAccess(t.Context(), s, "bob", ROLE_VIEWER); err != ErrNotOwner {
+		t.Errorf("other owners should not access the Spit: %v", err)
+	}
+	if err := CanAccess(t.Context(), &Spit{Id: "abc"}, "", ROLE_VIEWER); err != ErrNotOwner {
+		t.Errorf("anonymous Spits should belong to nobody: %v", err)
+	}
+}
+
+func TestSetMemberRole(t *testing.T) {
+	withFakeStorager(t)
+	ctx := context.Background()
+	ws, err := NewWorkspace(ctx, "acme", "Acme", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SetMemberRole(ctx, ws, "bob", "owner"); err != ErrInvalidRole {
+		t.Errorf("expected ErrInvalidRole, got %v", err)
+	}
+	if err := SetMemberRole(ctx, ws, "alice", ROLE_EDITOR); err != ErrLastAdmin {
+		t.Errorf("the last admin cannot be demoted, got %v", err)
+	}
+	if err := SetMemberRole(ctx, ws, "alice", ""); err != ErrLastAdmin {
+		t.Errorf("the last admin cannot be removed, got %v", err)
+	}
+	if err := SetMemberRole(ctx, ws, "bob", ROLE_ADMIN); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetMemberRole(ctx, ws, "alice", ""); err != nil {
+		t.Fatalf("an admin can leave if another one is left, got %v", err)
+	}
+	stored, _ := storager.GetWorkspace(ctx, "acme")
+	if len(stored.Members) != 1 || stored.Members["bob"] != ROLE_ADMIN || stored.Version != ws.Version {
+		t.Errorf("unexpected workspace %+v, loaded %+v", stored, ws)
+	}
+}
+
+func TestSetMemberRoleConcurrentAdmins(t *testing.T) {
+	withFakeStorager(t)
+	ctx := context.Background()
+	ws, err := NewWorkspace(ctx, "acme", "Acme", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SetMemberRole(ctx, ws, "bob", ROLE_ADMIN); err != nil {
+		t.Fatal(err)
+	}
+
+	// both admins load the workspace and demote each other
+	byAlice, _ := storager.GetWorkspace(ctx, "acme")
+	byBob, _ := storager.GetWorkspace(ctx, "acme")
+	if err := SetMemberRole(ctx, byAlice, "bob", ROLE_VIEWER); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetMemberRole(ctx, byBob, "alice", ROLE_VIEWER); err != ErrWorkspaceChanged {
+		t.Fatalf("the stale workspace should not be changed, got %v", err)
+	}
+	stored, _ := storager.GetWorkspace(ctx, "acme")
+	if stored.admins() != 1 || stored.Members["alice"] != ROLE_ADMIN {
+		t.Errorf("alice should be left as the admin, got %+v", stored.Members)
+	}
+}
+
+func TestClaimSlug(t *testing.T) {
+	f := withFakeStorager(t)
+	ctx := context.Background()
+	past := time.Now().UTC().Add(-time.Hour).Format(time.RFC3339)
+	f.spits["expired"] = &Spit{Id: "expired", Slug: "acme/q3", Exp: 60, DateExpiration: past}
+	f.slugs["acme/q3"] = "expired"
+	f.slugs["acme/q4"] = "deleted"
+
+	for _, slug := range []string{"acme/q3", "acme/q4", "acme/q5"} {
+		s := &Spit{Id: "new-" + slug, Slug: slug}
+		f.spits[s.Id] = s
+		if err := s.claimSlug(ctx); err != nil {
+			t.Errorf("%v: the slug should be claimed, got %v", slug, err)
+		}
+		if f.slugs[slug] != s.Id {
+			t.Errorf("%v: expected the slug to point to %v, got %v", slug, s.Id, f.slugs[slug])
+		}
+	}
+
+	// the slug of a live spit is taken, and the spit that tried to claim it is deleted again
+	s := &Spit{Id: "late", Slug: "acme/q3"}
+	f.spits[s.Id] = s
+	if err := s.claimSlug(ctx); err != ErrSlugTaken {
+		t.Errorf("expected ErrSlugTaken, got %v", err)
+	}
+	if _, exists := f.spits["late"]; exists {
+		t.Error("the spit without its slug should be deleted")
+	}
+	if f.slugs["acme/q3"] != "new-acme/q3" {
+		t.Errorf("the slug should still point to its spit, got %v", f.slugs["acme/q3"])
+	}
+}
+
+func TestPutSlugTakeoverRace(t *testing.T) {
+	f := withFakeStorager(t)
+	ctx := context.Background()
+	past := time.Now().UTC().Add(-time.Hour).Format(time.RFC3339)
+	f.spits["expired"] = &Spit{Id: "expired", Slug: "acme/q3", Exp: 60, DateExpiration: past}
+	f.slugs["acme/q3"] = "expired"
+
+	// both spits find the slug expired, the first one takes it over before the second one writes
+	first := &Spit{Id: "first", Slug: "acme/q3"}
+	f.spits[first.Id] = first
+	if err := first.putSlug(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := storager.PutSlug(ctx, "acme/q3", "second", "expired"); err != ErrSlugTaken {
+		t.Errorf("the takeover should only replace the expired spit, got %v", err)
+	}
+	if f.slugs["acme/q3"] != "first" {
+		t.Errorf("expected the slug of the first spit, got %v", f.slugs["acme/q3"])
+	}
+}
@@ -0,0 +1,117 @@
+// Package spitcrypt implements the client side encryption of text Spits.
+//
+// The content is encrypted with AES-256-GCM and the key never reaches the server,
+// it only lives in the fragment of the Spit URL (http://spi.to/<id>#<key>).
+// The format is the same one produced by the WebCrypto API of the web app:
+//
+//	content = base64url(nonce[12] || ciphertext || tag[16])
+//	key     = base64url(key[32])
+//
+// both without padding.
+package spitcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+const (
+	// ENCRYPTION is the value of the encryption marker sent when creating the Spit
+	ENCRYPTION string = "aes-256-gcm"
+
+	KEY_SIZE   int = 32
+	NONCE_SIZE int = 12
+	TAG_SIZE   int = 16
+)
+
+var (
+	ErrInvalidKey     = errors.New("spitcrypt: invalid key")
+	ErrInvalidContent = errors.New("spitcrypt: invalid encrypted content")
+)
+
+var encoding = base64.RawURLEncoding
+
+// NewKey generates a new random key.
+func NewKey() ([]byte, error) {
+	key := make([]byte, KEY_SIZE)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != KEY_SIZE {
+		return nil, ErrInvalidKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypt encrypts the plaintext with the key and returns the content to be posted as the Spit.
+func Encrypt(plaintext []byte, key []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, NONCE_SIZE)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(gcm.Seal(nonce, nonce, plaintext, nil)), nil
+}
+
+// Decrypt decrypts the content of a Spit created with Encrypt().
+func Decrypt(content string, key []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := encoding.DecodeString(strings.TrimSpace(content))
+	if err != nil || len(raw) < NONCE_SIZE+TAG_SIZE {
+		return nil, ErrInvalidContent
+	}
+	plaintext, err := gcm.Open(nil, raw[:NONCE_SIZE], raw[NONCE_SIZE:], nil)
+	if err != nil {
+		return nil, ErrInvalidContent
+	}
+	return plaintext, nil
+}
+
+// EncodeKey returns the key in the form used in the URL fragment.
+func EncodeKey(key []byte) string {
+	return encoding.EncodeToString(key)
+}
+
+// DecodeKey parses a key encoded with EncodeKey().
+func DecodeKey(s string) ([]byte, error) {
+	key, err := encoding.DecodeString(s)
+	if err != nil || len(key) != KEY_SIZE {
+		return nil, ErrInvalidKey
+	}
+	return key, nil
+}
+
+// FragmentURL appends the key as the fragment of the absolute URL of the Spit.
+func FragmentURL(spitURL string, key []byte) string {
+	if i := strings.IndexByte(spitURL, '#'); i >= 0 {
+		spitURL = spitURL[:i]
+	}
+	return spitURL + "#" + EncodeKey(key)
+}
+
+// KeyFromURL extracts the key from the fragment of a URL built with FragmentURL().
+func KeyFromURL(spitURL string) ([]byte, error) {
+	i := strings.LastIndexByte(spitURL, '#')
+	if i < 0 {
+		return nil, ErrInvalidKey
+	}
+	return DecodeKey(spitURL[i+1:])
+}
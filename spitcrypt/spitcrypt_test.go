@@ -0,0 +1,51 @@
+package spitcrypt_test
+
+import (
+	"testing"
+
+	"github.com/lambrospetrou/spito/spitcrypt"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	key, err := spitcrypt.NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := spitcrypt.Encrypt([]byte("secret spit"), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := spitcrypt.FragmentURL("http://spi.to/abcd", key)
+	urlKey, err := spitcrypt.KeyFromURL(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := spitcrypt.Decrypt(content, urlKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != "secret spit" {
+		t.Fatalf("unexpected plaintext: %q", plaintext)
+	}
+}
+
+func TestDecryptTampered(t *testing.T) {
+	key, _ := spitcrypt.NewKey()
+	content, _ := spitcrypt.Encrypt([]byte("secret spit"), key)
+
+	tampered := []byte(content)
+	if tampered[len(tampered)/2] == 'A' {
+		tampered[len(tampered)/2] = 'B'
+	} else {
+		tampered[len(tampered)/2] = 'A'
+	}
+	if _, err := spitcrypt.Decrypt(string(tampered), key); err != spitcrypt.ErrInvalidContent {
+		t.Fatalf("expected ErrInvalidContent, got %v", err)
+	}
+
+	otherKey, _ := spitcrypt.NewKey()
+	if _, err := spitcrypt.Decrypt(content, otherKey); err != spitcrypt.ErrInvalidContent {
+		t.Fatalf("expected ErrInvalidContent, got %v", err)
+	}
+}
@@ -0,0 +1,250 @@
+// Package sso logs users in with OpenID Connect, the authorization code flow with PKCE,
+// and keeps them logged in with signed session cookies.
+package sso
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/lambrospetrou/spito/session"
+	"github.com/lambrospetrou/spito/utils"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+const (
+	SESSION_COOKIE string = "spito_session"
+	// the state of a login in progress, between the redirect to the provider and the callback
+	LOGIN_COOKIE string = "spito_login"
+
+	_LOGIN_TTL time.Duration = 10 * time.Minute
+)
+
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the absolute URL of the callback, e.g. https://spi.to/auth/callback
+	RedirectURL string
+	Scopes      []string
+	// OwnerClaim is the claim of the ID token used as the owner of the Spits, sub by default
+	OwnerClaim   string
+	SessionTTL   time.Duration
+	Secret       []byte
+	CookieSecure bool
+}
+
+// ConfigFromEnv reads the configuration from the SPITO_OIDC_* variables.
+// Login is disabled, and nil returned, if SPITO_OIDC_ISSUER is not set.
+func ConfigFromEnv() *Config {
+	issuer := utils.EnvString("SPITO_OIDC_ISSUER", "")
+	if len(issuer) == 0 {
+		return nil
+	}
+	return &Config{
+		Issuer:       issuer,
+		ClientID:     utils.EnvString("SPITO_OIDC_CLIENT_ID", ""),
+		ClientSecret: utils.EnvString("SPITO_OIDC_CLIENT_SECRET", ""),
+		RedirectURL:  utils.EnvString("SPITO_OIDC_REDIRECT_URL", ""),
+		Scopes:       strings.Fields(utils.EnvString("SPITO_OIDC_SCOPES", "openid email profile")),
+		OwnerClaim:   utils.EnvString("SPITO_OIDC_OWNER_CLAIM", "sub"),
+		SessionTTL:   utils.EnvDuration("SPITO_SESSION_TTL", 7*24*time.Hour),
+		Secret:       []byte(utils.EnvString("SPITO_SESSION_SECRET", "")),
+		CookieSecure: utils.EnvBool("SPITO_COOKIE_SECURE", true),
+	}
+}
+
+// Provider runs the login flow against the OpenID Connect provider of the Config.
+type Provider struct {
+	config   *Config
+	oauth    *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+	codec    *session.Codec
+}
+
+type _login struct {
+	State    string `json:"s"`
+	Verifier string `json:"v"`
+	Nonce    string `json:"n"`
+	ReturnTo string `json:"r"`
+}
+
+type _session struct {
+	Owner string `json:"o"`
+}
+
+// New discovers the endpoints of the issuer.
+func New(ctx context.Context, config *Config) (*Provider, error) {
+	if len(config.ClientID) == 0 || len(config.RedirectURL) == 0 {
+		return nil, errors.New("SPITO_OIDC_CLIENT_ID and SPITO_OIDC_REDIRECT_URL are required")
+	}
+	codec, err := session.NewCodec(config.Secret)
+	if err != nil {
+		return nil, err
+	}
+	provider, err := oidc.NewProvider(ctx, config.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	return &Provider{
+		config: config,
+		oauth: &oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			RedirectURL:  config.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       config.Scopes,
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: config.ClientID}),
+		codec:    codec,
+	}, nil
+}
+
+func _RandomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// _SafeReturnTo only allows redirecting back to our own paths after the login.
+// Browsers drop tabs and newlines and read backslashes as slashes, so neither the
+// URL nor its decoded path may have them, e.g. /%09/evil.com would leave the site.
+func _SafeReturnTo(returnTo string) string {
+	u, err := url.Parse(returnTo)
+	if err != nil || _HasUnsafeChars(returnTo) || _HasUnsafeChars(u.Path) ||
+		len(u.Scheme) > 0 || len(u.Host) > 0 || !strings.HasPrefix(u.Path, "/") || strings.HasPrefix(u.Path, "//") {
+		return "/"
+	}
+	cleaned, err := url.Parse(path.Clean(u.Path))
+	if err != nil || len(cleaned.Scheme) > 0 || len(cleaned.Host) > 0 {
+		return "/"
+	}
+	return returnTo
+}
+
+func _HasUnsafeChars(s string) bool {
+	return strings.ContainsFunc(s, func(r rune) bool {
+		return r == '\\' || unicode.IsControl(r) || unicode.IsSpace(r)
+	})
+}
+
+// Owner returns the owner of the session of the request, empty if there is none.
+func (p *Provider) Owner(r *http.Request) string {
+	s := &_session{}
+	if err := p.codec.ReadCookie(r, SESSION_COOKIE, s); err != nil {
+		return ""
+	}
+	return s.Owner
+}
+
+// LoginHandler redirects to the provider, return_to is where the user lands after the login.
+func (p *Provider) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	state, err1 := _RandomToken()
+	nonce, err2 := _RandomToken()
+	if err := errors.Join(err1, err2); err != nil {
+		slog.ErrorContext(r.Context(), "could not start the login", "err", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	login := &_login{
+		State:    state,
+		Verifier: oauth2.GenerateVerifier(),
+		Nonce:    nonce,
+		ReturnTo: _SafeReturnTo(r.FormValue("return_to")),
+	}
+	if err := p.codec.SetCookie(w, LOGIN_COOKIE, login, _LOGIN_TTL, p.config.CookieSecure); err != nil {
+		slog.ErrorContext(r.Context(), "could not start the login", "err", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	url := p.oauth.AuthCodeURL(state, oauth2.S256ChallengeOption(login.Verifier), oidc.Nonce(nonce))
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// CallbackHandler finishes the login, exchanging the code for the ID token
+// and starting the session of its owner.
+func (p *Provider) CallbackHandler(w http.ResponseWriter, r *http.Request) {
+	login := &_login{}
+	if err := p.codec.ReadCookie(r, LOGIN_COOKIE, login); err != nil {
+		http.Error(w, "Login expired, please try again", http.StatusBadRequest)
+		return
+	}
+	session.ClearCookie(w, LOGIN_COOKIE, p.config.CookieSecure)
+	// an empty state would match a callback without one
+	if len(login.State) == 0 || r.FormValue("state") != login.State {
+		http.Error(w, "Invalid login state", http.StatusBadRequest)
+		return
+	}
+	if errMsg := r.FormValue("error"); len(errMsg) > 0 {
+		http.Error(w, "Login failed: "+errMsg, http.StatusUnauthorized)
+		return
+	}
+
+	token, err := p.oauth.Exchange(r.Context(), r.FormValue("code"), oauth2.VerifierOption(login.Verifier))
+	if err != nil {
+		slog.WarnContext(r.Context(), "could not exchange the login code", "err", err)
+		http.Error(w, "Login failed", http.StatusUnauthorized)
+		return
+	}
+	owner, err := p.ownerFromToken(r.Context(), token, login.Nonce)
+	if err != nil {
+		slog.WarnContext(r.Context(), "invalid ID token", "err", err)
+		http.Error(w, "Login failed", http.StatusUnauthorized)
+		return
+	}
+
+	if err := p.codec.SetCookie(w, SESSION_COOKIE, &_session{Owner: owner}, p.config.SessionTTL, p.config.CookieSecure); err != nil {
+		slog.ErrorContext(r.Context(), "could not start the session", "err", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, login.ReturnTo, http.StatusFound)
+}
+
+func (p *Provider) ownerFromToken(ctx context.Context, token *oauth2.Token, nonce string) (string, error) {
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return "", errors.New("no id_token in the token response")
+	}
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return "", err
+	}
+	if idToken.Nonce != nonce {
+		return "", errors.New("the nonce does not match")
+	}
+	if p.config.OwnerClaim == "sub" {
+		return idToken.Subject, nil
+	}
+	claims := map[string]interface{}{}
+	if err := idToken.Claims(&claims); err != nil {
+		return "", err
+	}
+	// unverified emails could be claimed by anyone
+	if verified, ok := claims["email_verified"].(bool); ok && !verified && p.config.OwnerClaim == "email" {
+		return "", errors.New("the email of the ID token is not verified")
+	}
+	owner, _ := claims[p.config.OwnerClaim].(string)
+	if len(owner) == 0 {
+		return "", errors.New("the ID token has no " + p.config.OwnerClaim + " claim")
+	}
+	return owner, nil
+}
+
+// LogoutHandler ends the session.
+func (p *Provider) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	session.ClearCookie(w, SESSION_COOKIE, p.config.CookieSecure)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
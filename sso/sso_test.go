@@ -0,0 +1,237 @@
+package sso
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mockProvider is a minimal OpenID Connect provider issuing RS256 ID tokens
+type mockProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	// the parameters of the last authorization request
+	challenge string
+	nonce     string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockProvider{t: t, key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", m.discovery)
+	mux.HandleFunc("/jwks", m.jwks)
+	mux.HandleFunc("/token", m.token)
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func (m *mockProvider) writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (m *mockProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	m.writeJSON(w, map[string]interface{}{
+		"issuer":                                m.server.URL,
+		"authorization_endpoint":                m.server.URL + "/authorize",
+		"token_endpoint":                        m.server.URL + "/token",
+		"jwks_uri":                              m.server.URL + "/jwks",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (m *mockProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	m.writeJSON(w, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (m *mockProvider) idToken(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, digest[:])
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (m *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	verifier := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if r.FormValue("code") != "good-code" || base64.RawURLEncoding.EncodeToString(verifier[:]) != m.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		m.writeJSON(w, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	m.writeJSON(w, map[string]interface{}{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token": m.idToken(map[string]interface{}{
+			"iss":   m.server.URL,
+			"sub":   "user-123",
+			"aud":   "spito",
+			"iat":   time.Now().Unix(),
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"nonce": m.nonce,
+		}),
+	})
+}
+
+func newTestProvider(t *testing.T, m *mockProvider) *Provider {
+	p, err := New(t.Context(), &Config{
+		Issuer:      m.server.URL,
+		ClientID:    "spito",
+		RedirectURL: "https://spi.to/auth/callback",
+		Scopes:      []string{"openid"},
+		OwnerClaim:  "sub",
+		SessionTTL:  time.Hour,
+		Secret:      []byte(strings.Repeat("s", 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// login() starts the login and returns the login cookie and the state
+func login(t *testing.T, m *mockProvider, p *Provider) (*http.Cookie, string) {
+	rec := httptest.NewRecorder()
+	p.LoginHandler(rec, httptest.NewRequest("GET", "/auth/login?return_to=/mine", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login should redirect to the provider, got %v", rec.Code)
+	}
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := location.Query()
+	if query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("login should use PKCE: %v", location)
+	}
+	m.challenge = query.Get("code_challenge")
+	m.nonce = query.Get("nonce")
+	return rec.Result().Cookies()[0], query.Get("state")
+}
+
+func TestLoginFlow(t *testing.T) {
+	m := newMockProvider(t)
+	p := newTestProvider(t, m)
+	loginCookie, state := login(t, m, p)
+
+	req := httptest.NewRequest("GET", "/auth/callback?code=good-code&state="+url.QueryEscape(state), nil)
+	req.AddCookie(loginCookie)
+	rec := httptest.NewRecorder()
+	p.CallbackHandler(rec, req)
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/mine" {
+		t.Fatalf("callback should redirect back, got %v %v: %v", rec.Code, rec.Header().Get("Location"), rec.Body)
+	}
+
+	var sessionCookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == SESSION_COOKIE {
+			sessionCookie = c
+		}
+	}
+	if sessionCookie == nil || !sessionCookie.HttpOnly {
+		t.Fatal("callback should set an HttpOnly session cookie")
+	}
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(sessionCookie)
+	if owner := p.Owner(req); owner != "user-123" {
+		t.Fatalf("the owner should be the subject of the ID token, got %q", owner)
+	}
+}
+
+func TestCallbackRejectsWrongState(t *testing.T) {
+	m := newMockProvider(t)
+	p := newTestProvider(t, m)
+	loginCookie, _ := login(t, m, p)
+
+	req := httptest.NewRequest("GET", "/auth/callback?code=good-code&state=forged", nil)
+	req.AddCookie(loginCookie)
+	rec := httptest.NewRecorder()
+	p.CallbackHandler(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("forged state should be rejected, got %v", rec.Code)
+	}
+}
+
+func TestCallbackRejectsOtherCookies(t *testing.T) {
+	m := newMockProvider(t)
+	p := newTestProvider(t, m)
+
+	// a session signed with the same secret is not a login
+	rec := httptest.NewRecorder()
+	if err := p.codec.SetCookie(rec, SESSION_COOKIE, &_session{Owner: "user-123"}, time.Minute, false); err != nil {
+		t.Fatal(err)
+	}
+	sessionCookie := rec.Result().Cookies()[0]
+	sessionCookie.Name = LOGIN_COOKIE
+
+	// nor is a login without a state
+	rec = httptest.NewRecorder()
+	if err := p.codec.SetCookie(rec, LOGIN_COOKIE, &_login{ReturnTo: "/"}, time.Minute, false); err != nil {
+		t.Fatal(err)
+	}
+	emptyState := rec.Result().Cookies()[0]
+
+	for _, cookie := range []*http.Cookie{sessionCookie, emptyState} {
+		req := httptest.NewRequest("GET", "/auth/callback?code=good-code", nil)
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		p.CallbackHandler(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("expected 400, got %v", rec.Code)
+		}
+	}
+}
+
+func TestSafeReturnTo(t *testing.T) {
+	cases := map[string]string{
+		"/mine":            "/mine",
+		"":                 "/",
+		"https://evil.com": "/",
+		"//evil.com":       "/",
+		"/\\evil.com":      "/",
+		"/%09/evil.com":    "/",
+		"/\t/evil.com":     "/",
+		"/%5Cevil.com":     "/",
+		"/ /evil.com":      "/",
+		"/%2F/evil.com":    "/",
+		"javascript:x":     "/",
+		"mine":             "/",
+		"/mine?tab=a#top":  "/mine?tab=a#top",
+	}
+	for in, expected := range cases {
+		if out := _SafeReturnTo(in); out != expected {
+			t.Errorf("_SafeReturnTo(%q) = %q, expected %q", in, out, expected)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lambrospetrou/spito/spit"
+	"github.com/lambrospetrou/spito/utils"
+)
+
+// the roles of the staff moderating the spits, every role can do everything the roles below it can
+const (
+	// STAFF_ROLE_SUPPORT looks up spits, their creators and the audit log
+	STAFF_ROLE_SUPPORT string = "support"
+	// STAFF_ROLE_MODERATOR also disables and enables spits
+	STAFF_ROLE_MODERATOR string = "moderator"
+	// STAFF_ROLE_ADMIN also disables all the spits of a domain
+	STAFF_ROLE_ADMIN string = "admin"
+)
+
+var staffRoleRanks = map[string]int{STAFF_ROLE_SUPPORT: 1, STAFF_ROLE_MODERATOR: 2, STAFF_ROLE_ADMIN: 3}
+
+// staffRoles maps the owners, of API keys or sessions, to their staff role
+var staffRoles map[string]string
+
+// parseStaffRoles() parses SPITO_STAFF, e.g. "alice=admin,bob=moderator"
+func parseStaffRoles(s string) (map[string]string, error) {
+	roles := make(map[string]string)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+		owner, role, ok := strings.Cut(entry, "=")
+		owner, role = strings.TrimSpace(owner), strings.TrimSpace(role)
+		if !ok || len(owner) == 0 || staffRoleRanks[role] == 0 {
+			return nil, fmt.Errorf("invalid staff entry %q, expected owner=support|moderator|admin", entry)
+		}
+		roles[owner] = role
+	}
+	return roles, nil
+}
+
+// requireStaff() only lets through the staff with at least the given role
+func requireStaff(role string, fn http.HandlerFunc) http.HandlerFunc {
+	return requireOwner(func(w http.ResponseWriter, r *http.Request) {
+		if staffRoleRanks[staffRoles[requestOwner(r)]] < staffRoleRanks[role] {
+			writeAPIError(w, r, http.StatusForbidden, "Your staff role does not allow this")
+			return
+		}
+		fn(w, r)
+	})
+}
+
+type APIAdminSpitResult struct {
+	Id               string `json:"id"`
+	Content          string `json:"content"`
+	SpitType         string `json:"spit_type"`
+	DateCreated      string `json:"date_created"`
+	DateExpiration   string `json:"date_expiration,omitempty"`
+	Expires          bool   `json:"expires"`
+	AbsoluteURL      string `json:"absolute_url"`
+	Clicks           uint64 `json:"clicks"`
+	Protected        bool   `json:"protected"`
+	Encryption       string `json:"encryption,omitempty"`
+	MaxViews         int    `json:"max_views,omitempty"`
+	ViewsLeft        int    `json:"views_left,omitempty"`
+	Disabled         string `json:"disabled,omitempty"`
+	Reviewed         bool   `json:"reviewed,omitempty"`
+	Owner            string `json:"owner,omitempty"`
+	Workspace        string `json:"workspace,omitempty"`
+	CreatorIP        string `json:"creator_ip,omitempty"`
+	CreatorUserAgent string `json:"creator_user_agent,omitempty"`
+}
+
+type APIAuditResult struct {
+	Entries []*spit.AuditEntry `json:"entries"`
+}
+
+// audit() records the action of the staff member before it is carried out,
+// the action is reported as failed and not carried out if it cannot be recorded
+func audit(w http.ResponseWriter, r *http.Request, e *spit.AuditEntry) bool {
+	e.Actor = requestOwner(r)
+	if err := spit.Audit(r.Context(), e); err != nil {
+		slog.ErrorContext(r.Context(), "could not record the audit entry", "err", err, "action", e.Action, "spit_id", e.SpitId)
+		writeInternalError(w, r)
+		return false
+	}
+	return true
+}
+
+// auditFailed() follows the entry of an action that failed after it was recorded up with the cause
+func auditFailed(r *http.Request, e *spit.AuditEntry, cause string) {
+	failed := &spit.AuditEntry{Actor: e.Actor, Action: spit.AUDIT_ACTION_FAILED, SpitId: e.SpitId,
+		Reason: e.Reason, Note: e.Action + ": " + cause}
+	// the log has to be corrected even if the client is gone
+	if err := spit.Audit(context.WithoutCancel(r.Context()), failed); err != nil {
+		slog.ErrorContext(r.Context(), "could not record the audit entry", "err", err, "action", failed.Action, "spit_id", e.SpitId)
+	}
+}
+
+// apiAdminSpitHandler() returns any spit with the metadata of its creator
+func apiAdminSpitHandler(w http.ResponseWriter, r *http.Request, id string) {
+	s, err := spit.LoadAny(r.Context(), id)
+	if err != nil {
+		if _, notFound := err.(spit.DynamoDbItemNotFoundError); notFound {
+			writeAPIError(w, r, http.StatusNotFound, "Spit not found")
+			return
+		}
+		slog.ErrorContext(r.Context(), "could not load spit", "err", err, "spit_id", id)
+		writeInternalError(w, r)
+		return
+	}
+	// the creators are personal data so every lookup is recorded
+	if !audit(w, r, &spit.AuditEntry{Action: spit.AUDIT_ACTION_LOOKUP, SpitId: id}) {
+		return
+	}
+	writeAPIResult(w, r, http.StatusOK, &APIAdminSpitResult{
+		Id: s.Id, Content: s.Content, SpitType: s.SpitType,
+		DateCreated: s.DateCreated, DateExpiration: s.ExpirationDate(), Expires: s.Expires(),
+		AbsoluteURL: spit.AbsoluteUrl(s), Clicks: s.MetricClicks, Protected: s.IsProtected(),
+		Encryption: s.Encryption, MaxViews: s.MaxViews, ViewsLeft: s.ViewsLeft, Disabled: s.Disabled, Reviewed: s.Reviewed,
+		Owner: s.OwnerId, Workspace: s.WorkspaceId, CreatorIP: s.CreatorIP, CreatorUserAgent: s.CreatorUserAgent,
+	})
+}
+
+// apiAdminDisableHandler() disables the spit for the posted reason, abuse or legal
+func apiAdminDisableHandler(w http.ResponseWriter, r *http.Request, id string) {
+	setSpitDisabled(w, r, id, strings.TrimSpace(r.FormValue("reason")), spit.AUDIT_ACTION_DISABLE)
+}
+
+// apiAdminEnableHandler() serves a disabled spit again
+func apiAdminEnableHandler(w http.ResponseWriter, r *http.Request, id string) {
+	setSpitDisabled(w, r, id, "", spit.AUDIT_ACTION_ENABLE)
+}
+
+func setSpitDisabled(w http.ResponseWriter, r *http.Request, id string, reason string, action string) {
+	if action == spit.AUDIT_ACTION_DISABLE && !spit.ActiveDisableReasons[reason] {
+		writeAPIError(w, r, http.StatusBadRequest, spit.ErrInvalidDisableReason.Error())
+		return
+	}
+	if _, err := spit.LoadAny(r.Context(), id); err != nil {
+		if _, notFound := err.(spit.DynamoDbItemNotFoundError); notFound {
+			writeAPIError(w, r, http.StatusNotFound, "Spit not found")
+			return
+		}
+		slog.ErrorContext(r.Context(), "could not load spit", "err", err, "spit_id", id)
+		writeInternalError(w, r)
+		return
+	}
+	// an action that failed after it was recorded is better than one that was never recorded
+	entry := &spit.AuditEntry{Action: action, SpitId: id, Reason: reason, Note: r.FormValue("note")}
+	if !audit(w, r, entry) {
+		return
+	}
+	if err := spit.Disable(r.Context(), id, reason); err != nil {
+		// the spit was deleted or expired since it was loaded
+		if _, notFound := err.(spit.DynamoDbItemNotFoundError); notFound {
+			auditFailed(r, entry, "Spit not found")
+			writeAPIError(w, r, http.StatusNotFound, "Spit not found")
+			return
+		}
+		auditFailed(r, entry, "storage error")
+		slog.ErrorContext(r.Context(), "could not change spit", "err", err, "spit_id", id, "action", action)
+		writeInternalError(w, r)
+		return
+	}
+	slog.InfoContext(r.Context(), "staff changed spit", "actor", requestOwner(r), "action", action, "reason", reason)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiAdminDisableDomainHandler() starts a job disabling all the URL spits pointing to
+// the posted domain or its subdomains, and returns it to look its status up
+func apiAdminDisableDomainHandler(w http.ResponseWriter, r *http.Request) {
+	domain := utils.NormalizeDomain(r.FormValue("domain"))
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if len(domain) == 0 || !strings.Contains(domain, ".") {
+		writeAPIError(w, r, http.StatusBadRequest, "Invalid domain specified")
+		return
+	}
+	if !spit.ActiveDisableReasons[reason] {
+		writeAPIError(w, r, http.StatusBadRequest, spit.ErrInvalidDisableReason.Error())
+		return
+	}
+	jobId, err := newJobId()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not start the job", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+	note := r.FormValue("note")
+	entry := &spit.AuditEntry{Action: spit.AUDIT_ACTION_DISABLE_DOMAIN, Job: jobId, Domain: domain, Reason: reason, Note: note}
+	if !audit(w, r, entry) {
+		return
+	}
+	job := &APIJobResult{Id: jobId, Domain: domain, Reason: reason}
+	domainJobs.startDisableDomain(job, requestOwner(r), note)
+	w.Header().Set("Location", "/api/v1/admin/jobs/"+jobId)
+	writeAPIResult(w, r, http.StatusAccepted, domainJobs.get(jobId))
+}
+
+// apiAdminJobHandler() returns the status of a job started on this instance, the outcome
+// of every job is also recorded in the audit log
+func apiAdminJobHandler(w http.ResponseWriter, r *http.Request) {
+	job := domainJobs.get(r.URL.Query().Get(":id"))
+	if job == nil {
+		writeAPIError(w, r, http.StatusNotFound, "Job not found on this instance, look its outcome up in the audit log")
+		return
+	}
+	writeAPIResult(w, r, http.StatusOK, job)
+}
+
+// apiAdminAuditHandler() returns the audit log of a day, today by default
+func apiAdminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	day := r.FormValue("day")
+	if len(day) == 0 {
+		day = time.Now().UTC().Format(time.DateOnly)
+	}
+	limit := 0
+	if l := r.FormValue("limit"); len(l) > 0 {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit <= 0 {
+			writeAPIError(w, r, http.StatusBadRequest, "Invalid limit specified")
+			return
+		}
+	}
+	if _, err := time.Parse(time.DateOnly, day); err != nil {
+		writeAPIError(w, r, http.StatusBadRequest, "Invalid day specified, use YYYY-MM-DD")
+		return
+	}
+	entries, err := spit.ListAudit(r.Context(), day, limit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not list the audit log", "err", err)
+		writeInternalError(w, r)
+		return
+	}
+	writeAPIResult(w, r, http.StatusOK, &APIAuditResult{Entries: entries})
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/lambrospetrou/spito/spit"
+)
+
+// staffStorager keeps the spits and the audit log, in the order the handlers write them
+type staffStorager struct {
+	spit.Storager
+	sync.Mutex
+	spits    map[string]*spit.Spit
+	writes   []string
+	auditErr error
+	// disableErr fails the next SetDisabled, e.g. for a spit deleted since it was loaded
+	disableErr error
+}
+
+func (s *staffStorager) Get(ctx context.Context, id string) (*spit.Spit, error) {
+	s.Lock()
+	defer s.Unlock()
+	found, ok := s.spits[id]
+	if !ok {
+		return nil, spit.DynamoDbItemNotFoundError{}
+	}
+	c := *found
+	return &c, nil
+}
+
+func (s *staffStorager) PutAudit(ctx context.Context, e *spit.AuditEntry) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.auditErr != nil {
+		return s.auditErr
+	}
+	s.writes = append(s.writes, "audit:"+e.Action)
+	return nil
+}
+
+func (s *staffStorager) SetDisabled(ctx context.Context, id string, reason string) error {
+	s.Lock()
+	defer s.Unlock()
+	if err := s.disableErr; err != nil {
+		s.disableErr = nil
+		return err
+	}
+	found, ok := s.spits[id]
+	if !ok {
+		return spit.DynamoDbItemNotFoundError{}
+	}
+	found.Disabled = reason
+	s.writes = append(s.writes, "disable:"+id)
+	return nil
+}
+
+// ScanURLs scans all the spits at once, the domain disables do not page
+func (s *staffStorager) ScanURLs(ctx context.Context, cursor string, limit int, fn func(s *spit.Spit) error) (string, error) {
+	s.Lock()
+	found := make([]*spit.Spit, 0)
+	for _, sp := range s.spits {
+		if sp.SpitType == spit.SPIT_TYPE_URL {
+			c := *sp
+			found = append(found, &c)
+		}
+	}
+	s.Unlock()
+	for _, sp := range found {
+		if err := fn(sp); err != nil {
+			return cursor, err
+		}
+	}
+	return "", nil
+}
+
+func withStaffStorager(t *testing.T) *staffStorager {
+	s := &staffStorager{spits: map[string]*spit.Spit{
+		"abc": {Id: "abc", SpitType: spit.SPIT_TYPE_URL, Content: "https://evil.com/a"},
+		"def": {Id: "def", SpitType: spit.SPIT_TYPE_URL, Content: "https://fine.com/b"},
+	}}
+	spit.InitWith(s)
+	t.Cleanup(func() { spit.InitWith(nil) })
+	return s
+}
+
+func withStaff(t *testing.T, roles map[string]string) {
+	previous := staffRoles
+	staffRoles = roles
+	t.Cleanup(func() { staffRoles = previous })
+}
+
+func TestParseStaffRoles(t *testing.T) {
+	roles, err := parseStaffRoles(" alice=admin, bob = moderator,,carol=support ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roles) != 3 || roles["alice"] != STAFF_ROLE_ADMIN || roles["bob"] != STAFF_ROLE_MODERATOR || roles["carol"] != STAFF_ROLE_SUPPORT {
+		t.Errorf("unexpected roles %v", roles)
+	}
+	if roles, err := parseStaffRoles(""); err != nil || len(roles) != 0 {
+		t.Errorf("expected no staff, got %v %v", roles, err)
+	}
+	for _, invalid := range []string{"alice", "alice=root", "=admin", "alice=admin,bob"} {
+		if _, err := parseStaffRoles(invalid); err == nil {
+			t.Errorf("%q should be rejected", invalid)
+		}
+	}
+}
+
+func TestRequireStaff(t *testing.T) {
+	withStaff(t, map[string]string{"alice": STAFF_ROLE_ADMIN, "bob": STAFF_ROLE_MODERATOR, "carol": STAFF_ROLE_SUPPORT})
+	handler := requireStaff(STAFF_ROLE_MODERATOR, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	cases := map[string]int{
+		"":      http.StatusUnauthorized,
+		"dave":  http.StatusForbidden,
+		"carol": http.StatusForbidden,
+		"bob":   http.StatusNoContent,
+		"alice": http.StatusNoContent,
+	}
+	for owner, expected := range cases {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/admin/spits/abc/disable", nil)
+		if len(owner) > 0 {
+			r = asOwner(r, owner)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != expected {
+			t.Errorf("%q: expected %v, got %v", owner, expected, w.Code)
+		}
+	}
+}
+
+func disableRequest(target string, form url.Values) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return asOwner(r, "bob")
+}
+
+func TestSetSpitDisabledAuditsFirst(t *testing.T) {
+	s := withStaffStorager(t)
+	w := httptest.NewRecorder()
+	apiAdminDisableHandler(w, disableRequest("/api/v1/admin/spits/abc/disable", url.Values{"reason": {"abuse"}}), "abc")
+	if w.Code != http.StatusNoContent || strings.Join(s.writes, ",") != "audit:disable,disable:abc" {
+		t.Fatalf("expected the audit before the change, got %v %v", w.Code, s.writes)
+	}
+
+	s = withStaffStorager(t)
+	s.auditErr = errors.New("throttled")
+	w = httptest.NewRecorder()
+	apiAdminDisableHandler(w, disableRequest("/api/v1/admin/spits/abc/disable", url.Values{"reason": {"abuse"}}), "abc")
+	if w.Code != http.StatusInternalServerError || len(s.spits["abc"].Disabled) > 0 {
+		t.Errorf("a change that cannot be audited should not be made, got %v %+v", w.Code, s.spits["abc"])
+	}
+
+	s = withStaffStorager(t)
+	w = httptest.NewRecorder()
+	apiAdminDisableHandler(w, disableRequest("/api/v1/admin/spits/nope/disable", url.Values{"reason": {"abuse"}}), "nope")
+	if w.Code != http.StatusNotFound || len(s.writes) > 0 {
+		t.Errorf("missing spits should not be audited, got %v %v", w.Code, s.writes)
+	}
+
+	for _, c := range []struct {
+		err  error
+		code int
+	}{
+		{spit.DynamoDbItemNotFoundError{}, http.StatusNotFound},
+		{errors.New("throttled"), http.StatusInternalServerError},
+	} {
+		s = withStaffStorager(t)
+		s.disableErr = c.err
+		w = httptest.NewRecorder()
+		apiAdminDisableHandler(w, disableRequest("/api/v1/admin/spits/abc/disable", url.Values{"reason": {"abuse"}}), "abc")
+		if w.Code != c.code || strings.Join(s.writes, ",") != "audit:disable,audit:failed" {
+			t.Errorf("%v: a change that failed after it was audited should be followed up, got %v %v", c.err, w.Code, s.writes)
+		}
+	}
+}
+
+func TestDisableDomainJob(t *testing.T) {
+	s := withStaffStorager(t)
+	previous := domainJobs
+	domainJobs = newJobs()
+	t.Cleanup(func() { domainJobs = previous })
+
+	w := httptest.NewRecorder()
+	apiAdminDisableDomainHandler(w, disableRequest("/api/v1/admin/domains/disable", url.Values{"domain": {"evil.com"}, "reason": {"abuse"}}))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %v %v", w.Code, w.Body)
+	}
+	location := w.Header().Get("Location")
+	jobId := strings.TrimPrefix(location, "/api/v1/admin/jobs/")
+	domainJobs.shutdown()
+
+	r := httptest.NewRequest(http.MethodGet, location+"?:id="+jobId, nil)
+	w = httptest.NewRecorder()
+	apiAdminJobHandler(w, r)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"status":"done"`) || !strings.Contains(w.Body.String(), `"disabled":["abc"]`) {
+		t.Errorf("expected the job to be done, got %v %v", w.Code, w.Body)
+	}
+	if strings.Join(s.writes, ",") != "audit:disable_domain,disable:abc,audit:disable_domain_done" {
+		t.Errorf("unexpected writes %v", s.writes)
+	}
+}
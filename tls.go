@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lambrospetrou/spito/utils"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLS is enabled either with static certificate files (SPITO_TLS_CERT, SPITO_TLS_KEY)
+// or with certificates issued through ACME for SPITO_ACME_DOMAINS.
+// SPITO_ACME_DIRECTORY and SPITO_ACME_CA_ROOTS point to another ACME server than
+// Let's Encrypt, e.g. a local Pebble with its test CA.
+type tlsSettings struct {
+	certFile string
+	keyFile  string
+
+	acmeDomains   []string
+	acmeCacheDir  string
+	acmeEmail     string
+	acmeDirectory string
+	acmeCARoots   string
+
+	tlsAddr  string
+	httpAddr string
+	hstsAge  time.Duration
+	// hstsSubdomains also covers the subdomains, only if all of them serve HTTPS
+	hstsSubdomains bool
+}
+
+func tlsSettingsFromEnv() *tlsSettings {
+	settings := &tlsSettings{
+		certFile:      utils.EnvString("SPITO_TLS_CERT", ""),
+		keyFile:       utils.EnvString("SPITO_TLS_KEY", ""),
+		acmeCacheDir:  utils.EnvString("SPITO_ACME_CACHE", "autocert-cache"),
+		acmeEmail:     utils.EnvString("SPITO_ACME_EMAIL", ""),
+		acmeDirectory: utils.EnvString("SPITO_ACME_DIRECTORY", autocert.DefaultACMEDirectory),
+		acmeCARoots:   utils.EnvString("SPITO_ACME_CA_ROOTS", ""),
+		tlsAddr:       utils.EnvString("SPITO_TLS_ADDR", ":443"),
+		httpAddr:      utils.EnvString("SPITO_HTTP_ADDR", ":80"),
+		hstsAge:       utils.EnvDuration("SPITO_HSTS_MAX_AGE", 365*24*time.Hour),
+
+		hstsSubdomains: utils.EnvBool("SPITO_HSTS_INCLUDE_SUBDOMAINS", false),
+	}
+	for _, domain := range strings.Split(utils.EnvString("SPITO_ACME_DOMAINS", ""), ",") {
+		if domain = strings.TrimSpace(domain); len(domain) > 0 {
+			settings.acmeDomains = append(settings.acmeDomains, domain)
+		}
+	}
+	return settings
+}
+
+func (s *tlsSettings) enabled() bool {
+	return len(s.certFile) > 0 || len(s.keyFile) > 0 || len(s.acmeDomains) > 0
+}
+
+func (s *tlsSettings) validate() error {
+	if len(s.acmeDomains) > 0 && (len(s.certFile) > 0 || len(s.keyFile) > 0) {
+		return errors.New("use either static certificates or ACME, not both")
+	}
+	if len(s.acmeDomains) == 0 && (len(s.certFile) == 0 || len(s.keyFile) == 0) {
+		return errors.New("both SPITO_TLS_CERT and SPITO_TLS_KEY are required")
+	}
+	return nil
+}
+
+// hstsHandler() tells the browsers to only use HTTPS for the given time
+func hstsHandler(h http.Handler, maxAge time.Duration, includeSubdomains bool) http.Handler {
+	value := fmt.Sprintf("max-age=%d", int64(maxAge.Seconds()))
+	if includeSubdomains {
+		value += "; includeSubDomains"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", value)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// httpsRedirectHandler() sends the plain HTTP requests to the same URL over HTTPS
+func httpsRedirectHandler(tlsAddr string) http.Handler {
+	_, tlsPort, _ := net.SplitHostPort(tlsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if len(tlsPort) > 0 && tlsPort != "443" {
+			host = net.JoinHostPort(host, tlsPort)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+func (s *tlsSettings) acmeManager() (*autocert.Manager, error) {
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(s.acmeCacheDir),
+		HostPolicy: autocert.HostWhitelist(s.acmeDomains...),
+		Email:      s.acmeEmail,
+		Client:     &acme.Client{DirectoryURL: s.acmeDirectory},
+	}
+	if len(s.acmeCARoots) > 0 {
+		pem, err := os.ReadFile(s.acmeCARoots)
+		if err != nil {
+			return nil, err
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in " + s.acmeCARoots)
+		}
+		m.Client.HTTPClient = &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}},
+		}
+	}
+	return m, nil
+}
+
+// tlsListeners() returns the HTTPS listener serving handler and the plain HTTP listener
+// redirecting to it, which also answers the ACME HTTP-01 challenges.
+func (s *tlsSettings) tlsListeners(handler http.Handler) ([]serverListener, error) {
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+
+	httpsServer := newServer(s.tlsAddr, hstsHandler(handler, s.hstsAge, s.hstsSubdomains))
+	redirect := withHealthRoutes(httpsRedirectHandler(s.tlsAddr))
+
+	if len(s.acmeDomains) > 0 {
+		m, err := s.acmeManager()
+		if err != nil {
+			return nil, err
+		}
+		httpsServer.TLSConfig = m.TLSConfig()
+		redirect = m.HTTPHandler(redirect)
+	}
+	httpsServer.TLSConfig = withTLSDefaults(httpsServer.TLSConfig)
+
+	certFile, keyFile := s.certFile, s.keyFile
+	return []serverListener{
+		{httpsServer, func(server *http.Server) error {
+			return server.ListenAndServeTLS(certFile, keyFile)
+		}},
+		{newServer(s.httpAddr, redirect), (*http.Server).ListenAndServe},
+	}, nil
+}
+
+func withTLSDefaults(config *tls.Config) *tls.Config {
+	if config == nil {
+		config = &tls.Config{}
+	}
+	config.MinVersion = tls.VersionTLS12
+	return config
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTLSSettingsValidate(t *testing.T) {
+	cases := []struct {
+		name     string
+		settings *tlsSettings
+		valid    bool
+	}{
+		{"static", &tlsSettings{certFile: "cert.pem", keyFile: "key.pem"}, true},
+		{"acme", &tlsSettings{acmeDomains: []string{"spi.to"}}, true},
+		{"cert without key", &tlsSettings{certFile: "cert.pem"}, false},
+		{"key without cert", &tlsSettings{keyFile: "key.pem"}, false},
+		{"acme and cert", &tlsSettings{acmeDomains: []string{"spi.to"}, certFile: "cert.pem"}, false},
+		{"acme and key", &tlsSettings{acmeDomains: []string{"spi.to"}, keyFile: "key.pem"}, false},
+	}
+	for _, c := range cases {
+		if err := c.settings.validate(); (err == nil) != c.valid {
+			t.Errorf("%v: expected valid %v, got %v", c.name, c.valid, err)
+		}
+	}
+}
+
+func TestHSTSHandler(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	cases := map[bool]string{
+		false: "max-age=3600",
+		true:  "max-age=3600; includeSubDomains",
+	}
+	for subdomains, expected := range cases {
+		w := httptest.NewRecorder()
+		hstsHandler(ok, time.Hour, subdomains).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if value := w.Header().Get("Strict-Transport-Security"); value != expected {
+			t.Errorf("subdomains %v: expected %q, got %q", subdomains, expected, value)
+		}
+	}
+}
+
+func TestHTTPSRedirectHandler(t *testing.T) {
+	cases := []struct {
+		tlsAddr  string
+		target   string
+		location string
+	}{
+		{":443", "http://spi.to/abc?x=1", "https://spi.to/abc?x=1"},
+		{":443", "http://spi.to:80/abc", "https://spi.to/abc"},
+		{":8443", "http://localhost:8080/abc", "https://localhost:8443/abc"},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		httpsRedirectHandler(c.tlsAddr).ServeHTTP(w, httptest.NewRequest(http.MethodGet, c.target, nil))
+		if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != c.location {
+			t.Errorf("%v via %v: expected a redirect to %q, got %v %q", c.target, c.tlsAddr, c.location, w.Code, w.Header().Get("Location"))
+		}
+	}
+}
+
+func TestHTTPSRedirectSkipsHealthChecks(t *testing.T) {
+	handler := withHealthRoutes(httpsRedirectHandler(":443"))
+	cases := map[string]int{
+		"http://spi.to/healthz": http.StatusOK,
+		"http://spi.to/abc":     http.StatusMovedPermanently,
+	}
+	for target, expected := range cases {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != expected {
+			t.Errorf("%v: expected %v, got %v", target, expected, w.Code)
+		}
+	}
+}
+
+// TestACMEManagerWithLocalCA checks that the ACME client trusts the CA of a local ACME server,
+// like Pebble, through SPITO_ACME_CA_ROOTS. The stand-in only serves the directory.
+func TestACMEManagerWithLocalCA(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"newNonce":   server.URL + "/nonce",
+			"newAccount": server.URL + "/account",
+			"newOrder":   server.URL + "/order",
+		})
+	}))
+	defer server.Close()
+
+	roots := filepath.Join(t.TempDir(), "roots.pem")
+	block := &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}
+	if err := os.WriteFile(roots, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	settings := &tlsSettings{acmeDomains: []string{"spi.to"}, acmeDirectory: server.URL + "/dir", acmeCARoots: roots, acmeCacheDir: t.TempDir()}
+	m, err := settings.acmeManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := m.Client.Discover(t.Context())
+	if err != nil {
+		t.Fatalf("the directory of the local ACME server should be trusted: %v", err)
+	}
+	if dir.OrderURL != server.URL+"/order" {
+		t.Errorf("unexpected directory %+v", dir)
+	}
+
+	settings.acmeCARoots = filepath.Join(t.TempDir(), "missing.pem")
+	if _, err := settings.acmeManager(); err == nil {
+		t.Error("missing CA roots should be rejected")
+	}
+}
@@ -0,0 +1,88 @@
+// Package tracing sets up the OpenTelemetry tracing of spito.
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/lambrospetrou/spito/utils"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	_TRACER_NAME  string = "github.com/lambrospetrou/spito"
+	_SERVICE_NAME string = "spito"
+)
+
+var tracer = otel.Tracer(_TRACER_NAME)
+
+// Init sets up the W3C trace context propagation and, if OTEL_EXPORTER_OTLP_ENDPOINT
+// or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set, exports the spans over OTLP/HTTP,
+// e.g. to a local collector at http://localhost:4318.
+// SPITO_TRACE_SAMPLE_PERCENT is the percentage of new traces recorded, 100 by default.
+// It returns the function flushing the pending spans on shutdown.
+func Init(ctx context.Context) (func(ctx context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+
+	if len(utils.EnvString("OTEL_EXPORTER_OTLP_ENDPOINT", "")) == 0 &&
+		len(utils.EnvString("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")) == 0 {
+		return func(ctx context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(),
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(_SERVICE_NAME)))
+	if err != nil {
+		return nil, err
+	}
+
+	ratio := float64(utils.EnvInt("SPITO_TRACE_SAMPLE_PERCENT", 100)) / 100
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Start starts a span as a child of the span in the context.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End marks the span as failed if there is an error and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Handler starts a server span for every request, continuing the trace
+// of the caller if the request carries a traceparent header.
+func Handler(h http.Handler) http.Handler {
+	return otelhttp.NewHandler(h, "http.request")
+}
+
+// SetRoute names the server span of the request after its route.
+func SetRoute(ctx context.Context, method string, route string) {
+	span := trace.SpanFromContext(ctx)
+	span.SetName(method + " " + route)
+	span.SetAttributes(semconv.HTTPRoute(route))
+}
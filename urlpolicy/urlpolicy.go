@@ -0,0 +1,149 @@
+// Package urlpolicy decides which destinations URL spits may point to,
+// with blocklists and optional allowlists of domains and regular expressions.
+package urlpolicy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/lambrospetrou/spito/utils"
+)
+
+var (
+	ErrBlocked    = errors.New("URL points to a blocked domain")
+	ErrNotAllowed = errors.New("URL points to a domain that is not allowed")
+)
+
+// _rule matches a URL by its host, or the whole URL for regular expressions
+type _rule struct {
+	domain string
+	// subdomainsOnly is set for *.example.com which does not match example.com itself
+	subdomainsOnly bool
+	regexp         *regexp.Regexp
+}
+
+func (r *_rule) matches(rawURL string, host string) bool {
+	if r.regexp != nil {
+		return r.regexp.MatchString(rawURL)
+	}
+	if r.subdomainsOnly && host == r.domain {
+		return false
+	}
+	return utils.HostMatchesDomain(host, r.domain)
+}
+
+func _ParseRule(pattern string) (*_rule, error) {
+	if expr, ok := strings.CutPrefix(pattern, "re:"); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		return &_rule{regexp: re}, nil
+	}
+	rule := &_rule{}
+	if domain, ok := strings.CutPrefix(pattern, "*."); ok {
+		rule.subdomainsOnly = true
+		pattern = domain
+	}
+	rule.domain = utils.NormalizeDomain(pattern)
+	if len(rule.domain) == 0 || strings.ContainsAny(rule.domain, "/:*@ ") {
+		return nil, fmt.Errorf("invalid domain %q", pattern)
+	}
+	return rule, nil
+}
+
+// Policy is a set of rules, the blocked URLs are rejected and if there are allowed
+// rules only the URLs matching one of them are accepted.
+type Policy struct {
+	block []*_rule
+	allow []*_rule
+}
+
+// Parse reads a policy with one rule per line, blank lines and # comments are skipped:
+//
+//	block example.com          # example.com and all its subdomains
+//	block re:^https?://[^/]+/phish
+//	allow *.ourcompany.com     # only the subdomains of ourcompany.com
+func Parse(r io.Reader) (*Policy, error) {
+	p := &Policy{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		// regular expressions may contain # so only strip comments after whitespace
+		if i := strings.Index(text, " #"); i >= 0 {
+			text = text[:i]
+		}
+		text = strings.TrimSpace(text)
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected block or allow and a pattern", line)
+		}
+		rule, err := _ParseRule(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		switch kind := fields[0]; kind {
+		case "block":
+			p.block = append(p.block, rule)
+		case "allow":
+			p.allow = append(p.allow, rule)
+		default:
+			return nil, fmt.Errorf("line %d: expected block or allow, got %q", line, kind)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Check returns ErrBlocked or ErrNotAllowed if the URL violates the policy.
+func (p *Policy) Check(rawURL string) error {
+	if p == nil {
+		return nil
+	}
+	rawURL = strings.TrimSpace(rawURL)
+	host := utils.URLHost(rawURL)
+	for _, rule := range p.block {
+		if rule.matches(rawURL, host) {
+			return ErrBlocked
+		}
+	}
+	if len(p.allow) == 0 {
+		return nil
+	}
+	for _, rule := range p.allow {
+		if rule.matches(rawURL, host) {
+			return nil
+		}
+	}
+	return ErrNotAllowed
+}
+
+// Rules returns the number of block and allow rules.
+func (p *Policy) Rules() (int, int) {
+	if p == nil {
+		return 0, 0
+	}
+	return len(p.block), len(p.allow)
+}
+
+var _current atomic.Pointer[Policy]
+
+// Set replaces the policy used by Check, nil allows every URL.
+func Set(p *Policy) {
+	_current.Store(p)
+}
+
+// Check checks the URL against the current policy.
+func Check(rawURL string) error {
+	return _current.Load().Check(rawURL)
+}
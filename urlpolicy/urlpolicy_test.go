@@ -0,0 +1,108 @@
+package urlpolicy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustParse(t *testing.T, s string) *Policy {
+	p, err := Parse(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestBlocklist(t *testing.T) {
+	p := mustParse(t, `
+# phishing
+block evil.com
+block re:^https?://[^/]+/wp-admin/  # compromised blogs
+`)
+	cases := map[string]error{
+		"https://evil.com/login":              ErrBlocked,
+		"https://www.EVIL.com/login":          ErrBlocked,
+		"https://notevil.com/login":           nil,
+		"https://blog.example.org/wp-admin/x": ErrBlocked,
+		"https://blog.example.org/post":       nil,
+	}
+	for url, expected := range cases {
+		if err := p.Check(url); err != expected {
+			t.Errorf("Check(%q) = %v, expected %v", url, err, expected)
+		}
+	}
+}
+
+func TestAllowlist(t *testing.T) {
+	p := mustParse(t, "allow *.ourcompany.com\nallow partner.org\nblock leaks.ourcompany.com\n")
+	cases := map[string]error{
+		"https://docs.ourcompany.com/q3":  nil,
+		"https://ourcompany.com/":         ErrNotAllowed,
+		"https://partner.org/":            nil,
+		"https://www.partner.org/":        nil,
+		"https://leaks.ourcompany.com/":   ErrBlocked,
+		"https://ourcompany.com.evil.io/": ErrNotAllowed,
+		"https://example.com/":            ErrNotAllowed,
+	}
+	for url, expected := range cases {
+		if err := p.Check(url); err != expected {
+			t.Errorf("Check(%q) = %v, expected %v", url, err, expected)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, s := range []string{"deny evil.com", "block", "block re:(", "block https://evil.com/", "allow *"} {
+		if _, err := Parse(strings.NewReader(s)); err == nil {
+			t.Errorf("Parse(%q) should fail", s)
+		}
+	}
+}
+
+func TestWatchFileReloads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy")
+	if err := os.WriteFile(path, []byte("block evil.com\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	stop, err := WatchFile(path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	defer Set(nil)
+	if Check("https://evil.com/") != ErrBlocked {
+		t.Fatal("the policy of the file should be loaded")
+	}
+
+	write := func(content string, modTime time.Time) {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor := func(url string, expected error) {
+		deadline := time.Now().Add(2 * time.Second)
+		for Check(url) != expected {
+			if time.Now().After(deadline) {
+				t.Fatalf("Check(%q) should become %v", url, expected)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	write("block other.com\n", time.Now().Add(time.Minute))
+	waitFor("https://other.com/", ErrBlocked)
+	waitFor("https://evil.com/", nil)
+
+	// an invalid file keeps the previous policy
+	write("deny everything\n", time.Now().Add(2*time.Minute))
+	time.Sleep(50 * time.Millisecond)
+	if Check("https://other.com/") != ErrBlocked {
+		t.Fatal("an invalid file should keep the previous policy")
+	}
+}
@@ -0,0 +1,43 @@
+package urlpolicy
+
+import (
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/lambrospetrou/spito/utils"
+)
+
+// LoadFile parses the policy of the file.
+func LoadFile(path string) (*Policy, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// WatchFile loads the policy of the file and reloads it whenever the file changes,
+// checking every interval. An invalid file keeps the previous policy in place.
+// The returned function stops watching.
+func WatchFile(path string, interval time.Duration) (func(), error) {
+	p, err := LoadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	Set(p)
+	blocked, allowed := p.Rules()
+	slog.Info("loaded the URL policy", "path", path, "block", blocked, "allow", allowed)
+
+	return utils.WatchFile(path, interval, func() {
+		p, err := LoadFile(path)
+		if err != nil {
+			slog.Error("could not reload the URL policy, keeping the previous one", "path", path, "err", err)
+			return
+		}
+		Set(p)
+		blocked, allowed := p.Rules()
+		slog.Info("reloaded the URL policy", "path", path, "block", blocked, "allow", allowed)
+	}), nil
+}
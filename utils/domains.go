@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"net/url"
+	"strings"
+)
+
+// NormalizeDomain lowercases the domain and strips the trailing dot of fully qualified names.
+func NormalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
+
+// URLHost returns the normalized host of the URL without its port, empty if it cannot be parsed.
+func URLHost(rawURL string) string {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return ""
+	}
+	return NormalizeDomain(u.Hostname())
+}
+
+// HostMatchesDomain checks whether the host is the domain or one of its subdomains.
+func HostMatchesDomain(host string, domain string) bool {
+	host, domain = NormalizeDomain(host), NormalizeDomain(domain)
+	if len(host) == 0 || len(domain) == 0 {
+		return false
+	}
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvString returns the value of the environment variable or the default value if it is not set.
+func EnvString(name string, defaultValue string) string {
+	if v := strings.TrimSpace(os.Getenv(name)); len(v) > 0 {
+		return v
+	}
+	return defaultValue
+}
+
+// EnvInt returns the integer value of the environment variable or the default value
+// if it is not set or invalid.
+func EnvInt(name string, defaultValue int) int {
+	v := EnvString(name, "")
+	if len(v) == 0 {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		slog.Warn("invalid environment variable, using the default", "name", name, "value", v, "default", defaultValue)
+		return defaultValue
+	}
+	return n
+}
+
+// EnvBool returns the boolean value of the environment variable or the default value
+// if it is not set or invalid.
+func EnvBool(name string, defaultValue bool) bool {
+	v := EnvString(name, "")
+	if len(v) == 0 {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		slog.Warn("invalid environment variable, using the default", "name", name, "value", v, "default", defaultValue)
+		return defaultValue
+	}
+	return b
+}
+
+// EnvDuration returns the duration value of the environment variable or the default value
+// if it is not set or invalid. Durations are parsed with ParseHumanDuration().
+func EnvDuration(name string, defaultValue time.Duration) time.Duration {
+	v := EnvString(name, "")
+	if len(v) == 0 {
+		return defaultValue
+	}
+	d, err := ParseHumanDuration(v)
+	if err != nil {
+		slog.Warn("invalid environment variable, using the default", "name", name, "value", v, "default", defaultValue)
+		return defaultValue
+	}
+	return d
+}
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context carrying the request id,
+// every record logged with that context gets a request_id attribute.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request id set by WithRequestID or an empty string.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// _requestIDHandler adds the request id of the context to the records
+type _requestIDHandler struct {
+	slog.Handler
+}
+
+func (h _requestIDHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestID(ctx); len(id) > 0 {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h _requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return _requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h _requestIDHandler) WithGroup(name string) slog.Handler {
+	return _requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+// NewLogger creates the logger configured by SPITO_LOG_LEVEL (debug, info, warn, error)
+// and SPITO_LOG_FORMAT (json or text).
+func NewLogger(w io.Writer) *slog.Logger {
+	level := slog.LevelInfo
+	if err := level.UnmarshalText([]byte(EnvString("SPITO_LOG_LEVEL", "info"))); err != nil {
+		level = slog.LevelInfo
+	}
+	options := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	if strings.ToLower(EnvString("SPITO_LOG_FORMAT", "json")) == "text" {
+		handler = slog.NewTextHandler(w, options)
+	} else {
+		handler = slog.NewJSONHandler(w, options)
+	}
+	return slog.New(_requestIDHandler{handler})
+}
+
+// SetupLogging makes the configured logger the default one,
+// including for the packages still using the log package.
+func SetupLogging() {
+	slog.SetDefault(NewLogger(os.Stderr))
+}
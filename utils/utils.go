@@ -1,17 +1,34 @@
 package utils
 
 import (
+	"context"
+	"errors"
+	"math"
 	"math/rand"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 	"unicode/utf8"
 )
 
 ////////////////// HELPERS /////////////////////////
-func IsUrl(u string) bool {
-	//resp, err := http.Get(u)
-	_, err := http.Head(u)
-	return err == nil
+// URL_CHECK_TIMEOUT is the maximum time IsUrl() waits for the URL to answer
+var URL_CHECK_TIMEOUT time.Duration = EnvDuration("SPITO_URL_CHECK_TIMEOUT", 5*time.Second)
+
+func IsUrl(ctx context.Context, u string) bool {
+	ctx, cancel := context.WithTimeout(ctx, URL_CHECK_TIMEOUT)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return true
 }
 
 func AbsoluteSpitoURL(subUrl string) string {
@@ -30,3 +47,57 @@ func ShuffleString(s string) string {
 	} // all characters processed
 	return string(rs)
 }
+
+var _HumanDurationUnits map[string]time.Duration = map[string]time.Duration{
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+}
+
+// ParseHumanDuration parses durations like time.ParseDuration() does
+// but also accepts days (d) and weeks (w), e.g. "7d", "1w2d", "1d12h30m".
+func ParseHumanDuration(s string) (time.Duration, error) {
+	orig := s
+	s = strings.TrimSpace(s)
+	if len(s) == 0 {
+		return 0, errors.New("utils: invalid duration " + strconv.Quote(orig))
+	}
+	var total time.Duration
+	for len(s) > 0 {
+		// split the next number and unit pair
+		i := 0
+		for i < len(s) && (s[i] == '.' || ('0' <= s[i] && s[i] <= '9')) {
+			i++
+		}
+		j := i
+		for j < len(s) && !(s[j] == '.' || ('0' <= s[j] && s[j] <= '9')) {
+			j++
+		}
+		if i == 0 || j == i {
+			return 0, errors.New("utils: invalid duration " + strconv.Quote(orig))
+		}
+		number, unit := s[:i], s[i:j]
+		s = s[j:]
+
+		if multiplier, ok := _HumanDurationUnits[unit]; ok {
+			n, err := strconv.ParseFloat(number, 64)
+			if err != nil || n*float64(multiplier) >= math.MaxInt64 {
+				return 0, errors.New("utils: invalid duration " + strconv.Quote(orig))
+			}
+			d := time.Duration(n * float64(multiplier))
+			if total > math.MaxInt64-d {
+				return 0, errors.New("utils: invalid duration " + strconv.Quote(orig))
+			}
+			total += d
+			continue
+		}
+		d, err := time.ParseDuration(number + unit)
+		if err != nil {
+			return 0, errors.New("utils: invalid duration " + strconv.Quote(orig))
+		}
+		if total > math.MaxInt64-d {
+			return 0, errors.New("utils: invalid duration " + strconv.Quote(orig))
+		}
+		total += d
+	}
+	return total, nil
+}
@@ -0,0 +1,60 @@
+package utils_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lambrospetrou/spito/utils"
+)
+
+func TestParseHumanDuration(t *testing.T) {
+	valid := map[string]time.Duration{
+		"90s":      90 * time.Second,
+		"1h30m":    90 * time.Minute,
+		"7d":       7 * 24 * time.Hour,
+		"1w":       7 * 24 * time.Hour,
+		"1d12h":    36 * time.Hour,
+		"1.5d":     36 * time.Hour,
+		"2w1d30m":  15*24*time.Hour + 30*time.Minute,
+		" 10m ":    10 * time.Minute,
+		"500ms":    500 * time.Millisecond,
+		"1d1h1m1s": 25*time.Hour + time.Minute + time.Second,
+	}
+	for s, expected := range valid {
+		d, err := utils.ParseHumanDuration(s)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", s, err)
+			continue
+		}
+		if d != expected {
+			t.Errorf("%q: expected %v, got %v", s, expected, d)
+		}
+	}
+
+	for _, s := range []string{"", "d", "10", "7x", "1d-", "h1", "1000000w", "2562047h2562047h"} {
+		if _, err := utils.ParseHumanDuration(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestHostMatchesDomain(t *testing.T) {
+	cases := []struct {
+		url      string
+		domain   string
+		expected bool
+	}{
+		{"https://evil.com/phish", "evil.com", true},
+		{"https://login.EVIL.com:8443/phish", "evil.com", true},
+		{"https://evil.com./phish", "Evil.com", true},
+		{"https://notevil.com/", "evil.com", false},
+		{"https://evil.com.example.org/", "evil.com", false},
+		{"not a url", "evil.com", false},
+		{"https://evil.com/", "", false},
+	}
+	for _, c := range cases {
+		if utils.HostMatchesDomain(utils.URLHost(c.url), c.domain) != c.expected {
+			t.Errorf("%q in %q should be %v", c.url, c.domain, c.expected)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"os"
+	"time"
+)
+
+// WatchFile calls onChange whenever the modification time of the file changes,
+// checking every interval. It returns a function that stops watching.
+func WatchFile(path string, interval time.Duration, onChange func()) func() {
+	done := make(chan struct{})
+	modTime := _ModTime(path)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			if current := _ModTime(path); !current.Equal(modTime) {
+				modTime = current
+				onChange()
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+func _ModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
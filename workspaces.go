@@ -0,0 +1,117 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/lambrospetrou/spito/spit"
+)
+
+type APIWorkspaceResult struct {
+	Id          string            `json:"id"`
+	Name        string            `json:"name"`
+	DateCreated string            `json:"date_created"`
+	Members     map[string]string `json:"members"`
+	Role        string            `json:"role"`
+}
+
+func newAPIWorkspaceResult(r *http.Request, ws *spit.Workspace) *APIWorkspaceResult {
+	return &APIWorkspaceResult{
+		Id: ws.Id, Name: ws.Name, DateCreated: ws.DateCreated, Members: ws.Members,
+		Role: ws.Members[requestOwner(r)],
+	}
+}
+
+// writeWorkspaceError() hides the workspaces from the owners outside them
+func writeWorkspaceError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case spit.ErrNotMember:
+		writeAPIError(w, r, http.StatusNotFound, "Workspace not found")
+	case spit.ErrRoleForbidden:
+		writeAPIError(w, r, http.StatusForbidden, err.Error())
+	default:
+		if _, notFound := err.(spit.DynamoDbItemNotFoundError); notFound {
+			writeAPIError(w, r, http.StatusNotFound, "Workspace not found")
+			return
+		}
+		slog.ErrorContext(r.Context(), "workspace request failed", "err", err)
+		writeInternalError(w, r)
+	}
+}
+
+func requireWorkspaceID(fn func(http.ResponseWriter, *http.Request, string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get(":workspace")
+		if !spit.ValidateWorkspaceId(id) {
+			writeAPIError(w, r, http.StatusNotFound, "Workspace not found")
+			return
+		}
+		fn(w, r, id)
+	}
+}
+
+// apiAddWorkspaceHandler() creates a workspace with the owner of the request as its admin
+func apiAddWorkspaceHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(r.FormValue("id"))
+	ws, err := spit.NewWorkspace(r.Context(), id, r.FormValue("name"), requestOwner(r))
+	if err != nil {
+		switch err {
+		case spit.ErrInvalidWorkspaceId:
+			writeAPIError(w, r, http.StatusBadRequest, err.Error())
+		case spit.ErrWorkspaceIdTaken:
+			writeAPIError(w, r, http.StatusConflict, err.Error())
+		default:
+			slog.ErrorContext(r.Context(), "workspace request failed", "err", err)
+			writeInternalError(w, r)
+		}
+		return
+	}
+	writeAPIResult(w, r, http.StatusCreated, newAPIWorkspaceResult(r, ws))
+}
+
+// apiViewWorkspaceHandler() returns the workspace and its members to any member
+func apiViewWorkspaceHandler(w http.ResponseWriter, r *http.Request, id string) {
+	ws, err := spit.LoadWorkspace(r.Context(), id, requestOwner(r), spit.ROLE_VIEWER)
+	if err != nil {
+		writeWorkspaceError(w, r, err)
+		return
+	}
+	writeAPIResult(w, r, http.StatusOK, newAPIWorkspaceResult(r, ws))
+}
+
+// apiSetMemberHandler() adds a member or changes its role, only admins can manage the members
+func apiSetMemberHandler(w http.ResponseWriter, r *http.Request, id string) {
+	setMemberRole(w, r, id, strings.TrimSpace(r.FormValue("role")))
+}
+
+// apiRemoveMemberHandler() removes a member from the workspace
+func apiRemoveMemberHandler(w http.ResponseWriter, r *http.Request, id string) {
+	setMemberRole(w, r, id, "")
+}
+
+func setMemberRole(w http.ResponseWriter, r *http.Request, id string, role string) {
+	member := strings.TrimSpace(r.URL.Query().Get(":member"))
+	if r.Method == http.MethodPut && !spit.ValidateRole(role) {
+		writeAPIError(w, r, http.StatusBadRequest, spit.ErrInvalidRole.Error())
+		return
+	}
+	ws, err := spit.LoadWorkspace(r.Context(), id, requestOwner(r), spit.ROLE_ADMIN)
+	if err != nil {
+		writeWorkspaceError(w, r, err)
+		return
+	}
+	if err := spit.SetMemberRole(r.Context(), ws, member, role); err != nil {
+		if err == spit.ErrLastAdmin || err == spit.ErrInvalidRole {
+			writeAPIError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err == spit.ErrWorkspaceChanged {
+			writeAPIError(w, r, http.StatusConflict, err.Error())
+			return
+		}
+		writeWorkspaceError(w, r, err)
+		return
+	}
+	writeAPIResult(w, r, http.StatusOK, newAPIWorkspaceResult(r, ws))
+}
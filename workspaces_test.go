@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/lambrospetrou/spito/spit"
+)
+
+// workspaceStorager only keeps the workspaces and the spits, the handlers under test use nothing else
+type workspaceStorager struct {
+	spit.Storager
+	workspaces map[string]*spit.Workspace
+	spits      map[string]*spit.Spit
+	// concurrent makes every change look like it raced with another admin
+	concurrent bool
+}
+
+func (s *workspaceStorager) GetWorkspace(ctx context.Context, id string) (*spit.Workspace, error) {
+	ws, ok := s.workspaces[id]
+	if !ok {
+		return nil, spit.DynamoDbItemNotFoundError{}
+	}
+	c := *ws
+	c.Members = make(map[string]string)
+	for member, role := range ws.Members {
+		c.Members[member] = role
+	}
+	return &c, nil
+}
+
+func (s *workspaceStorager) SetWorkspaceMember(ctx context.Context, workspaceId string, version int, memberId string, role string) error {
+	ws := s.workspaces[workspaceId]
+	if ws.Version != version || s.concurrent {
+		return spit.ErrWorkspaceChanged
+	}
+	if len(role) == 0 {
+		delete(ws.Members, memberId)
+	} else {
+		ws.Members[memberId] = role
+	}
+	ws.Version++
+	return nil
+}
+
+func (s *workspaceStorager) Get(ctx context.Context, id string) (*spit.Spit, error) {
+	found, ok := s.spits[id]
+	if !ok {
+		return nil, spit.DynamoDbItemNotFoundError{}
+	}
+	c := *found
+	return &c, nil
+}
+
+func withWorkspaceStorager(t *testing.T) *workspaceStorager {
+	s := &workspaceStorager{
+		workspaces: map[string]*spit.Workspace{"acme": {Id: "acme", Members: map[string]string{
+			"alice": spit.ROLE_ADMIN, "bob": spit.ROLE_EDITOR, "carol": spit.ROLE_VIEWER,
+		}}},
+		spits: map[string]*spit.Spit{"abc": {Id: "abc", WorkspaceId: "acme", SpitType: spit.SPIT_TYPE_TEXT, Content: "hi"}},
+	}
+	spit.InitWith(s)
+	t.Cleanup(func() { spit.InitWith(nil) })
+	return s
+}
+
+func asOwner(r *http.Request, owner string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), ownerKey{}, owner))
+}
+
+func TestSetMemberRoleChecks(t *testing.T) {
+	cases := []struct {
+		owner    string
+		method   string
+		member   string
+		role     string
+		expected int
+	}{
+		{"dave", http.MethodPut, "erin", spit.ROLE_VIEWER, http.StatusNotFound},
+		{"carol", http.MethodPut, "erin", spit.ROLE_VIEWER, http.StatusForbidden},
+		{"bob", http.MethodPut, "erin", spit.ROLE_VIEWER, http.StatusForbidden},
+		{"alice", http.MethodPut, "erin", "owner", http.StatusBadRequest},
+		{"alice", http.MethodPut, "alice", spit.ROLE_EDITOR, http.StatusBadRequest},
+		{"alice", http.MethodDelete, "alice", "", http.StatusBadRequest},
+		{"alice", http.MethodPut, "erin", spit.ROLE_VIEWER, http.StatusOK},
+		{"alice", http.MethodDelete, "carol", "", http.StatusOK},
+	}
+	for _, c := range cases {
+		s := withWorkspaceStorager(t)
+		form := url.Values{"role": {c.role}}
+		r := httptest.NewRequest(c.method, "/api/v1/workspaces/acme/members/"+c.member, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.URL.RawQuery = url.Values{":member": {c.member}}.Encode()
+		w := httptest.NewRecorder()
+		if c.method == http.MethodPut {
+			apiSetMemberHandler(w, asOwner(r, c.owner), "acme")
+		} else {
+			apiRemoveMemberHandler(w, asOwner(r, c.owner), "acme")
+		}
+		if w.Code != c.expected {
+			t.Errorf("%v %v %v as %v: expected %v, got %v %v", c.method, c.member, c.role, c.owner, c.expected, w.Code, w.Body)
+		}
+		if changed := s.workspaces["acme"].Version > 0; changed != (c.expected == http.StatusOK) {
+			t.Errorf("%v %v %v as %v: the members should only change on success", c.method, c.member, c.role, c.owner)
+		}
+	}
+}
+
+func TestSetMemberRoleConflict(t *testing.T) {
+	s := withWorkspaceStorager(t)
+	s.concurrent = true
+	r := httptest.NewRequest(http.MethodDelete, "/api/v1/workspaces/acme/members/bob?:member=bob", nil)
+	w := httptest.NewRecorder()
+	apiRemoveMemberHandler(w, asOwner(r, "alice"), "acme")
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected 409, got %v", w.Code)
+	}
+}
+
+func TestEditRoleChecks(t *testing.T) {
+	cases := map[string]int{
+		"dave":  http.StatusNotFound,
+		"carol": http.StatusForbidden,
+	}
+	for owner, expected := range cases {
+		withWorkspaceStorager(t)
+		r := httptest.NewRequest(http.MethodPut, "/api/v1/spits/abc", strings.NewReader("content=bye"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		apiEditHandler(w, asOwner(r, owner), "abc")
+		if w.Code != expected {
+			t.Errorf("%v: expected %v, got %v", owner, expected, w.Code)
+		}
+	}
+}